@@ -0,0 +1,196 @@
+package amogus
+
+import (
+	"amogus/config"
+	"amogus/fsys"
+	"amogus/mp3util"
+	"amogus/schema"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+/*
+Anti-entropy: with every other replica, we compare a Merkle tree (see fsys/merkle.go) of the files placement puts on
+both of us. If the roots match that's one round trip and we're done; otherwise we walk down the subtrees that differ, a
+level at a time, and only the leaves that still differ get their contents swapped. We push the versions we have that
+the peer doesn't (it does the same to us on its own round), and take up deletes that it heard about and we didn't.
+*/
+
+type merkleKey struct {
+	peer  string
+	depth int
+}
+
+/*
+The trees we've built, which are good until the journal moves on or a new membership epoch is published.
+*/
+type merkleCache struct {
+	seq   int64
+	epoch uint64
+	trees map[merkleKey]*fsys.MerkleTree
+	mtx   sync.Mutex
+}
+
+/*
+Our tree of the files that placement puts on both us and peer (a member ID).
+*/
+func (r *ReplicaService) merkleTree(peer string, depth int) *fsys.MerkleTree {
+	r.merkle.mtx.Lock()
+	defer r.merkle.mtx.Unlock()
+	seq := r.sdfs.JournalSeq()
+	membership := schema.Membership()
+	if r.merkle.trees == nil || r.merkle.seq != seq || r.merkle.epoch != membership.Epoch {
+		r.merkle.trees = make(map[merkleKey]*fsys.MerkleTree)
+		r.merkle.seq = seq
+		r.merkle.epoch = membership.Epoch
+	}
+	key := merkleKey{peer: peer, depth: depth}
+	if tree, ok := r.merkle.trees[key]; ok {
+		return tree
+	}
+	self := membership.Self.Member_Id
+	placement := schema.ConfiguredPlacement()
+	entries, tombstones, _ := r.sdfs.MerkleEntries(func(sdfsFileName string) bool {
+		ours, theirs := false, false
+		for _, m := range placement.Place(sdfsFileName, membership) {
+			ours = ours || m.Member_Id == self
+			theirs = theirs || m.Member_Id == peer
+		}
+		return ours && theirs
+	})
+	tree := fsys.BuildMerkleTree(entries, tombstones, depth)
+	r.merkle.trees[key] = tree
+	return tree
+}
+
+/*
+Hands out hashes of (REPLICA_MERKLE_NODES) or everything in (REPLICA_MERKLE_LEAVES) parts of our tree of the files we
+share with the asking replica.
+*/
+func (r *ReplicaService) DataConnHandleREPLICAMERKLE(conn net.Conn, req fsys.TCPChannelRequest) error {
+	defer conn.Close()
+	tree := r.merkleTree(req.MerklePeer, req.MerkleDepth)
+	resp := &fsys.TCPChannelResponse{ResponseCode: fsys.OK}
+	if req.RequestType == fsys.REPLICA_MERKLE_LEAVES {
+		resp.MerkleEntries, resp.MerkleTombstones = tree.LeafContents(req.MerkleNodes)
+	} else {
+		resp.MerkleHashes = tree.Hashes(req.MerkleNodes)
+	}
+	return handleTCPChannelRequestErr(resp.Send(conn))
+}
+
+/*
+One round of anti-entropy with every other member. The ones we don't share any files with have empty trees on both
+sides, so that's just one round trip.
+*/
+func (r *ReplicaService) AntiEntropy() error {
+	r.antiEntropyMtx.Lock()
+	defer r.antiEntropyMtx.Unlock()
+	membership := schema.Membership()
+	self := replicaMetadataOf(membership.Self)
+	for _, m := range membership.Members {
+		peer := replicaMetadataOf(m)
+		if peer == self {
+			continue
+		}
+		err := r.reconcile(peer)
+		if err != nil {
+			mp3util.NodeLogger.Warnf("Anti-entropy with %v failed: %v", peer.MemberId, err)
+		}
+	}
+	return nil
+}
+
+func (r *ReplicaService) reconcile(peer ReplicaMetadata) error {
+	tree := r.merkleTree(peer.MemberId, config.MERKLE_DEPTH)
+	req := &fsys.TCPChannelRequest{
+		RequestType: fsys.REPLICA_MERKLE_NODES,
+		MerklePeer:  selfReplicaMetadata().MemberId,
+		MerkleDepth: tree.Depth,
+	}
+
+	/* Walk down from the root, one level per round trip, only into the subtrees that differ */
+	var leaves []int
+	for nodes := []int{1}; len(nodes) > 0; {
+		req.MerkleNodes = nodes
+		resp, err := UnicastToReplica(req, peer)
+		if err != nil {
+			return err
+		}
+		if len(resp.MerkleHashes) != len(nodes) {
+			return errors.New(fmt.Sprintf("asked for %v hashes, got %v", len(nodes), len(resp.MerkleHashes)))
+		}
+		nodes = nil
+		for i, n := range req.MerkleNodes {
+			if resp.MerkleHashes[i] == tree.Nodes[n] {
+				continue
+			}
+			if leaf, ok := tree.LeafIndex(n); ok {
+				leaves = append(leaves, leaf)
+			} else {
+				nodes = append(nodes, 2*n, 2*n+1)
+			}
+		}
+	}
+	if len(leaves) == 0 {
+		return nil
+	}
+
+	req.RequestType = fsys.REPLICA_MERKLE_LEAVES
+	req.MerkleNodes = leaves
+	resp, err := UnicastToReplica(req, peer)
+	if err != nil {
+		return err
+	}
+	theirs := make(map[fsys.SDFSFile]string)
+	for _, e := range resp.MerkleEntries {
+		theirs[fsys.SDFSFile{SDFSFileName: e.SDFSFileName, Version: e.Version}] = e.ContentHash
+	}
+	ours, _ := tree.LeafContents(leaves)
+
+	/* Deletes they've seen and we haven't. Otherwise they'd never take the deleted versions and we'd be at this forever. */
+	deleted := make(map[string]bool)
+	for _, e := range ours {
+		theirTombstone := resp.MerkleTombstones[e.SDFSFileName]
+		if e.Version > theirTombstone || deleted[e.SDFSFileName] {
+			continue
+		}
+		if _, ourTombstone := r.sdfs.History(e.SDFSFileName); ourTombstone >= theirTombstone {
+			continue
+		}
+		mp3util.NodeLogger.Infof("Replica %v saw %v deleted @ %v, so deleting it here too", peer.MemberId, e.SDFSFileName, theirTombstone)
+		_, err := r.sdfs.RemoveSDFSFile(e.SDFSFileName, theirTombstone)
+		if err != nil {
+			mp3util.NodeLogger.Warnf("Couldn't delete %v @ %v: %v", e.SDFSFileName, theirTombstone, err)
+		}
+		deleted[e.SDFSFileName] = true
+	}
+
+	/* And versions we have that they don't */
+	missing := make(fsys.SDFSFileVersionSet)
+	numMissing := 0
+	for _, e := range ours {
+		if deleted[e.SDFSFileName] || e.Version <= resp.MerkleTombstones[e.SDFSFileName] {
+			continue
+		}
+		hash, ok := theirs[fsys.SDFSFile{SDFSFileName: e.SDFSFileName, Version: e.Version}]
+		if ok {
+			if hash != e.ContentHash {
+				mp3util.NodeLogger.Warnf("Replica %v and we disagree on what %v @ %v is (%v vs ours %v)", peer.MemberId, e.SDFSFileName, e.Version, hash, e.ContentHash)
+			}
+			continue
+		}
+		if _, ok := missing[e.SDFSFileName]; !ok {
+			missing[e.SDFSFileName] = make(map[int64]bool)
+		}
+		missing[e.SDFSFileName][e.Version] = true
+		numMissing++
+	}
+	if numMissing == 0 {
+		return nil
+	}
+	mp3util.NodeLogger.Infof("Anti-entropy: %v of %v leaves differ with replica %v, offering it %v versions", len(leaves), len(tree.Leaves), peer.MemberId, numMissing)
+	return r.offerFileSetToReplica(missing, peer)
+}
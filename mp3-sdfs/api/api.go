@@ -34,7 +34,8 @@ func IssueMP2Command(cmd string) (*http.Response, error) {
 /**
  * IssueMP3Command
  *	Issue POST request to mp3 module, for given command.
- *	@param opcode - one of "getlist", "putfile", "deletefile", "ls", "store"
+ *	@param opcode - one of "getlist", "putfile", "deletefile", "ls", "store", "history", "getrange", "ownership",
+ *		"mkdir", "rmdir", "mv", "lsall", "stat", "setattr", "diffversions"
  *	@return resp - http response from mp3 module
  */
 func IssueMP3Command(opcode string, args schema.CliArgs) (*http.Response, error) {
@@ -113,12 +114,12 @@ func clientHandler(w http.ResponseWriter, r *http.Request, cb clientCallback) er
 /**
  * GetMembershipChanges
  *	Issue LISTMEM to MP2, LISTSELF to MP2.
- *	Update the global membership list/self id known to mp3.
+ *	Publish what they say as the next membership snapshot (see schema/membership.go).
+ *	Nothing is published unless both succeed.
  */
 func GetMembershipChanges() error {
-	memList := &schema.MemList
-	memList.Mtx.Lock()
-	defer memList.Mtx.Unlock()
+	var members []schema.Member
+	var self schema.Member
 
 	/* Get membership list from MP2 using listmem command */
 	resp, err := IssueMP2Command("listmem")
@@ -126,11 +127,11 @@ func GetMembershipChanges() error {
 		return err
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&memList.List)
+	err = json.NewDecoder(resp.Body).Decode(&members)
 	if err != nil {
 		return err
 	}
-	mp3util.NodeLogger.Info("Received member(s): ", memList.List)
+	mp3util.NodeLogger.Info("Received member(s): ", members)
 
 	/* Get self ID from MP2 using listself command */
 	resp, err = IssueMP2Command("listself")
@@ -138,12 +139,14 @@ func GetMembershipChanges() error {
 		return err
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&memList.SelfNode)
+	err = json.NewDecoder(resp.Body).Decode(&self)
 	if err != nil {
 		return err
 	}
-	mp3util.NodeLogger.Info("Received self ID: ", memList.SelfNode.Member_Id)
+	mp3util.NodeLogger.Info("Received self ID: ", self.Member_Id)
 
+	membership := schema.PublishMembership(members, self)
+	mp3util.NodeLogger.Infof("Membership is at epoch %v", membership.Epoch)
 	return nil
 }
 
@@ -220,6 +223,7 @@ func membershipUpdateLoop(master *amogus.MasterGRPCService, replica *amogus.Repl
  *	@param replica - pointer to replica object
  */
 func RunAPI(master *amogus.MasterGRPCService, replica *amogus.ReplicaService, done chan bool) {
+	election := replica.Election() // Clients look up who to talk to here
 
 	/* Start MP2 notification channel. See membershipUpdateLoop */
 	mp2chan := make(chan bool)
@@ -241,7 +245,7 @@ func RunAPI(master *amogus.MasterGRPCService, replica *amogus.ReplicaService, do
 				mp3util.NodeLogger.Infof("Getfile took %v usec to complete\n", delta.Microseconds())
 			}(startTime)
 		}
-		client, err := amogus.NewClient()
+		client, err := amogus.NewClient(election)
 		if err != nil {
 			mp3util.NodeLogger.Debug("Could not start client: ", err)
 			w.WriteHeader(500)
@@ -267,7 +271,7 @@ func RunAPI(master *amogus.MasterGRPCService, replica *amogus.ReplicaService, do
 				mp3util.NodeLogger.Infof("Putfile took %v usec to complete\n", delta.Microseconds())
 			}(startTime)
 		}
-		client, err := amogus.NewClient()
+		client, err := amogus.NewClient(election)
 		if err != nil {
 			mp3util.NodeLogger.Debug("Could not start client: ", err)
 			w.WriteHeader(500)
@@ -285,7 +289,7 @@ func RunAPI(master *amogus.MasterGRPCService, replica *amogus.ReplicaService, do
 
 	http.HandleFunc("/mp3/ls", func(w http.ResponseWriter, r *http.Request) {
 		mp3util.NodeLogger.Debug("Entered /ls handler")
-		client, err := amogus.NewClient()
+		client, err := amogus.NewClient(election)
 		if err != nil {
 			mp3util.NodeLogger.Debug("Could not start client: ", err)
 			w.WriteHeader(500)
@@ -303,7 +307,7 @@ func RunAPI(master *amogus.MasterGRPCService, replica *amogus.ReplicaService, do
 
 	http.HandleFunc("/mp3/store", func(w http.ResponseWriter, r *http.Request) {
 		mp3util.NodeLogger.Debug("Entered /store handler")
-		client, err := amogus.NewClient()
+		client, err := amogus.NewClient(election)
 		if err != nil {
 			mp3util.NodeLogger.Debug("Could not start client: ", err)
 			w.WriteHeader(500)
@@ -319,9 +323,63 @@ func RunAPI(master *amogus.MasterGRPCService, replica *amogus.ReplicaService, do
 		}
 	})
 
+	http.HandleFunc("/mp3/history", func(w http.ResponseWriter, r *http.Request) {
+		mp3util.NodeLogger.Debug("Entered /history handler")
+		client, err := amogus.NewClient(election)
+		if err != nil {
+			mp3util.NodeLogger.Debug("Could not start client: ", err)
+			w.WriteHeader(500)
+			return
+		}
+		defer client.Close()
+
+		err = clientHandler(w, r, client.History)
+		if err != nil {
+			mp3util.NodeLogger.Error("history error: ", err)
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "history error: %v", err.Error())
+		}
+	})
+
+	http.HandleFunc("/mp3/ownership", func(w http.ResponseWriter, r *http.Request) {
+		mp3util.NodeLogger.Debug("Entered /ownership handler")
+		client, err := amogus.NewClient(election)
+		if err != nil {
+			mp3util.NodeLogger.Debug("Could not start client: ", err)
+			w.WriteHeader(500)
+			return
+		}
+		defer client.Close()
+
+		err = clientHandler(w, r, client.Ownership)
+		if err != nil {
+			mp3util.NodeLogger.Error("ownership error: ", err)
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "ownership error: %v", err.Error())
+		}
+	})
+
+	http.HandleFunc("/mp3/getrange", func(w http.ResponseWriter, r *http.Request) {
+		mp3util.NodeLogger.Debug("Entered /getrange handler")
+		client, err := amogus.NewClient(election)
+		if err != nil {
+			mp3util.NodeLogger.Debug("Could not start client: ", err)
+			w.WriteHeader(500)
+			return
+		}
+		defer client.Close()
+
+		err = clientHandler(w, r, client.GetRange)
+		if err != nil {
+			mp3util.NodeLogger.Error("getrange error: ", err)
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "getrange error: %v", err.Error())
+		}
+	})
+
 	http.HandleFunc("/mp3/deletefile", func(w http.ResponseWriter, r *http.Request) {
 		mp3util.NodeLogger.Debug("Entered /mp3/deletefile handler")
-		client, err := amogus.NewClient()
+		client, err := amogus.NewClient(election)
 		if err != nil {
 			mp3util.NodeLogger.Debug("Could not start client: ", err)
 			w.WriteHeader(500)
@@ -337,6 +395,135 @@ func RunAPI(master *amogus.MasterGRPCService, replica *amogus.ReplicaService, do
 		}
 	})
 
+	http.HandleFunc("/mp3/mkdir", func(w http.ResponseWriter, r *http.Request) {
+		mp3util.NodeLogger.Debug("Entered /mp3/mkdir handler")
+		client, err := amogus.NewClient(election)
+		if err != nil {
+			mp3util.NodeLogger.Debug("Could not start client: ", err)
+			w.WriteHeader(500)
+			return
+		}
+		defer client.Close()
+
+		err = clientHandler(w, r, client.Mkdir)
+		if err != nil {
+			mp3util.NodeLogger.Error("mkdir error: ", err)
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "mkdir error: %v", err.Error())
+		}
+	})
+
+	http.HandleFunc("/mp3/rmdir", func(w http.ResponseWriter, r *http.Request) {
+		mp3util.NodeLogger.Debug("Entered /mp3/rmdir handler")
+		client, err := amogus.NewClient(election)
+		if err != nil {
+			mp3util.NodeLogger.Debug("Could not start client: ", err)
+			w.WriteHeader(500)
+			return
+		}
+		defer client.Close()
+
+		err = clientHandler(w, r, client.Rmdir)
+		if err != nil {
+			mp3util.NodeLogger.Error("rmdir error: ", err)
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "rmdir error: %v", err.Error())
+		}
+	})
+
+	http.HandleFunc("/mp3/mv", func(w http.ResponseWriter, r *http.Request) {
+		mp3util.NodeLogger.Debug("Entered /mp3/mv handler")
+		client, err := amogus.NewClient(election)
+		if err != nil {
+			mp3util.NodeLogger.Debug("Could not start client: ", err)
+			w.WriteHeader(500)
+			return
+		}
+		defer client.Close()
+
+		err = clientHandler(w, r, client.Move)
+		if err != nil {
+			mp3util.NodeLogger.Error("mv error: ", err)
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "mv error: %v", err.Error())
+		}
+	})
+
+	http.HandleFunc("/mp3/lsall", func(w http.ResponseWriter, r *http.Request) {
+		mp3util.NodeLogger.Debug("Entered /mp3/lsall handler")
+		client, err := amogus.NewClient(election)
+		if err != nil {
+			mp3util.NodeLogger.Debug("Could not start client: ", err)
+			w.WriteHeader(500)
+			return
+		}
+		defer client.Close()
+
+		err = clientHandler(w, r, client.ListFiles)
+		if err != nil {
+			mp3util.NodeLogger.Error("lsall error: ", err)
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "lsall error: %v", err.Error())
+		}
+	})
+
+	http.HandleFunc("/mp3/stat", func(w http.ResponseWriter, r *http.Request) {
+		mp3util.NodeLogger.Debug("Entered /mp3/stat handler")
+		client, err := amogus.NewClient(election)
+		if err != nil {
+			mp3util.NodeLogger.Debug("Could not start client: ", err)
+			w.WriteHeader(500)
+			return
+		}
+		defer client.Close()
+
+		err = clientHandler(w, r, client.Stat)
+		if err != nil {
+			mp3util.NodeLogger.Error("stat error: ", err)
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "stat error: %v", err.Error())
+		}
+	})
+
+	http.HandleFunc("/mp3/setattr", func(w http.ResponseWriter, r *http.Request) {
+		mp3util.NodeLogger.Debug("Entered /mp3/setattr handler")
+		client, err := amogus.NewClient(election)
+		if err != nil {
+			mp3util.NodeLogger.Debug("Could not start client: ", err)
+			w.WriteHeader(500)
+			return
+		}
+		defer client.Close()
+
+		err = clientHandler(w, r, client.SetAttr)
+		if err != nil {
+			mp3util.NodeLogger.Error("setattr error: ", err)
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "setattr error: %v", err.Error())
+		}
+	})
+
+	http.HandleFunc("/mp3/diffversions", func(w http.ResponseWriter, r *http.Request) {
+		mp3util.NodeLogger.Debug("Entered /mp3/diffversions handler")
+		client, err := amogus.NewClient(election)
+		if err != nil {
+			mp3util.NodeLogger.Debug("Could not start client: ", err)
+			w.WriteHeader(500)
+			return
+		}
+		defer client.Close()
+
+		// The diff goes back to the CLI in the response body.
+		err = clientHandler(w, r, func(args schema.CliArgs) error {
+			return client.DiffVersions(args, w)
+		})
+		if err != nil {
+			mp3util.NodeLogger.Error("diffversions error: ", err)
+			w.WriteHeader(500)
+			fmt.Fprintf(w, "diffversions error: %v", err.Error())
+		}
+	})
+
 	http.HandleFunc("/mp3/getversions", func(w http.ResponseWriter, r *http.Request) {
 		mp3util.NodeLogger.Debug("Entered /getversions handler")
 		if config.COLLECT_STATS {
@@ -347,7 +534,7 @@ func RunAPI(master *amogus.MasterGRPCService, replica *amogus.ReplicaService, do
 				mp3util.NodeLogger.Infof("Getversions took %v usec to complete\n", delta.Microseconds())
 			}(startTime)
 		}
-		client, err := amogus.NewClient()
+		client, err := amogus.NewClient(election)
 		if err != nil {
 			mp3util.NodeLogger.Debug("Could not start client: ", err)
 			w.WriteHeader(500)
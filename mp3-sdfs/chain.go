@@ -0,0 +1,256 @@
+package amogus
+
+import (
+	"amogus/config"
+	"amogus/fsys"
+	"amogus/mp3util"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+Chain replication for puts. The client uploads to one replica only, and hands it the rest of the replicas as a chain.
+As every chunk comes in, that replica streams it on to the next replica in the chain while it stores it, that one
+streams it to the one after, and so on. The client pays for one upload instead of one per replica, and the chunk
+reaches the end of the chain about as fast as it would have reached the first replica.
+
+An upload that started out as a chain only stays one while every hop keeps up. If the next replica fails a chunk, drops
+off, or can't be resumed in step with us, we cut the chain there and carry on by ourselves; the commit tells the client
+how far down the chain the upload made it, and the client sends it to everyone past that the normal way.
+*/
+type uploadChain struct {
+	next     fsys.ReplicaAddr
+	uploadID string   // The upload's ID on next
+	conn     net.Conn // Open while we're in the middle of a stream of chunks, nil otherwise
+	mtx      sync.Mutex
+}
+
+/*
+Passes whatever we read off the client on to the next hop. A failed write there mustn't fail our own copy, so it just
+gets remembered.
+*/
+type chainWriter struct {
+	conn net.Conn
+	err  error
+}
+
+func (w *chainWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.conn.Write(p)
+	}
+	return len(p), nil
+}
+
+/*
+Begins the upload on the first hop of chain (telling it about the rest), and remembers it as where upload id goes next.
+*/
+func (r *ReplicaService) beginChain(id string, req fsys.TCPChannelRequest) {
+	next := req.Chain[0]
+	resp, err := uploadRequest(&fsys.TCPChannelRequest{
+		RequestType:  fsys.CLIENT_UPLOAD_BEGIN,
+		SDFSFileName: req.SDFSFileName,
+		FileSize:     req.FileSize,
+		Chain:        req.Chain[1:],
+	}, ReplicaMetadata{Address: next.Address, MemberId: next.MemberId})
+	if err == nil && resp.ResponseCode != fsys.OK {
+		err = errors.New(fmt.Sprintf("replica responded %v", resp.ResponseCode))
+	}
+	if err != nil {
+		mp3util.NodeLogger.Warnf("Couldn't chain upload %v on to replica with ID=%v, the client will have to: %v", id, next.MemberId, err)
+		return
+	}
+	r.chainsMtx.Lock()
+	r.chains[id] = &uploadChain{next: next, uploadID: resp.UploadID}
+	r.chainsMtx.Unlock()
+}
+
+func (r *ReplicaService) lookupChain(id string) *uploadChain {
+	r.chainsMtx.Lock()
+	defer r.chainsMtx.Unlock()
+	return r.chains[id]
+}
+
+/*
+Stops forwarding upload id. Whatever the next hop has of it expires there eventually. Call with chain.mtx held.
+*/
+func (r *ReplicaService) cutChain(id string, chain *uploadChain, reason string) {
+	mp3util.NodeLogger.Warnf("Cutting upload %v off from replica with ID=%v: %v", id, chain.next.MemberId, reason)
+	r.forgetChain(id, chain)
+}
+
+func (r *ReplicaService) forgetChain(id string, chain *uploadChain) {
+	chain.hangUp()
+	r.chainsMtx.Lock()
+	if r.chains[id] == chain {
+		delete(r.chains, id)
+	}
+	r.chainsMtx.Unlock()
+}
+
+func (chain *uploadChain) hangUp() {
+	if chain.conn != nil {
+		chain.conn.Close()
+		chain.conn = nil
+	}
+}
+
+/*
+Makes sure the next hop is at the same offset we are before the client resumes, or cuts it off.
+*/
+func (r *ReplicaService) resumeChain(id string, offset int64) {
+	chain := r.lookupChain(id)
+	if chain == nil {
+		return
+	}
+	chain.mtx.Lock()
+	defer chain.mtx.Unlock()
+	chain.hangUp()
+	resp, err := uploadRequest(&fsys.TCPChannelRequest{RequestType: fsys.CLIENT_UPLOAD_RESUME, UploadID: chain.uploadID},
+		ReplicaMetadata{Address: chain.next.Address, MemberId: chain.next.MemberId})
+	if err != nil {
+		r.cutChain(id, chain, err.Error())
+	} else if resp.ResponseCode != fsys.OK {
+		r.cutChain(id, chain, fmt.Sprintf("it responded %v to resuming", resp.ResponseCode))
+	} else if resp.UploadOffset != offset {
+		r.cutChain(id, chain, fmt.Sprintf("it's at %v, we're at %v", resp.UploadOffset, offset))
+	}
+}
+
+/*
+Takes one chunk of upload id off source, storing it and streaming it down the chain at the same time. Same contract as
+LocalSDFSStorage.WriteUploadChunk, which does the storing.
+*/
+func (r *ReplicaService) writeChainedUploadChunk(req fsys.TCPChannelRequest, source io.Reader) (int64, error) {
+	chain := r.lookupChain(req.UploadID)
+	if chain == nil {
+		return r.sdfs.WriteUploadChunk(req.UploadID, req.ChunkOffset, req.FileSize, req.ChunkChecksum, source)
+	}
+	chain.mtx.Lock()
+	defer chain.mtx.Unlock()
+
+	var err error
+	if chain.conn == nil {
+		chain.conn, err = net.DialTimeout("tcp", fmt.Sprintf("%v:%v", chain.next.Address, config.MP3_REPLICA_TCP_PORT), config.DEFAULT_TCP_TIMEOUT)
+		if err != nil {
+			chain.conn = nil
+			r.cutChain(req.UploadID, chain, err.Error())
+			return r.sdfs.WriteUploadChunk(req.UploadID, req.ChunkOffset, req.FileSize, req.ChunkChecksum, source)
+		}
+	}
+	chain.conn.SetDeadline(time.Now().Add(config.UPLOAD_CHUNK_TIMEOUT))
+	forward := req
+	forward.UploadID = chain.uploadID
+	downstream := &chainWriter{conn: chain.conn}
+	downstream.err = forward.Send(chain.conn)
+
+	offset, err := r.sdfs.WriteUploadChunk(req.UploadID, req.ChunkOffset, req.FileSize, req.ChunkChecksum, io.TeeReader(source, downstream))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// The next hop only got part of the chunk too. Hanging up makes it drop it, same as we are.
+		chain.hangUp()
+		return offset, err
+	}
+	if downstream.err != nil {
+		r.cutChain(req.UploadID, chain, downstream.err.Error())
+		return offset, err
+	}
+	resp, recvErr := fsys.RecvTCPChannelResponseAnyCode(chain.conn)
+	if recvErr != nil {
+		r.cutChain(req.UploadID, chain, recvErr.Error())
+	} else if resp.UploadOffset != offset {
+		r.cutChain(req.UploadID, chain, fmt.Sprintf("it's at %v (%v), we're at %v", resp.UploadOffset, resp.ResponseCode, offset))
+	}
+	return offset, err
+}
+
+/*
+Called when a stream of chunks ends, so we don't hold a connection to the next hop open while the client is away.
+*/
+func (r *ReplicaService) pauseChain(id string) {
+	chain := r.lookupChain(id)
+	if chain == nil {
+		return
+	}
+	chain.mtx.Lock()
+	chain.hangUp()
+	chain.mtx.Unlock()
+}
+
+/*
+Commits upload id down the chain. Returns how many hops (not counting us) committed it, and the tmpfile name they
+committed it as, which had better be the same as ours.
+*/
+func (r *ReplicaService) commitChain(id string) (int, string) {
+	chain := r.lookupChain(id)
+	if chain == nil {
+		return 0, ""
+	}
+	chain.mtx.Lock()
+	defer chain.mtx.Unlock()
+	defer r.forgetChain(id, chain)
+
+	commit := &fsys.TCPChannelRequest{RequestType: fsys.CLIENT_UPLOAD_COMMIT, UploadID: chain.uploadID}
+	var resp *fsys.TCPChannelResponse
+	var err error
+	if chain.conn != nil {
+		chain.conn.SetDeadline(time.Now().Add(config.UPLOAD_CHUNK_TIMEOUT))
+		err = commit.Send(chain.conn)
+		if err == nil {
+			resp, err = fsys.RecvTCPChannelResponseAnyCode(chain.conn)
+		}
+	} else {
+		resp, err = uploadRequest(commit, ReplicaMetadata{Address: chain.next.Address, MemberId: chain.next.MemberId})
+	}
+	if err == nil && resp.ResponseCode != fsys.OK {
+		err = errors.New(fmt.Sprintf("replica responded %v", resp.ResponseCode))
+	}
+	if err != nil {
+		mp3util.NodeLogger.Warnf("Couldn't commit upload %v on replica with ID=%v: %v", id, chain.next.MemberId, err)
+		return 0, ""
+	}
+	return resp.ChainCommitted, resp.FileContentHash
+}
+
+/*
+Passes an abort down the chain, if upload id has one.
+*/
+func (r *ReplicaService) abortChain(id string) {
+	chain := r.lookupChain(id)
+	if chain == nil {
+		return
+	}
+	chain.mtx.Lock()
+	defer chain.mtx.Unlock()
+	r.forgetChain(id, chain)
+	_, err := uploadRequest(&fsys.TCPChannelRequest{RequestType: fsys.CLIENT_UPLOAD_ABORT, UploadID: chain.uploadID},
+		ReplicaMetadata{Address: chain.next.Address, MemberId: chain.next.MemberId})
+	if err != nil {
+		mp3util.NodeLogger.Infof("Couldn't pass abort of upload %v on to replica with ID=%v, it'll expire there: %v", id, chain.next.MemberId, err)
+	}
+}
+
+/*
+Forgets chains whose upload is gone (expired, or the client never came back to commit).
+*/
+func (r *ReplicaService) dropStaleChains() {
+	r.chainsMtx.Lock()
+	var stale []string
+	for id := range r.chains {
+		if _, err := r.sdfs.UploadOffset(id); err != nil {
+			stale = append(stale, id)
+		}
+	}
+	r.chainsMtx.Unlock()
+	for _, id := range stale {
+		chain := r.lookupChain(id)
+		if chain == nil {
+			continue
+		}
+		chain.mtx.Lock()
+		r.cutChain(id, chain, "upload expired")
+		chain.mtx.Unlock()
+	}
+}
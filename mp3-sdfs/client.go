@@ -6,19 +6,29 @@ import (
 	"amogus/mp3util"
 	"amogus/proto"
 	"amogus/schema"
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
 	"io"
 	"log"
+	"mime"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -39,24 +49,30 @@ func (c *Client) Close() {
 
 /**
  * NewClient
- *	Creates a client, connecting to the current master node.
+ *	Creates a client, connecting to the current master node, i.e. whoever
+ *	won the latest election this node has heard about.
+ *	@param election - this node's side of the master election
  *	@return c - new client object
  */
-func NewClient() (*Client, error) {
-	memList := &schema.MemList
-	memList.Mtx.Lock()
-	defer memList.Mtx.Unlock()
-	mater, err := memList.CurrMasterNode()
-	if err != nil {
+func NewClient(election *Election) (*Client, error) {
+	masterId, term := election.Leader()
+	mater, ok := schema.Membership().Lookup(masterId)
+	if masterId == "" || !ok {
 		mp3util.NodeLogger.Error("Couldn't establish master node to connect to")
-		return nil, err
+		return nil, errors.New(fmt.Sprintf("No master elected in term %v (yet)", term))
 	}
 
 	c := &Client{}
 
-	/* Set up connection with the master node */
-	mp3util.NodeLogger.Debugf("Dialing GRPC for master %v at address %v:%v\n", mater.Member_Id, mater.Address, mater.Port)
-	conn, err := grpc.Dial(fmt.Sprintf("%v:%v", mater.Address, config.MP3_MASTER_PORT), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	/* Set up connection with the master node. Every RPC says which term we think it's master of. */
+	mp3util.NodeLogger.Debugf("Dialing GRPC for master %v of term %v at address %v:%v\n", mater.Member_Id, term, mater.Address, mater.Port)
+	conn, err := grpc.Dial(fmt.Sprintf("%v:%v", mater.Address, config.MP3_MASTER_PORT), grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(withTerm(ctx, term), method, req, reply, cc, opts...)
+		}),
+		grpc.WithStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(withTerm(ctx, term), desc, cc, method, opts...)
+		}))
 
 	if err != nil {
 		log.Fatalf("Failed to dial: %v", err)
@@ -73,6 +89,10 @@ func NewClient() (*Client, error) {
 	return c, nil
 }
 
+func withTerm(ctx context.Context, term uint64) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, TERM_METADATA_KEY, strconv.FormatUint(term, 10))
+}
+
 func (c *Client) createLocalStorage() error {
 	localFileDir := filepath.Join(".", fsys.LOCALFILE_DIR)
 	err := os.MkdirAll(localFileDir, 0777)
@@ -93,6 +113,23 @@ func (c *Client) openFile(filePath string, mode int) (*os.File, error) {
 	return fd, nil
 }
 
+/*
+Asks the master which file args.SdfsFileName is (see fsys/namespace.go), and fills in args.FileId, which is what
+replicas go by. With create, a path with no file at it yet gets an ID for the file we're about to put there.
+*/
+func (c *Client) resolve(args *schema.CliArgs, create bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	f, err := c.masterStub.Resolve(ctx, &proto.FileInfo{Sdfsname: args.SdfsFileName, Create: create})
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't resolve %v: %v", args.SdfsFileName, err)
+		return err
+	}
+	mp3util.NodeLogger.Debugf("%v is file %v", f.Sdfsname, f.Fileid)
+	args.SdfsFileName, args.FileId = f.Sdfsname, f.Fileid
+	return nil
+}
+
 /**
  * GetReplicas
  *	Client side request over GRPC to master. Fetches quorum of replicas from
@@ -106,11 +143,14 @@ func (c *Client) GetReplicas(args schema.CliArgs) ([]ReplicaMetadata, error) {
 	defer cancel()
 	stream, err := c.masterStub.GetReplicas(ctx, &proto.FileInfo{
 		Sdfsname:    args.SdfsFileName,
+		Fileid:      args.FileId,
 		ContentHash: "IGNORED_FIELD", // doesn't make sense we just want partitioning function :(
 	})
 
 	if err != nil {
-		mp3util.NodeLogger.Fatal("Failed GetReplicas", err)
+		/* Routine while the master changes hands (not the master anymore, stale term): the caller can retry with a
+		 * new client, which dials whoever won */
+		mp3util.NodeLogger.Error("Failed GetReplicas: ", err)
 		return nil, err
 	}
 
@@ -136,11 +176,12 @@ func (c *Client) GetReplicasNonQuorum(args schema.CliArgs) ([]ReplicaMetadata, e
 	defer cancel()
 	stream, err := c.masterStub.GetReplicasNonQuorum(ctx, &proto.FileInfo{
 		Sdfsname:    args.SdfsFileName,
+		Fileid:      args.FileId,
 		ContentHash: "IGNORED_FIELD", // doesn't make sense we just want partitioning function :(
 	})
 
 	if err != nil {
-		mp3util.NodeLogger.Fatal("Failed GetReplicas", err)
+		mp3util.NodeLogger.Error("Failed GetReplicasNonQuorum: ", err)
 		return nil, err
 	}
 
@@ -161,7 +202,11 @@ func (c *Client) GetReplicasNonQuorum(args schema.CliArgs) ([]ReplicaMetadata, e
 	return replicaList, nil
 }
 
-func (c *Client) FinalizeWrite(contentHash string, args schema.CliArgs, replicas []ReplicaMetadata) error {
+/*
+Asks the master to register the upload named contentHash on replicas. missed are the replicas that should have gotten
+the upload but couldn't be reached; the master arranges for them to get it later.
+*/
+func (c *Client) FinalizeWrite(contentHash string, args schema.CliArgs, replicas []ReplicaMetadata, missed []ReplicaMetadata) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -174,50 +219,148 @@ func (c *Client) FinalizeWrite(contentHash string, args schema.CliArgs, replicas
 			Memberid: r.MemberId,
 		})
 	}
+	var missedInfo []*proto.ReplicaInfo
+	for _, r := range missed {
+		missedInfo = append(missedInfo, &proto.ReplicaInfo{
+			Name:     r.Address,
+			Port:     r.Port,
+			Memberid: r.MemberId,
+		})
+	}
+
+	writer := schema.Membership().Self.Member_Id
+
+	fileInfo := &proto.FileInfo{Sdfsname: args.SdfsFileName, Fileid: args.FileId, ContentHash: contentHash, Writer: writer,
+		Localname: filepath.Base(args.LocalFileName), Mimetype: detectMimeType(args.LocalFileName)}
+	var keys []string
+	for key := range args.Attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fileInfo.Attrs = append(fileInfo.Attrs, &proto.Attribute{Key: key, Value: args.Attrs[key]})
+	}
 
 	status, err := c.masterStub.FinalizeWrite(ctx, &proto.FileAndQuorumInfo{
 		Quorum: quorum,
-		Args:   &proto.FileInfo{Sdfsname: args.SdfsFileName, ContentHash: contentHash},
+		Missed: missedInfo,
+		Args:   fileInfo,
 	})
 
 	if err != nil {
 		mp3util.NodeLogger.Error("Error finalizing write on master: ", err)
+		if quorumErr := writeQuorumErrorFrom(err); quorumErr != nil {
+			return quorumErr
+		}
 	}
 	mp3util.NodeLogger.Debug("Status received from FinalizeWrite", status)
 	return err
 }
 
+/*
+The MIME type of the local file, by its extension, or failing that, by sniffing its first 512 bytes.
+*/
+func detectMimeType(localFileName string) string {
+	if t := mime.TypeByExtension(filepath.Ext(localFileName)); t != "" {
+		return t
+	}
+	fd, err := os.Open(filepath.Join(".", localFileName))
+	if err != nil {
+		return ""
+	}
+	defer fd.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(fd, head)
+	return http.DetectContentType(head[:n])
+}
+
+/*
+What FinalizeWrite returns when the master couldn't get the write registered on enough replicas. The write isn't
+durable, even though some replicas (Succeeded of them) may have it.
+*/
+type WriteQuorumError struct {
+	Succeeded int
+	Needed    int
+	Failed    []ReplicaMetadata
+}
+
+func (e *WriteQuorumError) Error() string {
+	var failed []string
+	for _, r := range e.Failed {
+		failed = append(failed, r.MemberId)
+	}
+	return fmt.Sprintf("write quorum not met: %v of %v replicas registered the write (failed: %v)",
+		e.Succeeded, e.Needed, strings.Join(failed, ", "))
+}
+
+/*
+Digs the WriteQuorumFailure the master attaches out of a gRPC error, if it's there.
+*/
+func writeQuorumErrorFrom(err error) *WriteQuorumError {
+	st, ok := grpcstatus.FromError(err)
+	if !ok {
+		return nil
+	}
+	for _, detail := range st.Details() {
+		failure, ok := detail.(*proto.WriteQuorumFailure)
+		if !ok {
+			continue
+		}
+		quorumErr := &WriteQuorumError{Succeeded: int(failure.Succeeded), Needed: int(failure.Needed)}
+		for _, r := range failure.Failed {
+			quorumErr.Failed = append(quorumErr.Failed, NewReplicaMetadata(r))
+		}
+		return quorumErr
+	}
+	return nil
+}
+
 /////// woo yea
-func (c *Client) QueryReplicaForLatestVersion(args schema.CliArgs, r ReplicaMetadata) (time.Time, error) {
+func (c *Client) QueryReplicaForLatestVersion(args schema.CliArgs, r ReplicaMetadata) (int64, error) {
+	resp, err := c.queryReplicaForMetadata(args, 0, r)
+	if err != nil {
+		return 0, err
+	}
+	return resp.SDFSFileVersion, nil
+}
+
+/*
+Asks r about the newest version it has of args.FileId that's no newer than upperVersionBound (0 for no bound). The
+response has the version and, if the replica has it, its metadata.
+*/
+func (c *Client) queryReplicaForMetadata(args schema.CliArgs, upperVersionBound int64, r ReplicaMetadata) (*fsys.TCPChannelResponse, error) {
 	// Defer resource leak info: https://stackoverflow.com/a/45620423/6184823
 	mp3util.NodeLogger.Debugf("Initiating GetFile transaction with replica with ID=%v at addr=%v\n", r.MemberId, r.Address)
 	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", r.Address, config.MP3_REPLICA_TCP_PORT), config.DEFAULT_TCP_TIMEOUT)
 	if err != nil {
 		mp3util.NodeLogger.Errorf("Couldn't connect to replica with ID=%v at addr=%v: %v !\n", r.MemberId, r.Address, err)
-		return time.Unix(0, 0), err
+		return nil, err
 	}
 	defer conn.Close()
 	/* Issue request to replica to fetch file version */
-	req := fsys.TCPChannelRequest{RequestType: fsys.CLIENT_REQ_FILE_METADATA, SDFSFileName: args.SdfsFileName}
+	req := fsys.TCPChannelRequest{RequestType: fsys.CLIENT_REQ_FILE_METADATA, SDFSFileName: args.FileId, UpperVersionBound: upperVersionBound}
 	err = req.Send(conn)
 	if err != nil {
 		mp3util.NodeLogger.Errorf("Couldn't send request to replica with ID=%v at addr=%v: %v !\n", r.MemberId, r.Address, err)
-		return time.Time{}, err
+		return nil, err
 	}
 
-	resp, err := fsys.RecvTCPChannelResponse(conn)
+	resp, err := fsys.RecvTCPChannelResponseAnyCode(conn)
 	if err != nil {
 		mp3util.NodeLogger.Warnf("Error response from replica with ID=%v at addr=%v: %v !\n", r.MemberId, r.Address, err)
-		return time.Unix(0, 0), err
+		return nil, err
 	}
 
 	mp3util.NodeLogger.Debugf("Got response: %v\n", *resp)
 
 	if resp.ResponseCode == fsys.OK {
-		return time.Unix(0, resp.SDFSFileVersion), nil
+		return resp, nil
+	} else if resp.ResponseCode == fsys.FILE_NOT_FOUND {
+		// Not an error as such, but the caller needs to tell "doesn't have it" apart from "has version 0".
+		return nil, os.ErrNotExist
 	} else {
 		mp3util.NodeLogger.Errorf("Response was not OK from replica %v - response was %v", r, resp)
-		return time.Unix(0, 0), nil
+		return nil, errors.New(fmt.Sprintf("replica responded %v", resp.ResponseCode))
 	}
 }
 
@@ -225,65 +368,249 @@ func (c *Client) QueryReplicaForLatestVersion(args schema.CliArgs, r ReplicaMeta
 NON GRPC Function
 */
 func (c *Client) SendFileToReplica(args schema.CliArgs, fd *os.File, compressedFileSize int64, r ReplicaMetadata) (*fsys.TCPChannelResponse, error) {
+	return c.sendFileToReplica(context.Background(), args, fd, compressedFileSize, r, nil)
+}
 
-	// Defer resource leak info: https://stackoverflow.com/a/45620423/6184823
+/*
+SendFileToReplica, but r also forwards the upload down chain as it gets it. The response's ChainCommitted says how many
+of r and chain (in that order) ended up with it. Cancelling ctx abandons the upload.
+*/
+func (c *Client) sendFileToReplica(ctx context.Context, args schema.CliArgs, fd *os.File, compressedFileSize int64, r ReplicaMetadata, chain []fsys.ReplicaAddr) (*fsys.TCPChannelResponse, error) {
+	defer fd.Seek(0, 0)
 	mp3util.NodeLogger.Debugf("Initiating PutFile transaction with replica with ID=%v at addr=%v\n", r.MemberId, r.Address)
+
+	/* The upload goes over in checksummed chunks as part of an upload session on the replica. If the connection drops,
+	 * we reconnect, ask the replica how far it got, and carry on from there. */
+	uploadID := ""
+	var err error
+	for attempt := 1; attempt <= config.UPLOAD_MAX_ATTEMPTS; attempt++ {
+		if attempt > 1 {
+			mp3util.NodeLogger.Warnf("Upload to replica with ID=%v interrupted (%v). Resuming, attempt %v of %v...",
+				r.MemberId, err, attempt, config.UPLOAD_MAX_ATTEMPTS)
+			select {
+			case <-time.After(time.Duration(attempt-1) * time.Second):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
+		var resp *fsys.TCPChannelResponse
+		resp, err = c.uploadToReplica(ctx, args, fd, compressedFileSize, r, chain, &uploadID)
+		if err == nil {
+			return resp, nil
+		}
+	}
+	if ctx.Err() != nil {
+		mp3util.NodeLogger.Debugf("Abandoning upload to replica with ID=%v at addr=%v", r.MemberId, r.Address)
+	} else {
+		mp3util.NodeLogger.Errorf("Giving up on uploading to replica with ID=%v at addr=%v: %v !\n", r.MemberId, r.Address, err)
+	}
+	if uploadID != "" {
+		// Don't make the replica sit on half an upload until it expires.
+		uploadRequest(&fsys.TCPChannelRequest{RequestType: fsys.CLIENT_UPLOAD_ABORT, UploadID: uploadID}, r)
+	}
+	return nil, err
+}
+
+/*
+One attempt at (the rest of) an upload. *uploadID is the session to resume, or "" to begin a new one, in which case it
+gets filled in. chain only matters when beginning.
+*/
+func (c *Client) uploadToReplica(ctx context.Context, args schema.CliArgs, fd *os.File, compressedFileSize int64, r ReplicaMetadata, chain []fsys.ReplicaAddr, uploadID *string) (*fsys.TCPChannelResponse, error) {
+	offset := int64(0)
+	if *uploadID != "" {
+		resp, err := uploadRequest(&fsys.TCPChannelRequest{RequestType: fsys.CLIENT_UPLOAD_RESUME, UploadID: *uploadID}, r)
+		if err != nil {
+			return nil, err
+		}
+		if resp.ResponseCode == fsys.OK {
+			offset = resp.UploadOffset
+			mp3util.NodeLogger.Infof("Replica with ID=%v already has %v of %v bytes.", r.MemberId, offset, compressedFileSize)
+		} else {
+			mp3util.NodeLogger.Warnf("Replica with ID=%v lost upload %v (%v), starting over.", r.MemberId, *uploadID, resp.ResponseCode)
+			*uploadID = ""
+		}
+	}
+	if *uploadID == "" {
+		resp, err := uploadRequest(&fsys.TCPChannelRequest{
+			RequestType:  fsys.CLIENT_UPLOAD_BEGIN,
+			SDFSFileName: args.FileId,
+			FileSize:     compressedFileSize,
+			Chain:        chain,
+		}, r)
+		if err != nil {
+			return nil, err
+		}
+		if resp.ResponseCode != fsys.OK {
+			return nil, errors.New(fmt.Sprintf("replica refused the upload: %v", resp.ResponseCode))
+		}
+		*uploadID = resp.UploadID
+	}
+
+	/* Regenerate the gzip from the top. It's deterministic, so we can just skip what the replica already has (and
+	 * still hash the whole thing, to check against what the replica ends up with). */
+	_, err := fd.Seek(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	stream := fsys.GzipStream(fd)
+	defer stream.Close()
+	hashWriter := sha256.New()
+	gzipped := io.TeeReader(stream, hashWriter)
+	_, err = io.CopyN(io.Discard, gzipped, offset)
+	if err != nil {
+		return nil, err
+	}
+
 	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", r.Address, config.MP3_REPLICA_TCP_PORT), config.DEFAULT_TCP_TIMEOUT)
-	defer fd.Seek(0, 0)
 	if err != nil {
 		mp3util.NodeLogger.Errorf("Couldn't connect to replica with ID=%v at addr=%v: %v !\n", r.MemberId, r.Address, err)
 		return nil, err
 	}
-
 	defer conn.Close()
-	mp3util.NodeLogger.Debug("Constructing request to send tot he file")
-	/* Issue request to replica to put file */
-	err = (&fsys.TCPChannelRequest{
-		RequestType:  fsys.CLIENT_SEND_FILE_DATA,
-		SDFSFileName: args.SdfsFileName,
-		FileSize:     compressedFileSize,
-	}).Send(conn)
+	// Hanging up is how an abandoned upload stops mid-chunk.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	buf := make([]byte, config.UPLOAD_CHUNK_SIZE)
+	for offset < compressedFileSize {
+		n := config.UPLOAD_CHUNK_SIZE
+		if compressedFileSize-offset < n {
+			n = compressedFileSize - offset
+		}
+		_, err = io.ReadFull(gzipped, buf[:n])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%v changed while uploading it? %v", args.LocalFileName, err))
+		}
+		checksum := sha256.Sum256(buf[:n])
+		conn.SetDeadline(time.Now().Add(config.UPLOAD_CHUNK_TIMEOUT))
+		err = (&fsys.TCPChannelRequest{
+			RequestType:   fsys.CLIENT_UPLOAD_CHUNK,
+			UploadID:      *uploadID,
+			ChunkOffset:   offset,
+			FileSize:      n,
+			ChunkChecksum: hex.EncodeToString(checksum[:]),
+		}).Send(conn)
+		if err != nil {
+			return nil, err
+		}
+		_, err = conn.Write(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		resp, err := fsys.RecvTCPChannelResponseAnyCode(conn)
+		if err != nil {
+			return nil, err
+		}
+		if resp.ResponseCode != fsys.OK {
+			// The replica tells us where it wants us to continue from; the next attempt will ask it again.
+			return nil, errors.New(fmt.Sprintf("chunk at %v rejected with %v, replica is at %v", offset, resp.ResponseCode, resp.UploadOffset))
+		}
+		offset = resp.UploadOffset
+	}
+	if extra, _ := io.CopyN(io.Discard, gzipped, 1); extra > 0 {
+		return nil, errors.New(fmt.Sprintf("%v changed while uploading it, it's bigger now", args.LocalFileName))
+	}
 
+	conn.SetDeadline(time.Now().Add(config.UPLOAD_CHUNK_TIMEOUT))
+	err = (&fsys.TCPChannelRequest{RequestType: fsys.CLIENT_UPLOAD_COMMIT, UploadID: *uploadID}).Send(conn)
 	if err != nil {
-		mp3util.NodeLogger.Errorf("Couldn't send request to replica with ID=%v at addr=%v: %v !\n", r.MemberId, r.Address, err)
 		return nil, err
 	}
-
-	resp, err := fsys.RecvTCPChannelResponse(conn)
+	resp, err := fsys.RecvTCPChannelResponseAnyCode(conn)
 	if err != nil {
-		mp3util.NodeLogger.Errorf("Did not get OK from replica with ID=%v at addr=%v: %v !\n", r.MemberId, r.Address, err)
 		return nil, err
 	}
-
-	/* Now send the entire compressed file over to the replica */
-	if err = fsys.SendFileAsGzip(fd, conn); err != nil {
-		mp3util.NodeLogger.Errorf("Couldn't send file to replica with ID=%v at addr=%v: %v !\n", r.MemberId, r.Address, err)
+	if resp.ResponseCode != fsys.OK {
+		return nil, errors.New(fmt.Sprintf("replica couldn't commit the upload: %v", resp.ResponseCode))
 	}
+	if expected := hex.EncodeToString(hashWriter.Sum(nil)); resp.FileContentHash != expected {
+		// The session is gone now, so there's nothing to resume.
+		*uploadID = ""
+		return nil, errors.New(fmt.Sprintf("replica ended up with %v, but we sent %v", resp.FileContentHash, expected))
+	}
+	return resp, nil
+}
 
-	resp, err = fsys.RecvTCPChannelResponse(conn)
+/*
+Sends one request on its own connection and returns the response, even if it isn't OK.
+*/
+func uploadRequest(req *fsys.TCPChannelRequest, r ReplicaMetadata) (*fsys.TCPChannelResponse, error) {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", r.Address, config.MP3_REPLICA_TCP_PORT), config.DEFAULT_TCP_TIMEOUT)
 	if err != nil {
-		mp3util.NodeLogger.Errorf("Did not get OK from replica with ID=%v at addr=%v: %v !\n", r.MemberId, r.Address, err)
 		return nil, err
 	}
-
-	return resp, nil
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(config.UPLOAD_CHUNK_TIMEOUT))
+	err = req.Send(conn)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.RecvTCPChannelResponseAnyCode(conn)
 }
 
 /**
  * GetFile
- *	Gets a file from SDFS and copies locally onto disk.
+ *	Gets a file from SDFS and copies locally onto disk. That's the latest
+ *	version, unless args.Version asks for a specific one, or args.UpperVersionBound
+ *	for the one there was as of some time.
  */
 func (c *Client) GetFile(args schema.CliArgs) error {
 	mp3util.NodeLogger.Debug("Entered client.GetFile")
+	err := c.resolve(&args, false)
+	if err != nil {
+		return err
+	}
+	version, err := c.getVersion(args, args.LocalFileName)
+	if err != nil {
+		return err
+	}
+	if args.Version != 0 || args.UpperVersionBound != 0 {
+		fmt.Printf("Got %v @ %v (%v) into %v\n", args.SdfsFileName, version, fsys.VersionTime(version).Format(time.RFC822),
+			filepath.Join(fsys.LOCALFILE_DIR, args.LocalFileName))
+	}
+	return nil
+}
+
+/*
+Downloads the version of args.FileId that GetFile would into localFileName, and returns which version that was.
+*/
+func (c *Client) getVersion(args schema.CliArgs, localFileName string) (int64, error) {
+	latestVersionReplica, latestVersion, allReplicas, err := c.findLatestVersion(args)
+	if err != nil {
+		return 0, err
+	}
+	return latestVersion, c.fromIntactReplica(args, latestVersionReplica, latestVersion, allReplicas, func(r ReplicaMetadata) error {
+		return c.ReceiveFileFromReplica(args.FileId, localFileName, ReplicaFileInfo{ReplicaID: r, Version: latestVersion})
+	})
+}
+
+/*
+Which replica has the latest version of args.SdfsFileName, and what version that is. Also returns every replica of the
+file, in case the one with the latest version turns out to be no good. With args.Version, it's that exact version
+we're after instead, and with args.UpperVersionBound, the latest one no newer than that.
+*/
+func (c *Client) findLatestVersion(args schema.CliArgs) (ReplicaMetadata, int64, []ReplicaMetadata, error) {
+	var latestVersionReplica ReplicaMetadata
+	latestVersion := int64(0)
 	replicas, err := c.GetReplicas(args)
 	mp3util.NodeLogger.Debug("Getfile received replicas: ", replicas)
 
 	if err != nil || len(replicas) == 0 {
 		mp3util.NodeLogger.Error("Client can't get replicas!")
 		if err != nil {
-			return err
+			return latestVersionReplica, latestVersion, nil, err
 		} else {
-			return errors.New("Length of GetReplicas was zero.")
+			return latestVersionReplica, latestVersion, nil, errors.New("Length of GetReplicas was zero.")
 		}
 	}
 
@@ -292,45 +619,117 @@ func (c *Client) GetFile(args schema.CliArgs) error {
 	 * Determine which replica has the latest file version.
 	 */
 
-	// Cap the replicas to query for reads here.
-	if len(replicas) > config.READ_CONSISTENCY {
+	// Cap the replicas to query for reads here. Older versions may be on any of them, though, not just the first R.
+	upperVersionBound := args.UpperVersionBound
+	if args.Version != 0 {
+		upperVersionBound = args.Version
+	}
+	allReplicas := replicas
+	if len(replicas) > config.READ_CONSISTENCY && upperVersionBound == 0 {
 		replicas = replicas[:config.READ_CONSISTENCY]
 	}
-	var latestVersionReplica ReplicaMetadata
-	latestVersion := time.Unix(0, 0)
 	replicaWithFileExists := false
+	answered := make(map[ReplicaMetadata]int64)
 	for _, r := range replicas {
-		replicaVersion, err := c.QueryReplicaForLatestVersion(args, r)
+		var replicaVersion int64
+		resp, err := c.queryReplicaForMetadata(args, upperVersionBound, r)
+		if err == nil && args.Version != 0 && resp.SDFSFileVersion != args.Version {
+			err = os.ErrNotExist
+		} else if err == nil {
+			replicaVersion = resp.SDFSFileVersion
+		}
 		if err == nil {
 			replicaWithFileExists = true
+			answered[r] = replicaVersion
 			/* Determine latest timestamp replica */
-			if replicaVersion.After(latestVersion) {
+			if replicaVersion > latestVersion {
 				latestVersion = replicaVersion
 				latestVersionReplica = r
 			}
+		} else if errors.Is(err, os.ErrNotExist) {
+			answered[r] = 0
 		}
 	}
 	if !replicaWithFileExists {
 		mp3util.NodeLogger.Errorf("Replica with SDFSFile=%v not found!", args.SdfsFileName)
-		return os.ErrNotExist
+		return latestVersionReplica, latestVersion, allReplicas, os.ErrNotExist
+	}
+
+	// Only the latest version is worth repairing; older ones may well be gone on purpose (see fsys/retention.go).
+	if config.READ_REPAIR && upperVersionBound == 0 {
+		var stale []ReplicaMetadata
+		for r, v := range answered {
+			if v < latestVersion {
+				stale = append(stale, r)
+			}
+		}
+		if len(stale) > 0 {
+			go readRepair(args.FileId, latestVersionReplica, latestVersion, stale)
+		}
 	}
+	return latestVersionReplica, latestVersion, allReplicas, nil
+}
 
-	latestVersionReplicaFileInfo := ReplicaFileInfo{
-		ReplicaID: latestVersionReplica,
-		Version:   time.Now(),
+/*
+Read repair: has source push version to the replicas we just caught lagging behind it, over the same
+REPLICA_QUERY_FILES/REPLICA_SEND_FILE path active replication uses. Nobody waits on this.
+*/
+func readRepair(sdfsFileName string, source ReplicaMetadata, version int64, stale []ReplicaMetadata) {
+	req := &fsys.TCPChannelRequest{
+		RequestType:     fsys.CLIENT_READ_REPAIR,
+		SDFSFileName:    sdfsFileName,
+		SDFSFileVersion: version,
+	}
+	var staleIds []string
+	for _, r := range stale {
+		req.RepairTargets = append(req.RepairTargets, fsys.ReplicaAddr{MemberId: r.MemberId, Address: r.Address})
+		staleIds = append(staleIds, r.MemberId)
 	}
-	err = c.ReceiveFileFromReplica(args.SdfsFileName, args.LocalFileName, latestVersionReplicaFileInfo)
+	mp3util.NodeLogger.Infof("Read repair: replicas %v are behind on %v, asking %v to push version %v to them",
+		staleIds, sdfsFileName, source.MemberId, version)
+	_, err := UnicastToReplica(req, source)
 	if err != nil {
-		mp3util.NodeLogger.Errorf("Failed to receive file from replica with ID=%v at addr=%v: %v !\n", latestVersionReplica.MemberId, latestVersionReplica.Address, err)
+		mp3util.NodeLogger.Warnf("Read repair of %v from %v failed: %v", sdfsFileName, source.MemberId, err)
+	}
+}
+
+/*
+Runs fetch against the replica with the latest version. If what it sends doesn't match its content hash, fetch is
+retried against everyone else that has the same version (not just the replicas findLatestVersion asked), and we only
+give up if nobody has an intact copy.
+*/
+func (c *Client) fromIntactReplica(args schema.CliArgs, latestVersionReplica ReplicaMetadata, latestVersion int64,
+	allReplicas []ReplicaMetadata, fetch func(r ReplicaMetadata) error) error {
+	err := fetch(latestVersionReplica)
+	if err == nil {
+		return nil
+	}
+	mp3util.NodeLogger.Errorf("Failed to receive file from replica with ID=%v at addr=%v: %v !\n", latestVersionReplica.MemberId, latestVersionReplica.Address, err)
+	if err != fsys.ErrContentHashMismatch {
 		return err
 	}
+
+	for _, r := range allReplicas {
+		if r == latestVersionReplica {
+			continue
+		}
+		resp, qErr := c.queryReplicaForMetadata(args, latestVersion, r)
+		if qErr != nil || resp.SDFSFileVersion != latestVersion {
+			continue
+		}
+		mp3util.NodeLogger.Warnf("Retrying %v from replica with ID=%v...", args.SdfsFileName, r.MemberId)
+		err = fetch(r)
+		if err == nil {
+			return nil
+		}
+	}
 	return err
 }
 
 func (c *Client) ReceiveFileFromReplica(sdfsFileName string, localFileName string, repInfo ReplicaFileInfo) error {
 
 	r := repInfo.ReplicaID
-	version := repInfo.Version.UnixNano()
+	version := repInfo.Version
 
 	/* Now, receive the file from the replica with the latest version */
 	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", r.Address, config.MP3_REPLICA_TCP_PORT), config.DEFAULT_TCP_TIMEOUT)
@@ -346,7 +745,7 @@ func (c *Client) ReceiveFileFromReplica(sdfsFileName string, localFileName strin
 		UpperVersionBound: version,
 	}).Send(conn)
 	if err != nil {
-		mp3util.NodeLogger.Error("Couldn't send request to download file from replica! Error: %v", err)
+		mp3util.NodeLogger.Errorf("Couldn't send request to download file from replica! Error: %v", err)
 		return err
 	}
 	resp, err := fsys.RecvTCPChannelResponse(conn)
@@ -354,80 +753,404 @@ func (c *Client) ReceiveFileFromReplica(sdfsFileName string, localFileName strin
 		mp3util.NodeLogger.Errorf("Couldn't get response back from replica! Error: %v", err)
 		return err
 	}
+	if resp.ResponseCode != fsys.OK {
+		mp3util.NodeLogger.Errorf("Replica with ID=%v couldn't send %v: %v", r.MemberId, sdfsFileName, resp.ResponseCode)
+		return errors.New(fmt.Sprintf("replica responded %v", resp.ResponseCode))
+	}
+	if resp.SDFSFileVersion != version {
+		// It doesn't have that version (anymore), just older ones.
+		mp3util.NodeLogger.Errorf("Replica with ID=%v has %v @ %v, not @ %v", r.MemberId, sdfsFileName, resp.SDFSFileVersion, version)
+		return os.ErrNotExist
+	}
 
 	localFilePath := filepath.Join(fsys.LOCALFILE_DIR, localFileName)
-	fd, err := c.openFile(localFilePath, os.O_WRONLY|os.O_CREATE)
+	fd, err := c.openFile(localFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
 	defer fd.Close()
 	if err != nil {
 		return err
 	}
 	mp3util.NodeLogger.Debug("About to recv file over TCP")
-	nbytes, err := fsys.RecvFileFromGzip(&io.LimitedReader{
+	nbytes, err := fsys.RecvFileFromGzipVerified(&io.LimitedReader{
 		R: conn,
 		N: resp.ReturningSDFSFileSize,
-	}, fd)
+	}, fd, resp.FileContentHash)
 	mp3util.NodeLogger.Debug("Received ", nbytes, " bytes from replica")
+	if err == fsys.ErrContentHashMismatch {
+		// Don't leave a corrupted file lying around where it looks like a successful get.
+		mp3util.NodeLogger.Errorf("%v @ %v from replica with ID=%v is corrupt! Discarding %v.", sdfsFileName, resp.SDFSFileVersion, r.MemberId, localFilePath)
+		fd.Close()
+		os.Remove(localFilePath)
+	}
 	return err
 }
 
-func (c *Client) PutFile(args schema.CliArgs) error {
-	mp3util.NodeLogger.Debug("Entered client.PutFile")
-	replicas, err := c.GetReplicas(args)
-	mp3util.NodeLogger.Debug("Received replicas: ", replicas)
-
-	if err != nil || len(replicas) == 0 {
-		mp3util.NodeLogger.Error("Client can't get replicas!")
-		if err != nil {
-			return err
-		} else {
-			return errors.New("Length of GetReplicas was zero.")
-		}
-	}
-
-	/* Open file locally and calculate compressed file size */
-	localFilePath := filepath.Join(".", args.LocalFileName)
-	fd, err := c.openFile(localFilePath, os.O_RDONLY)
+/**
+ * GetRange
+ *	Client side operation to download [args.Offset, args.Offset+args.Length)
+ *	of the latest version of a file into fetchedfiles/<args.LocalFileName>.
+ *	Only the chunks covering the range come over the wire.
+ *	@param args - file args, containing target file on sdfs, the range and
+ *		the local file name
+ */
+func (c *Client) GetRange(args schema.CliArgs) error {
+	mp3util.NodeLogger.Debug("Entered client.GetRange")
+	localFilePath := filepath.Join(fsys.LOCALFILE_DIR, args.LocalFileName)
+	fd, err := c.openFile(localFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
 	if err != nil {
 		return err
 	}
 	defer fd.Close()
-
-	compressedFileSize, err := fsys.GetGzipFileSize(fd)
-	mp3util.NodeLogger.Debugf("Calculated compressed file size: %v", compressedFileSize)
-	fd.Seek(0, 0)
+	nbytes, err := c.readRange(args.SdfsFileName, args.Offset, args.Length, func() (io.Writer, error) {
+		// Start over if a replica sent us garbage halfway through.
+		err := fd.Truncate(0)
+		if err != nil {
+			return nil, err
+		}
+		_, err = fd.Seek(0, io.SeekStart)
+		return fd, err
+	})
 	if err != nil {
+		fd.Close()
+		os.Remove(localFilePath)
 		return err
 	}
+	mp3util.NodeLogger.Infof("Got %v bytes of %v starting at %v", nbytes, args.SdfsFileName, args.Offset)
+	return nil
+}
 
-	/* Contact each replica with a CLIENT_SEND_FILE_DATA request.
-	 * The response will contain an ACK.
-	 * Then, send the gzip'd file to each replica
-	 */
-	contentHash := ""
-	for _, r := range replicas {
-		resp, err := c.SendFileToReplica(args, fd, compressedFileSize, r)
-		if err == nil {
-			contentHash = resp.FileContentHash
-		}
+/**
+ * ReadAt
+ *	Reads len(p) bytes of the latest version of an SDFS file, starting at
+ *	off, the same way io.ReaderAt does: if fewer than len(p) bytes are read
+ *	(because the file ends first), the error says why, and is io.EOF if it's
+ *	just the end of the file.
+ */
+func (c *Client) ReadAt(sdfsFileName string, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
 	}
-
-	if contentHash == "" {
-		return errors.New("No response from any replicas")
+	buf := &sliceWriter{buf: p}
+	nbytes, err := c.readRange(sdfsFileName, off, int64(len(p)), func() (io.Writer, error) {
+		buf.n = 0
+		return buf, nil
+	})
+	if err != nil {
+		return int(nbytes), err
 	}
+	if int(nbytes) < len(p) {
+		return int(nbytes), io.EOF
+	}
+	return int(nbytes), nil
+}
 
-	/* Issue a write request to master to finalize file send */
-	return c.FinalizeWrite(contentHash, args, replicas)
+/*
+Fetches a range of the latest version from whichever replica has it intact. newTarget is called before every attempt,
+and should hand back somewhere empty to write to.
+*/
+func (c *Client) readRange(sdfsFileName string, off int64, length int64, newTarget func() (io.Writer, error)) (int64, error) {
+	if off < 0 || length <= 0 {
+		return 0, errors.New(fmt.Sprintf("bad range: offset=%v length=%v", off, length))
+	}
+	args := schema.CliArgs{SdfsFileName: sdfsFileName}
+	err := c.resolve(&args, false)
+	if err != nil {
+		return 0, err
+	}
+	latestVersionReplica, latestVersion, allReplicas, err := c.findLatestVersion(args)
+	if err != nil {
+		return 0, err
+	}
+	var nbytes int64
+	err = c.fromIntactReplica(args, latestVersionReplica, latestVersion, allReplicas, func(r ReplicaMetadata) error {
+		target, err := newTarget()
+		if err != nil {
+			return err
+		}
+		nbytes, err = c.ReceiveRangeFromReplica(sdfsFileName, off, length, ReplicaFileInfo{ReplicaID: r, Version: latestVersion}, target)
+		return err
+	})
+	return nbytes, err
 }
 
-func (c *Client) Ls(args schema.CliArgs) error {
-	/*
-		LS: need to find all machines that could have the file.
-		Step 1: Get the replicas from the master.
+func (c *Client) ReceiveRangeFromReplica(sdfsFileName string, off int64, length int64, repInfo ReplicaFileInfo, target io.Writer) (int64, error) {
+	r := repInfo.ReplicaID
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", r.Address, config.MP3_REPLICA_TCP_PORT), config.DEFAULT_TCP_TIMEOUT)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't connect to replica with ID=%v at addr=%v: %v !\n", r.MemberId, r.Address, err)
+		return 0, err
+	}
+	defer conn.Close()
+
+	err = (&fsys.TCPChannelRequest{
+		RequestType:       fsys.CLIENT_REQ_FILE_RANGE,
+		SDFSFileName:      sdfsFileName,
+		UpperVersionBound: repInfo.Version,
+		RangeOffset:       off,
+		RangeLength:       length,
+	}).Send(conn)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't send request to download range from replica! Error: %v", err)
+		return 0, err
+	}
+	resp, err := fsys.RecvTCPChannelResponseAnyCode(conn)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't get response back from replica! Error: %v", err)
+		return 0, err
+	}
+	if resp.ResponseCode != fsys.OK {
+		mp3util.NodeLogger.Errorf("Replica with ID=%v couldn't send range of %v: %v", r.MemberId, sdfsFileName, resp.ResponseCode)
+		return 0, errors.New(fmt.Sprintf("replica responded %v", resp.ResponseCode))
+	}
+
+	nbytes, err := fsys.RecvRangeFromGzip(&io.LimitedReader{
+		R: conn,
+		N: resp.ReturningSDFSFileSize,
+	}, resp.RangeChunks, resp.RangeSkip, resp.RangeLength, target)
+	if err == fsys.ErrContentHashMismatch {
+		mp3util.NodeLogger.Errorf("Range of %v @ %v from replica with ID=%v is corrupt!", sdfsFileName, resp.SDFSFileVersion, r.MemberId)
+	}
+	return nbytes, err
+}
+
+/*
+An io.Writer over a fixed slice, for ReadAt. Never writes past the end of it.
+*/
+type sliceWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	n := copy(w.buf[w.n:], p)
+	w.n += n
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (c *Client) PutFile(args schema.CliArgs) error {
+	mp3util.NodeLogger.Debug("Entered client.PutFile")
+	err := c.resolve(&args, true)
+	if err != nil {
+		return err
+	}
+	replicas, err := c.GetReplicas(args)
+	mp3util.NodeLogger.Debug("Received replicas: ", replicas)
+
+	if err != nil || len(replicas) == 0 {
+		mp3util.NodeLogger.Error("Client can't get replicas!")
+		if err != nil {
+			return err
+		} else {
+			return errors.New("Length of GetReplicas was zero.")
+		}
+	}
+
+	/* Open file locally and calculate compressed file size */
+	localFilePath := filepath.Join(".", args.LocalFileName)
+	fd, err := c.openFile(localFilePath, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	compressedFileSize, err := fsys.GetGzipFileSize(fd)
+	mp3util.NodeLogger.Debugf("Calculated compressed file size: %v", compressedFileSize)
+	fd.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+
+	if config.PUT_PARALLEL_UPLOADS && !config.PUT_CHAIN_REPLICATION {
+		contentHash, acked, missed, err := c.sendFileToQuorum(args, localFilePath, compressedFileSize, replicas)
+		if err != nil {
+			return err
+		}
+		return c.FinalizeWrite(contentHash, args, acked, missed)
+	}
+
+	/* Contact each replica with a CLIENT_SEND_FILE_DATA request.
+	 * The response will contain an ACK.
+	 * Then, send the gzip'd file to each replica
+	 */
+	contentHash := ""
+	pending := replicas
+	if config.PUT_CHAIN_REPLICATION {
+		contentHash, pending = c.sendFileDownChain(args, fd, compressedFileSize, replicas)
+	}
+	for _, r := range pending {
+		resp, err := c.SendFileToReplica(args, fd, compressedFileSize, r)
+		if err == nil {
+			contentHash = resp.FileContentHash
+		}
+	}
+
+	if contentHash == "" {
+		return errors.New("No response from any replicas")
+	}
+
+	/* Issue a write request to master to finalize file send */
+	return c.FinalizeWrite(contentHash, args, replicas, nil)
+}
+
+/*
+Uploads to every replica at once, each from its own fd. As soon as config.QUORUM_SIZE of them (or all of them, if
+there are fewer) have committed the same tmpfile, the rest get config.PUT_STRAGGLER_GRACE to finish too, and whoever
+still hasn't is abandoned: their connections are closed and their upload sessions aborted. Returns the tmpfile name,
+the replicas that have it, and the ones that don't.
+*/
+func (c *Client) sendFileToQuorum(args schema.CliArgs, localFilePath string, compressedFileSize int64, replicas []ReplicaMetadata) (string, []ReplicaMetadata, []ReplicaMetadata, error) {
+	type uploadResult struct {
+		r           ReplicaMetadata
+		contentHash string
+		err         error
+	}
+	ctx, abandon := context.WithCancel(context.Background())
+	defer abandon()
+	// Buffered, so that uploads finishing after we've stopped listening don't hang around.
+	results := make(chan uploadResult, len(replicas))
+	for _, r := range replicas {
+		go func(r ReplicaMetadata) {
+			fd, err := c.openFile(localFilePath, os.O_RDONLY)
+			if err != nil {
+				results <- uploadResult{r: r, err: err}
+				return
+			}
+			defer fd.Close()
+			resp, err := c.sendFileToReplica(ctx, args, fd, compressedFileSize, r, nil)
+			if err != nil {
+				results <- uploadResult{r: r, err: err}
+				return
+			}
+			results <- uploadResult{r: r, contentHash: resp.FileContentHash}
+		}(r)
+	}
+
+	needed := config.QUORUM_SIZE
+	if len(replicas) < needed {
+		needed = len(replicas)
+	}
+	acked := make(map[string][]ReplicaMetadata)
+	quorumHash := ""
+	var grace <-chan time.Time
+	var failures []string
+	for received := 0; received < len(replicas); {
+		select {
+		case res := <-results:
+			received++
+			if res.err != nil {
+				mp3util.NodeLogger.Warnf("Upload of %v to replica with ID=%v failed: %v", args.SdfsFileName, res.r.MemberId, res.err)
+				failures = append(failures, fmt.Sprintf("%v: %v", res.r.MemberId, res.err))
+				if quorumHash == "" && len(failures) > len(replicas)-needed {
+					// No way to get a quorum now, don't wait around for the rest.
+					received = len(replicas)
+				}
+				continue
+			}
+			acked[res.contentHash] = append(acked[res.contentHash], res.r)
+			if quorumHash == "" && len(acked[res.contentHash]) >= needed {
+				quorumHash = res.contentHash
+				mp3util.NodeLogger.Debugf("%v of %v replicas have %v, waiting up to %v for the rest", len(acked[quorumHash]), len(replicas),
+					args.SdfsFileName, config.PUT_STRAGGLER_GRACE)
+				grace = time.After(config.PUT_STRAGGLER_GRACE)
+			}
+		case <-grace:
+			mp3util.NodeLogger.Infof("Abandoning %v straggling uploads of %v", len(replicas)-received, args.SdfsFileName)
+			received = len(replicas)
+		}
+	}
+	if quorumHash == "" {
+		took := 0
+		for _, rs := range acked {
+			took += len(rs)
+		}
+		if len(acked) > 1 {
+			failures = append(failures, fmt.Sprintf("replicas disagree on what they got: %v", acked))
+		}
+		return "", nil, nil, errors.New(fmt.Sprintf("only %v of %v needed replicas took the upload (%v)",
+			took, needed, strings.Join(failures, "; ")))
+	}
+	have := make(map[ReplicaMetadata]bool)
+	for _, r := range acked[quorumHash] {
+		have[r] = true
+	}
+	var missed []ReplicaMetadata
+	for _, r := range replicas {
+		if !have[r] {
+			missed = append(missed, r)
+		}
+	}
+	return quorumHash, acked[quorumHash], missed, nil
+}
+
+/*
+Uploads once, to the first replica in ring order, and has it pass the upload down the rest of the ring (see chain.go).
+Returns the tmpfile name, and whichever replicas the chain didn't reach, which still need a normal upload.
+*/
+func (c *Client) sendFileDownChain(args schema.CliArgs, fd *os.File, compressedFileSize int64, replicas []ReplicaMetadata) (string, []ReplicaMetadata) {
+	replicas = ringOrder(args.FileId, replicas)
+	var chain []fsys.ReplicaAddr
+	for _, r := range replicas[1:] {
+		chain = append(chain, fsys.ReplicaAddr{MemberId: r.MemberId, Address: r.Address})
+	}
+	resp, err := c.sendFileToReplica(context.Background(), args, fd, compressedFileSize, replicas[0], chain)
+	if err != nil {
+		mp3util.NodeLogger.Warnf("Chained upload of %v failed, uploading to every replica instead: %v", args.SdfsFileName, err)
+		return "", replicas
+	}
+	committed := resp.ChainCommitted
+	if committed < 1 || committed > len(replicas) {
+		committed = 1
+	}
+	if committed < len(replicas) {
+		mp3util.NodeLogger.Warnf("Chained upload of %v only reached %v of %v replicas, uploading to the rest directly.",
+			args.SdfsFileName, committed, len(replicas))
+	}
+	return resp.FileContentHash, replicas[committed:]
+}
+
+/*
+Sorts replicas the way the placement strategy ranks them for sdfsFileName (for chord: clockwise, starting from the
+file's ID). Ones it doesn't rank at all go last.
+*/
+func ringOrder(sdfsFileName string, replicas []ReplicaMetadata) []ReplicaMetadata {
+	rank := make(map[string]int)
+	for i, m := range schema.ConfiguredPlacement().Place(sdfsFileName, schema.Membership()) {
+		rank[m.Member_Id] = i + 1
+	}
+	sorted := append([]ReplicaMetadata(nil), replicas...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := rank[sorted[i].MemberId], rank[sorted[j].MemberId]
+		return a != 0 && (b == 0 || a < b)
+	})
+	return sorted
+}
+
+func (c *Client) Ls(args schema.CliArgs) error {
+	/*
+		LS: a directory (or anything, with -R) is listed from the namespace on the master. Otherwise it's a file,
+		and we need to find all machines that could have the file.
+		Step 1: Get the replicas from the master.
 		Step 2: Initialize connection with the replicas and ask do you have file?
 		Step 3: Replica respond
 		Step 4: We print to user.
 	*/
-	mp3util.NodeLogger.Debug("Entered client.GetFile")
+	mp3util.NodeLogger.Debug("Entered client.Ls")
+	entries, err := c.ListDirectory(args)
+	if err == nil {
+		clean, _ := fsys.CleanPath(args.SdfsFileName)
+		if args.Recursive || len(entries) != 1 || entries[0].Dir || entries[0].Path != clean {
+			printDirectory(entries)
+			return nil
+		}
+	} else if grpcstatus.Code(err) != codes.NotFound {
+		return err
+	}
+	err = c.resolve(&args, false)
+	if err != nil {
+		return err
+	}
 	replicas, err := c.GetReplicasNonQuorum(args)
 	mp3util.NodeLogger.Debug("Received replicas: ", replicas)
 
@@ -445,25 +1168,189 @@ func (c *Client) Ls(args schema.CliArgs) error {
 	 * Determine which replica has the latest file version.
 	 */
 
-	var replicasWithFile []ReplicaFileInfo
+	w := tabwriter.NewWriter(os.Stdout, 1, 4, 4, ' ', 0)
+	fmt.Fprintln(w, "Replica\tFile\tLatest Version\tReadable Version\tSize\tMIME Type\tAttributes\t")
+	fmt.Fprintln(w, "===========\t===========\t===========\t===========\t===========\t===========\t===========\t")
 	for _, r := range replicas {
-		v, err := c.QueryReplicaForLatestVersion(args, r)
-		if err == nil {
-			replicasWithFile = append(replicasWithFile, ReplicaFileInfo{ReplicaID: r, Version: v})
+		resp, err := c.queryReplicaForMetadata(args, 0, r)
+		if err != nil {
+			continue
+		}
+		var meta fsys.VersionMetadata
+		if resp.Metadata != nil {
+			meta = *resp.Metadata
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n", r.MemberId, args.SdfsFileName, resp.SDFSFileVersion,
+			fsys.VersionTime(resp.SDFSFileVersion).Format(time.RFC822), meta.Size, meta.MimeType, formatAttrs(meta.Attrs))
+	}
+	w.Flush()
+	return nil
+}
+
+/*
+Attributes as key=value pairs, sorted by key.
+*/
+func formatAttrs(attrs map[string]string) string {
+	var pairs []string
+	for key, value := range attrs {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", key, value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+/*
+What's in the directory args.SdfsFileName, or with args.Recursive, everything under it, as the master's namespace has
+it. If it's a file, just that file.
+*/
+func (c *Client) ListDirectory(args schema.CliArgs) ([]*proto.DirEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := c.masterStub.ListDirectory(ctx, &proto.FileInfo{Sdfsname: args.SdfsFileName, Recursive: args.Recursive})
+	if err != nil {
+		mp3util.NodeLogger.Error("Failed ListDirectory: ", err)
+		return nil, err
+	}
+	var entries []*proto.DirEntry
+	for {
+		entry, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
 		}
+		entries = append(entries, entry)
 	}
+	return entries, nil
+}
 
+func printDirectory(entries []*proto.DirEntry) {
 	w := tabwriter.NewWriter(os.Stdout, 1, 4, 4, ' ', 0)
-	fmt.Fprintln(w, "Replica\tFile\tLatest Version\tReadable Version\t")
+	fmt.Fprintln(w, "Path\tFile ID\tLatest Version\tReadable Version\t")
 	fmt.Fprintln(w, "===========\t===========\t===========\t===========\t")
-	for _, replicaAndVer := range replicasWithFile {
-		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t\n", replicaAndVer.ReplicaID.MemberId, args.SdfsFileName,
-			replicaAndVer.Version.UnixNano(), replicaAndVer.Version.Format(time.RFC822))
+	for _, e := range entries {
+		if e.Dir {
+			fmt.Fprintf(w, "%v/\t-\t-\t-\t\n", e.Path)
+			continue
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t\n", e.Path, e.Fileid, e.Version, fsys.VersionTime(e.Version).Format(time.RFC822))
 	}
 	w.Flush()
+}
+
+/*
+ListFiles lists every file in the cluster, with how many replicas have its newest version (see master's ListFiles),
+as a table or, with args.JSON, as JSON.
+*/
+func (c *Client) ListFiles(args schema.CliArgs) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	stream, err := c.masterStub.ListFiles(ctx, &proto.ListFilesRequest{
+		Prefix: args.Prefix,
+		Glob:   args.Glob,
+		After:  args.After,
+		Limit:  int32(args.Limit),
+	})
+	if err != nil {
+		mp3util.NodeLogger.Error("Failed ListFiles: ", err)
+		return err
+	}
+	var files []*proto.FileSummary
+	for {
+		f, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			mp3util.NodeLogger.Error("Failed to recv entire stream of files from master: ", err)
+			return err
+		}
+		files = append(files, f)
+	}
+	more := args.Limit > 0 && len(files) == args.Limit
+
+	if args.JSON {
+		type fileJSON struct {
+			Path            string `json:"path"`
+			FileId          string `json:"fileId"`
+			Version         int64  `json:"version"`
+			Size            int64  `json:"size"`
+			Replicas        int32  `json:"replicas"`
+			Wanted          int32  `json:"wanted"`
+			UnderReplicated bool   `json:"underReplicated"`
+		}
+		out := struct {
+			Files []fileJSON `json:"files"`
+			Next  string     `json:"next,omitempty"` // What to pass as after for the next page
+		}{Files: []fileJSON{}}
+		for _, f := range files {
+			out.Files = append(out.Files, fileJSON{f.Path, f.Fileid, f.Version, f.Size, f.Replicas, f.Wanted, f.Underreplicated})
+		}
+		if more {
+			out.Next = files[len(files)-1].Path
+		}
+		j, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(j))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 4, 4, ' ', 0)
+	fmt.Fprintln(w, "Path\tFile ID\tLatest Version\tSize\tReplicas\t\t")
+	fmt.Fprintln(w, "===========\t===========\t===========\t===========\t===========\t\t")
+	for _, f := range files {
+		flag := ""
+		if f.Underreplicated {
+			flag = "UNDER-REPLICATED"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v/%v\t%v\t\n", f.Path, f.Fileid, f.Version, f.Size, f.Replicas, f.Wanted, flag)
+	}
+	w.Flush()
+	if more {
+		fmt.Printf("More files after %v (use --after %v)\n", files[len(files)-1].Path, files[len(files)-1].Path)
+	}
 	return nil
 }
 
+/*
+Mkdir, Rmdir and Move change the namespace on the master. No data moves: replicas go by file ID, not path.
+*/
+func (c *Client) Mkdir(args schema.CliArgs) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := c.masterStub.Mkdir(ctx, &proto.FileInfo{Sdfsname: args.SdfsFileName})
+	mp3util.NodeLogger.Debugf("Response gotten from master: %v", resp)
+	if err != nil {
+		mp3util.NodeLogger.Error("Error trying to make the directory: ", err)
+	}
+	return err
+}
+
+func (c *Client) Rmdir(args schema.CliArgs) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := c.masterStub.Rmdir(ctx, &proto.FileInfo{Sdfsname: args.SdfsFileName})
+	mp3util.NodeLogger.Debugf("Response gotten from master: %v", resp)
+	if err != nil {
+		mp3util.NodeLogger.Error("Error trying to remove the directory: ", err)
+	}
+	return err
+}
+
+func (c *Client) Move(args schema.CliArgs) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := c.masterStub.Rename(ctx, &proto.RenameInfo{From: args.SdfsFileName, To: args.DestFileName})
+	mp3util.NodeLogger.Debugf("Response gotten from master: %v", resp)
+	if err != nil {
+		mp3util.NodeLogger.Error("Error trying to move: ", err)
+	}
+	return err
+}
+
 /*
 Store doesn't take any arguments. TESTED WORKING.
 */
@@ -495,8 +1382,69 @@ func (c *Client) Store(args schema.CliArgs) error {
 	fmt.Fprintln(w, "File\tVersion\t(Readable Version)\t") // goofy aah whjitspace
 	fmt.Fprintln(w, "===========\t===========\t===========\t")
 	for _, locallyStoredSDFSFile := range resp.FileList {
-		t := time.Unix(0, locallyStoredSDFSFile.Version)
-		fmt.Fprintf(w, "%v\t%v\t%v\t\n", locallyStoredSDFSFile.SDFSFileName, t.UnixNano(), t.Format(time.RFC822))
+		fmt.Fprintf(w, "%v\t%v\t%v\t\n", locallyStoredSDFSFile.SDFSFileName, locallyStoredSDFSFile.Version,
+			fsys.VersionTime(locallyStoredSDFSFile.Version).Format(time.RFC822))
+	}
+	w.Flush()
+	return nil
+}
+
+/*
+History asks the replica on this node why it holds the versions of a file that it does. Like Store, it only looks at
+the local node.
+*/
+func (c *Client) History(args schema.CliArgs) error {
+	err := c.resolve(&args, false)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", "localhost", config.MP3_REPLICA_TCP_PORT), config.DEFAULT_TCP_TIMEOUT)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't connect to replica on self! Error: %v", err)
+		return err
+	}
+	defer conn.Close()
+	err = (&fsys.TCPChannelRequest{
+		RequestType:  fsys.CLIENT_REQ_HISTORY,
+		SDFSFileName: args.FileId,
+	}).Send(conn)
+	if err != nil {
+		mp3util.NodeLogger.Error("Couldn't send request to self replica! Error: ", err)
+		return err
+	}
+
+	resp, err := fsys.RecvTCPChannelResponse(conn)
+	if err != nil {
+		mp3util.NodeLogger.Error("Couldn't get response back from self replica! Error: ", err)
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 3, ' ', 0)
+	fmt.Fprintln(w, "Version\tSize\tContent Hash\tWriter\tRecorded\tReason\t")
+	fmt.Fprintln(w, "===========\t===========\t===========\t===========\t===========\t===========\t")
+	for _, rec := range resp.VersionHistory {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t\n", rec.Version, rec.FileSize, rec.ContentHash, rec.Writer,
+			time.Unix(0, rec.RecordedAt).Format(time.RFC822), rec.Reason)
+	}
+	w.Flush()
+	if resp.Tombstone != 0 {
+		fmt.Printf("Versions of %v at or before %v were deleted.\n", args.SdfsFileName, resp.Tombstone)
+	}
+	return nil
+}
+
+/*
+Ownership prints how much of the data each member is in charge of, as this node's current membership has it.
+*/
+func (c *Client) Ownership(_ schema.CliArgs) error {
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 3, ' ', 0)
+	fmt.Fprintln(w, "Member\tAddress\tDomain\tWeight\tTarget\tPrimary\tReplica\t")
+	fmt.Fprintln(w, "===========\t===========\t===========\t===========\t===========\t===========\t===========\t")
+	membership := schema.Membership()
+	fmt.Printf("Membership epoch %v, %v members\n", membership.Epoch, len(membership.Members))
+	for _, o := range schema.OwnershipReport(membership) {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%.2f%%\t%.2f%%\t%.2f%%\t\n", o.Member.Member_Id, o.Member.Address,
+			schema.FailureDomain(o.Member), o.Weight, 100*o.Target, 100*o.Primary, 100*o.Replica)
 	}
 	w.Flush()
 	return nil
@@ -520,6 +1468,198 @@ func (c *Client) DeleteFile(args schema.CliArgs) error {
 	return nil
 }
 
+/*
+Writes to out what changed from args.Version of the file to args.OtherVersion: a unified diff if they're both text, or
+a summary of how they differ otherwise (see diff.go). Both versions get downloaded first, so that anything going wrong
+with that happens before anything's written.
+*/
+func (c *Client) DiffVersions(args schema.CliArgs, out io.Writer) error {
+	mp3util.NodeLogger.Debug("Entered client.DiffVersions")
+	err := c.resolve(&args, false)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	var versions []int64 // What we got, which for 0 is whatever the latest was
+	for _, version := range []int64{args.Version, args.OtherVersion} {
+		localFileName := fmt.Sprintf("diff-%v-%v", args.FileId, version)
+		args.Version = version
+		got, err := c.getVersion(args, localFileName)
+		if err != nil {
+			return err
+		}
+		p := filepath.Join(fsys.LOCALFILE_DIR, localFileName)
+		defer os.Remove(p)
+		paths = append(paths, p)
+		versions = append(versions, got)
+	}
+	from, to := versions[0], versions[1]
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	aIsText, err := isTextFile(paths[0])
+	if err != nil {
+		return err
+	}
+	bIsText, err := isTextFile(paths[1])
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "--- %v@%v\t%v\n+++ %v@%v\t%v\n", args.SdfsFileName, from, fsys.VersionTime(from).Format(time.RFC3339Nano),
+		args.SdfsFileName, to, fsys.VersionTime(to).Format(time.RFC3339Nano))
+	if err != nil {
+		return err
+	}
+	if aIsText && bIsText {
+		a, err := readLines(paths[0])
+		if err != nil {
+			return err
+		}
+		b, err := readLines(paths[1])
+		if err != nil {
+			return err
+		}
+		// If nothing changed, every op is a line in both, and the summary below says they're identical.
+		ops, ok := diffLines(a, b, config.DIFF_MAX_CHANGED_LINES)
+		if ok && (len(ops) != len(a) || len(ops) != len(b)) {
+			return writeUnifiedDiff(w, a, b, ops)
+		} else if !ok {
+			_, err = fmt.Fprintf(w, "More than %v lines changed, not diffing line by line\n", config.DIFF_MAX_CHANGED_LINES)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		_, err = fmt.Fprintln(w, "Binary versions")
+		if err != nil {
+			return err
+		}
+	}
+	return writeBinaryDiffSummary(w, paths[0], paths[1])
+}
+
+/*
+Finds args.Version of the file (or its latest version, if that's 0) among the replicas for it. Returns the version, its
+metadata (from whichever replica had it) and the replicas that have it.
+*/
+func (c *Client) findVersion(args schema.CliArgs) (int64, fsys.VersionMetadata, []ReplicaMetadata, error) {
+	var meta fsys.VersionMetadata
+	replicas, err := c.GetReplicasNonQuorum(args)
+	if err != nil || len(replicas) == 0 {
+		mp3util.NodeLogger.Error("Client can't get replicas!")
+		if err != nil {
+			return 0, meta, nil, err
+		} else {
+			return 0, meta, nil, errors.New("Length of GetReplicas was zero.")
+		}
+	}
+
+	version := int64(0)
+	var holders []ReplicaMetadata
+	for _, r := range replicas {
+		resp, err := c.queryReplicaForMetadata(args, args.Version, r)
+		if err != nil || args.Version != 0 && resp.SDFSFileVersion != args.Version || resp.SDFSFileVersion < version {
+			continue
+		}
+		if resp.SDFSFileVersion > version {
+			version, holders, meta = resp.SDFSFileVersion, nil, fsys.VersionMetadata{}
+		}
+		holders = append(holders, r)
+		if resp.Metadata != nil && meta.ContentHash == "" {
+			meta = *resp.Metadata
+		}
+	}
+	if len(holders) == 0 {
+		mp3util.NodeLogger.Errorf("No replica has %v @ %v", args.SdfsFileName, args.Version)
+		return 0, meta, nil, os.ErrNotExist
+	}
+	return version, meta, holders, nil
+}
+
+/*
+Prints everything we know about a version of a file: the system metadata and the user's attributes.
+*/
+func (c *Client) Stat(args schema.CliArgs) error {
+	mp3util.NodeLogger.Debug("Entered client.Stat")
+	err := c.resolve(&args, false)
+	if err != nil {
+		return err
+	}
+	version, meta, holders, err := c.findVersion(args)
+	if err != nil {
+		return err
+	}
+	var holderIds []string
+	for _, r := range holders {
+		holderIds = append(holderIds, r.MemberId)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Path:\t%v\n", args.SdfsFileName)
+	fmt.Fprintf(w, "File ID:\t%v\n", args.FileId)
+	fmt.Fprintf(w, "Version:\t%v (%v)\n", version, fsys.VersionTime(version).Format(time.RFC822))
+	fmt.Fprintf(w, "Size:\t%v bytes (%v compressed)\n", meta.Size, meta.FileSize)
+	fmt.Fprintf(w, "Content hash:\t%v\n", meta.ContentHash)
+	fmt.Fprintf(w, "Local name:\t%v\n", meta.LocalFileName)
+	fmt.Fprintf(w, "MIME type:\t%v\n", meta.MimeType)
+	fmt.Fprintf(w, "Uploader:\t%v\n", meta.Writer)
+	fmt.Fprintf(w, "Replicas:\t%v\n", strings.Join(holderIds, ", "))
+	fmt.Fprintf(w, "Attributes:\t%v\n", len(meta.Attrs))
+	var keys []string
+	for key := range meta.Attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %v\t%v\n", key, meta.Attrs[key])
+	}
+	return w.Flush()
+}
+
+/*
+Changes the attributes of args.Version of the file (the latest, if that's 0) on every replica that has it: each key in
+args.Attrs is set, or removed if its value is "".
+*/
+func (c *Client) SetAttr(args schema.CliArgs) error {
+	mp3util.NodeLogger.Debug("Entered client.SetAttr")
+	if err := fsys.CheckAttributes(args.Attrs); err != nil {
+		return err
+	}
+	err := c.resolve(&args, false)
+	if err != nil {
+		return err
+	}
+	version, _, holders, err := c.findVersion(args)
+	if err != nil {
+		return err
+	}
+
+	var updated fsys.VersionMetadata
+	succeeded := 0
+	for _, r := range holders {
+		req := &fsys.TCPChannelRequest{
+			RequestType:     fsys.CLIENT_SET_ATTRS,
+			SDFSFileName:    args.FileId,
+			SDFSFileVersion: version,
+			Attributes:      &fsys.VersionAttributes{Attrs: args.Attrs},
+		}
+		resp, err := UnicastToReplica(req, r)
+		if err != nil {
+			mp3util.NodeLogger.Errorf("Couldn't set attributes on replica %v! Error: %v", r.MemberId, err)
+			continue
+		}
+		if resp.Metadata != nil {
+			updated = *resp.Metadata
+		}
+		succeeded++
+	}
+	if succeeded == 0 {
+		return errors.New(fmt.Sprintf("no replica set the attributes of %v @ %v", args.SdfsFileName, version))
+	}
+	fmt.Printf("%v @ %v on %v of %v replicas: %v\n", args.SdfsFileName, version, succeeded, len(holders), formatAttrs(updated.Attrs))
+	return nil
+}
+
 func (c *Client) GetVersions(args schema.CliArgs) error {
 
 	/* Procedure:
@@ -531,6 +1671,10 @@ func (c *Client) GetVersions(args schema.CliArgs) error {
 	 */
 
 	mp3util.NodeLogger.Debug("Entered client.GetVersions")
+	err := c.resolve(&args, false)
+	if err != nil {
+		return err
+	}
 	replicas, err := c.GetReplicasNonQuorum(args)
 	mp3util.NodeLogger.Debug("Client GETVERSIONS: Received replicas: ", replicas)
 
@@ -545,11 +1689,12 @@ func (c *Client) GetVersions(args schema.CliArgs) error {
 
 	/* Get replica-version pairs */
 	var replicaVersionPairs []ReplicaFileInfo
+	metadata := make(map[int64]*fsys.VersionMetadata)
 	for _, r := range replicas {
 		req := &fsys.TCPChannelRequest{
 			RequestType:     fsys.CLIENT_REQ_KVERSIONS,
-			SDFSFileVersion: time.Now().UnixNano(),
-			SDFSFileName:    args.SdfsFileName,
+			SDFSFileVersion: fsys.LATEST_VERSION,
+			SDFSFileName:    args.FileId,
 			KVersions:       args.NumVersions,
 		}
 
@@ -564,8 +1709,11 @@ func (c *Client) GetVersions(args schema.CliArgs) error {
 		for _, file := range kFiles {
 			replicaVersionPairs = append(replicaVersionPairs, ReplicaFileInfo{
 				ReplicaID: r,
-				Version:   time.Unix(0, file.Version),
+				Version:   file.Version,
 			})
+			if file.Metadata != nil {
+				metadata[file.Version] = file.Metadata
+			}
 		}
 	}
 
@@ -573,7 +1721,7 @@ func (c *Client) GetVersions(args schema.CliArgs) error {
 	sort.Slice(replicaVersionPairs, func(i, j int) bool {
 		a := replicaVersionPairs[i].Version
 		b := replicaVersionPairs[j].Version
-		return a.After(b)
+		return a > b
 	})
 
 	numVersions := args.NumVersions
@@ -585,25 +1733,35 @@ func (c *Client) GetVersions(args schema.CliArgs) error {
 	ver := 0
 
 	fetchedVersionMap := make(map[int64]bool)
+	w := tabwriter.NewWriter(os.Stdout, 1, 4, 4, ' ', 0)
+	fmt.Fprintln(w, "Local File\tVersion\tReadable Version\tSize\tMIME Type\tUploader\tAttributes\t")
+	fmt.Fprintln(w, "===========\t===========\t===========\t===========\t===========\t===========\t===========\t")
+	defer w.Flush()
 
 	mp3util.NodeLogger.Debug("GETVERSIONS replicaVersionPairs: ", replicaVersionPairs)
 	for k := 0; k < len(replicaVersionPairs); k++ {
 		fileName := fmt.Sprintf("%s-version-%d", args.LocalFileName, ver)
 		repVersionPair := replicaVersionPairs[k]
-		mp3util.NodeLogger.Debugf("GETVERSIONS ver/replica/time = %v/%v/%v", ver, repVersionPair.ReplicaID, repVersionPair.Version.UnixNano())
-		if fetchedVersionMap[repVersionPair.Version.UnixNano()] {
+		mp3util.NodeLogger.Debugf("GETVERSIONS ver/replica/time = %v/%v/%v", ver, repVersionPair.ReplicaID, repVersionPair.Version)
+		if fetchedVersionMap[repVersionPair.Version] {
 			mp3util.NodeLogger.Warnf("Already fetched file/version = %v/%v. Skipping replica: %v", args.SdfsFileName, repVersionPair.Version, repVersionPair.ReplicaID)
 			continue
 		}
 
-		err = c.ReceiveFileFromReplica(args.SdfsFileName, fileName, repVersionPair)
+		err = c.ReceiveFileFromReplica(args.FileId, fileName, repVersionPair)
 		if err != nil {
 			mp3util.NodeLogger.Warnf("Failed to contact replica %v for file, version =  %v, %v",
-				repVersionPair.ReplicaID, repVersionPair.Version)
+				repVersionPair.ReplicaID, repVersionPair.Version, err)
 			continue
 		}
 
-		fetchedVersionMap[repVersionPair.Version.UnixNano()] = true
+		fetchedVersionMap[repVersionPair.Version] = true
+		meta := fsys.VersionMetadata{}
+		if metadata[repVersionPair.Version] != nil {
+			meta = *metadata[repVersionPair.Version]
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n", fileName, repVersionPair.Version,
+			fsys.VersionTime(repVersionPair.Version).Format(time.RFC822), meta.Size, meta.MimeType, meta.Writer, formatAttrs(meta.Attrs))
 
 		ver++
 		if ver == args.NumVersions {
@@ -624,7 +1782,7 @@ func (c *Client) GetVersions(args schema.CliArgs) error {
 
 func (c *Client) GetVersionsBruh(localFileName string, latestVersion int) {
 	mp3util.NodeLogger.Warn("This was never meant to beeeeeeeeeeeeeeeeeee")
-	fmt.Println("You are bad for calling this command.\n")
+	fmt.Print("You are bad for calling this command.\n\n")
 	fmt.Printf("OOOOOOOOOOOOOOOOO\n")
 
 	var cmd []string
@@ -12,10 +12,51 @@ var PASSIVE_REPLICATION_PERIOD = time.Second * 10 // Currently unused
 var MP3_REPLICA_TCP_PORT = "7780"                 // TCP
 var MP3_REPLICA_GRPC_PORT = "7781"                // GRPC
 var RING_SIZE = 32                                // For chord-style file partitioning
+var PLACEMENT_STRATEGY = "chord"                  // Where files go: "chord", "rendezvous" or "jump" (see schema/placement.go)
+var VNODES_PER_MEMBER = 16                        // Tokens (virtual nodes) each member gets on the chord ring. 1 is plain chord.
+var MEMBER_WEIGHTS = map[string]float64{}         // By address: members with more disk get proportionally more tokens
+var SPREAD_ACROSS_DOMAINS = true                  // Replicas (and quorums) go to as many failure domains as possible...
+var FAILURE_DOMAINS = map[string]string{}         // ...which are these, by address, for members MP2 doesn't have a Zone for...
+var FAILURE_DOMAINS_FILE = "failure_domains.json" // ...loaded from here at startup
 var NUM_REPLICAS = 5
 var QUORUM_SIZE = 4
 var READ_CONSISTENCY = 2
 var NO_PARTITIONING_DEBUG = false
+var ELECTION_TIMEOUT = 1500 * time.Millisecond       // Members call a master election after somewhere between this and twice this without a heartbeat...
+var MASTER_HEARTBEAT_PERIOD = 300 * time.Millisecond // ...which the master sends everyone this often. It steps down after ELECTION_TIMEOUT without reaching a majority.
+var ELECTION_RPC_TIMEOUT = 500 * time.Millisecond    // Votes and heartbeats that take longer than this to answer count as a no
+var MASTER_GROUP = []string{}                        // Addresses of the members that elect the master and keep its log. Has to be set, the same on every node.
+var MASTER_COMMIT_TIMEOUT = 3 * time.Second          // The master gives up on a request if a majority of the group hasn't logged its decision by then
+var MASTER_LOG_MAX_APPEND = 256                      // Most master log entries sent to a follower at once
+var MASTER_LOG_SNAPSHOT_INTERVAL = 1000              // Compact the master log into a snapshot every this many entries
 var DEFAULT_TCP_TIMEOUT = time.Duration(5 * time.Second)
-var NUM_VERSIONS = 5
+var NUM_VERSIONS = 5                           // Versions kept of files no retention policy covers...
+var RETENTION_POLICIES_FILE = "retention.json" // ...policies (see fsys/retention.go) being loaded from here at startup
+var RETENTION_PERIOD = 10 * time.Second        // How often replicas drop the versions their files' policies don't keep
 var COLLECT_STATS = true
+var RECOVER_SDFS_STORAGE = true      // Keep (and verify) sdfs/ across restarts instead of wiping it
+var TMPFILE_ORPHAN_AGE = time.Minute // Tmpfiles older than this at boot are never getting finalized
+var JOURNAL_SNAPSHOT_INTERVAL = 1000 // Snapshot the storage journal (and start a fresh log) every this many entries
+var CHUNK_MIN_SIZE = 256 * 1024      // Content-defined chunking: no chunk (but the last) is smaller than this
+var CHUNK_AVG_SIZE = 1024 * 1024     // ...they come out about this big on average
+var CHUNK_MAX_SIZE = 4 * 1024 * 1024
+var SCRUB_PERIOD = 30 * time.Second         // How often the scrubber wakes up...
+var SCRUB_BYTES_PER_PASS = int64(64 << 20)  // ...to re-read about this much of what we store...
+var SCRUB_BYTES_PER_SECOND = int64(8 << 20) // ...no faster than this
+var UPLOAD_CHUNK_SIZE = int64(4 << 20)      // putfile sends (and checksums) the gzip this much at a time
+var UPLOAD_MAX_CHUNK_SIZE = int64(64 << 20) // Replicas refuse bigger chunks than this
+var UPLOAD_MAX_ATTEMPTS = 5                 // Reconnect (and resume) this many times before giving up on a replica
+var UPLOAD_SESSION_TTL = 10 * time.Minute   // Replicas throw away uploads that have been idle this long
+var UPLOAD_CHUNK_TIMEOUT = 30 * time.Second // A chunk that takes longer than this to go through counts as a dropped connection
+var PUT_CHAIN_REPLICATION = false           // putfile uploads to one replica, which streams it down a chain to the others
+var PUT_PARALLEL_UPLOADS = true             // putfile uploads to every replica at once, and is done once QUORUM_SIZE of them have it...
+var PUT_STRAGGLER_GRACE = 2 * time.Second   // ...plus this long for the rest to catch up before they get abandoned
+var READ_REPAIR = true                      // getfile has the replica with the latest version push it to the replicas it found lagging
+var HINT_DELIVERY_PERIOD = 2 * time.Second  // How often replicas try to hand off writes they're holding for unreachable replicas...
+var HINT_TTL = time.Hour                    // ...and how long before they give up (regular replication will get there anyway)
+var ANTI_ENTROPY = true                     // Replicas periodically compare Merkle trees of the files they share with each other replica...
+var ANTI_ENTROPY_PERIOD = 20 * time.Second  // ...this often (and whenever the membership changes)...
+var MERKLE_DEPTH = 10                       // ...with trees this deep, i.e. 1<<MERKLE_DEPTH leaves per tree
+var OWNERSHIP_SAMPLES = 20000               // How many made-up files the ownership report places to see who gets what
+var DIFF_MAX_TEXT_SIZE = int64(16 << 20)    // diffversions only diffs text this big or smaller line by line...
+var DIFF_MAX_CHANGED_LINES = 2000           // ...with no more than this many lines added or removed. Otherwise it just summarizes.
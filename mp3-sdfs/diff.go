@@ -0,0 +1,271 @@
+package amogus
+
+import (
+	"amogus/config"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+/*
+What diffversions shows: a unified diff (with DIFF_CONTEXT_LINES of context) if both versions are text, or else a
+summary of where and how much they differ. Text diffs are Myers' O(ND) algorithm over lines, which needs about D^2
+memory for D changed lines, so past config.DIFF_MAX_CHANGED_LINES we give up on it and summarize instead. Same for
+text bigger than config.DIFF_MAX_TEXT_SIZE.
+*/
+
+const DIFF_CONTEXT_LINES = 3
+
+type diffOp struct {
+	kind byte // ' ' (in both), '-' (only in a) or '+' (only in b)
+	a, b int  // Line indexes in a and b; the one a line isn't in is where it would go
+}
+
+/*
+Whether the file at p looks like text: not too big, valid UTF-8 and no NULs.
+*/
+func isTextFile(p string) (bool, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() > config.DIFF_MAX_TEXT_SIZE {
+		return false, nil // Without reading all of it
+	}
+	contents, err := os.ReadFile(p)
+	if err != nil {
+		return false, err
+	}
+	return int64(len(contents)) <= config.DIFF_MAX_TEXT_SIZE && utf8.Valid(contents) && bytes.IndexByte(contents, 0) < 0, nil
+}
+
+func readLines(p string) ([]string, error) {
+	contents, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for len(contents) > 0 {
+		end := bytes.IndexByte(contents, '\n') + 1
+		if end == 0 {
+			end = len(contents)
+		}
+		lines = append(lines, string(contents[:end]))
+		contents = contents[end:]
+	}
+	return lines, nil
+}
+
+/*
+The shortest edit script from a to b, or false if that takes more than maxEdits inserted and deleted lines.
+*/
+func diffLines(a []string, b []string, maxEdits int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	maxD := n + m
+	if maxD > maxEdits {
+		maxD = maxEdits
+	}
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3) // v[offset+k]: how far along a the furthest path on diagonal k (x - y = k) got
+	var trace [][]int          // v, from -d to d, after each d
+	done := false
+	for d := 0; d <= maxD && !done; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Down, i.e. insert b[y-1]
+			} else {
+				x = v[offset+k-1] + 1 // Right, i.e. delete a[x-1]
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		trace = append(trace, append([]int{}, v[offset-d:offset+d+1]...))
+	}
+	if !done {
+		return nil, false
+	}
+
+	/* Walk back from the end, through the d's, to the start */
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int {
+			return prev[k+d-1]
+		}
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			ops = append(ops, diffOp{' ', x, y})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, diffOp{'+', x, y})
+		} else {
+			x--
+			ops = append(ops, diffOp{'-', x, y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		ops = append(ops, diffOp{' ', x, y})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
+
+/*
+Writes ops as unified diff hunks.
+*/
+func writeUnifiedDiff(w io.Writer, a []string, b []string, ops []diffOp) error {
+	for start := 0; start < len(ops); {
+		/* Find the next change, and the end of its hunk: where there are more than 2*context unchanged lines in a row */
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		end, unchanged := start, 0
+		for end < len(ops) && unchanged <= 2*DIFF_CONTEXT_LINES {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		end -= unchanged
+		from, to := start-DIFF_CONTEXT_LINES, end+DIFF_CONTEXT_LINES
+		if from < 0 {
+			from = 0
+		}
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		aLines, bLines := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aLines++
+			}
+			if op.kind != '-' {
+				bLines++
+			}
+		}
+		_, err := fmt.Fprintf(w, "@@ -%v +%v @@\n", hunkRange(ops[from].a, aLines), hunkRange(ops[from].b, bLines))
+		if err != nil {
+			return err
+		}
+		for _, op := range ops[from:to] {
+			line := b[op.b]
+			if op.kind == '-' {
+				line = a[op.a]
+			}
+			_, err = fmt.Fprintf(w, "%c%v", op.kind, line)
+			if err == nil && line[len(line)-1] != '\n' {
+				_, err = fmt.Fprint(w, "\n\\ No newline at end of file\n")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		start = end
+	}
+	return nil
+}
+
+func hunkRange(start int, lines int) string {
+	if lines == 0 {
+		return fmt.Sprintf("%v,0", start)
+	}
+	if lines == 1 {
+		return fmt.Sprintf("%v", start+1)
+	}
+	return fmt.Sprintf("%v,%v", start+1, lines)
+}
+
+/*
+For versions we can't (or won't) diff line by line: their sizes, where they first differ, and how many of the bytes
+they both have differ.
+*/
+func writeBinaryDiffSummary(w io.Writer, aPath string, bPath string) error {
+	af, err := os.Open(aPath)
+	if err != nil {
+		return err
+	}
+	defer af.Close()
+	bf, err := os.Open(bPath)
+	if err != nil {
+		return err
+	}
+	defer bf.Close()
+
+	ar, br := bufio.NewReader(af), bufio.NewReader(bf)
+	var aSize, bSize, differing int64
+	firstDifference := int64(-1)
+	for {
+		ab, aErr := ar.ReadByte()
+		bb, bErr := br.ReadByte()
+		if aErr == nil {
+			aSize++
+		}
+		if bErr == nil {
+			bSize++
+		}
+		if aErr != nil || bErr != nil {
+			if aErr != nil && aErr != io.EOF {
+				return aErr
+			}
+			if bErr != nil && bErr != io.EOF {
+				return bErr
+			}
+			if aErr == io.EOF && bErr == io.EOF {
+				break
+			}
+			if firstDifference < 0 {
+				firstDifference = min64(aSize, bSize) // One of them ended
+			}
+			continue
+		}
+		if ab != bb {
+			differing++
+			if firstDifference < 0 {
+				firstDifference = aSize - 1
+			}
+		}
+	}
+	if firstDifference < 0 {
+		_, err = fmt.Fprintf(w, "Identical (%v bytes)\n", aSize)
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Sizes: %v -> %v bytes (%+d)\nFirst difference at byte %v\n%v of the first %v bytes differ\n",
+		aSize, bSize, bSize-aSize, firstDifference, differing, min64(aSize, bSize))
+	return err
+}
+
+func min64(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,385 @@
+package amogus
+
+import (
+	"amogus/config"
+	"amogus/fsys"
+	"amogus/mp3util"
+	"amogus/schema"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+Master election, Raft style (the election half of it, anyway). Time is split into terms, and each term has at most one
+master. Members follow whoever heartbeats them with the newest term; one that goes ELECTION_TIMEOUT (give or take, it's
+randomized so they don't all go at once) without hearing a heartbeat bumps the term and asks everyone in the membership
+for their vote. Nobody votes twice in a term, so at most one candidate gets a majority.
+
+Every request the master sends replicas carries its term, and replicas refuse ones from older terms than the newest
+they've seen, so once a majority has moved on a deposed master can't finalize anything. It also steps down by itself
+when it goes ELECTION_TIMEOUT without a majority answering its heartbeats.
+
+Only the master group (config.MASTER_GROUP, which every node has to be started with) votes and runs, and "majority" is
+of the group. The group also keeps the master's log (masterlog.go), and nobody votes for a candidate whose log is behind
+theirs, so whoever wins has every decision a majority logged.
+*/
+
+type electionRole int
+
+const (
+	FOLLOWER electionRole = iota
+	CANDIDATE
+	LEADER
+)
+
+type Election struct {
+	storage  *fsys.LocalSDFSStorage // Where the term and our vote are saved
+	term     uint64
+	votedFor string // Member ID we voted for this term, if we did
+	role     electionRole
+	leader   string    // Member ID of the master of term, if we know who that is
+	deadline time.Time // Followers and candidates: when to call an election. Master: when to give up on reaching a majority.
+	onChange []func()  // Called whenever we become or stop being master
+	mtx      sync.Mutex
+
+	log         *fsys.MasterLog
+	state       fsys.MasterState    // The log applied up to commitIndex
+	commitIndex uint64              // Newest entry we know a majority of the group has
+	clock       fsys.HLC            // Hands out versions. Has seen every version in the log, committed or not.
+	peers       map[string]*logPeer // Master: how far along the rest of the group is, by address
+	committed   *sync.Cond          // Broadcast when commitIndex moves, or the term does
+	kick        chan bool           // Master: replicate now instead of at the next heartbeat
+}
+
+type logPeer struct {
+	next  uint64 // The next entry to send it
+	match uint64 // The newest entry we know it has
+}
+
+func NewElection(storage *fsys.LocalSDFSStorage) *Election {
+	if len(config.MASTER_GROUP) == 0 {
+		// Raft needs the same voters all along, and the membership comes and goes (see masterGroup)
+		mp3util.NodeLogger.Fatal("No master group configured, can't keep the master log without one")
+	}
+	e := &Election{storage: storage, kick: make(chan bool, 1)}
+	e.committed = sync.NewCond(&e.mtx)
+	st, err := storage.ElectionState()
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't read the saved election state, starting from term 0: %v", err)
+	}
+	e.term, e.votedFor = st.Term, st.VotedFor
+	e.log, err = storage.OpenMasterLog()
+	if err != nil {
+		mp3util.NodeLogger.Fatal("Couldn't open the master log: ", err)
+	}
+	snap := e.log.Snapshot()
+	e.state, e.commitIndex = snap.State, snap.Index
+	e.clock.Observe(e.state.LastVersion)
+	for _, entry := range e.log.Entries(snap.Index+1, int(e.log.LastIndex()-snap.Index)) {
+		e.clock.Observe(entry.Version)
+	}
+	e.resetDeadline()
+	return e
+}
+
+/*
+Has f called every time we become master or stop being it. It runs on its own goroutine, since whatever noticed the
+change (the master, when a replica turns it away) may be holding locks f wants; so by the time it runs, things may have
+changed again, and it should look at LeaderTerm rather than assume.
+*/
+func (e *Election) OnLeadershipChange(f func()) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.onChange = append(e.onChange, f)
+}
+
+func (e *Election) notify() {
+	e.mtx.Lock()
+	callbacks := append([]func(){}, e.onChange...)
+	e.mtx.Unlock()
+	for _, f := range callbacks {
+		go f()
+	}
+}
+
+/*
+Our current term, and whether we're its master.
+*/
+func (e *Election) LeaderTerm() (uint64, bool) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.term, e.role == LEADER
+}
+
+func (e *Election) IsLeader() bool {
+	_, leader := e.LeaderTerm()
+	return leader
+}
+
+/*
+The member ID of the master, as far as we know ("" if we don't), and its term.
+*/
+func (e *Election) Leader() (string, uint64) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.leader, e.term
+}
+
+/*
+NOTE: ASSUMES CALLER GRABS LOCK (and so do the rest of the lowercase ones)
+*/
+func (e *Election) resetDeadline() {
+	e.deadline = time.Now().Add(config.ELECTION_TIMEOUT + time.Duration(rand.Int63n(int64(config.ELECTION_TIMEOUT))))
+}
+
+func (e *Election) save() error {
+	err := e.storage.SaveElectionState(fsys.ElectionState{Term: e.term, VotedFor: e.votedFor})
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't save election state for term %v: %v", e.term, err)
+	}
+	return err
+}
+
+/*
+Moves on to term, which is newer than ours, as a follower that hasn't voted in it yet. Returns whether we were master.
+*/
+func (e *Election) adoptTerm(term uint64) bool {
+	wasLeader := e.role == LEADER
+	if wasLeader {
+		mp3util.NodeLogger.Warnf("Term %v has started, no longer master of term %v", term, e.term)
+	}
+	e.term = term
+	e.votedFor = ""
+	e.role = FOLLOWER
+	e.leader = ""
+	e.save()
+	if wasLeader {
+		e.resetDeadline()
+	}
+	e.committed.Broadcast()
+	return wasLeader
+}
+
+/*
+Takes note of term, from anyone: if it's newer than ours, whatever we were doing in our term is over.
+*/
+func (e *Election) ObserveTerm(term uint64) {
+	e.mtx.Lock()
+	changed := false
+	if term > e.term {
+		changed = e.adoptTerm(term)
+	}
+	e.mtx.Unlock()
+	if changed {
+		e.notify()
+	}
+}
+
+/*
+Whether to go through with a request the master of term sent us. Ones from before the newest term we know of are from a
+master that's been replaced.
+*/
+func (e *Election) AcceptMasterTerm(term uint64) bool {
+	e.mtx.Lock()
+	current := e.term
+	e.mtx.Unlock()
+	if term < current {
+		return false
+	}
+	e.ObserveTerm(term)
+	return true
+}
+
+/*
+The membership changed. If the master isn't in it anymore there's no point waiting out the timeout for its heartbeats.
+*/
+func (e *Election) MembershipChanged() {
+	membership := schema.Membership()
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.role != FOLLOWER || e.leader == "" {
+		return
+	}
+	if _, ok := membership.Lookup(e.leader); !ok {
+		mp3util.NodeLogger.Infof("Master %v of term %v left the membership, calling an election", e.leader, e.term)
+		e.leader = ""
+		e.deadline = time.Now()
+	}
+}
+
+func (e *Election) HandleRequestVote(req fsys.TCPChannelRequest) *fsys.TCPChannelResponse {
+	e.mtx.Lock()
+	changed := false
+	if req.Term > e.term {
+		changed = e.adoptTerm(req.Term)
+	}
+	resp := &fsys.TCPChannelResponse{ResponseCode: fsys.OK, Term: e.term}
+	membership := schema.Membership()
+	if req.Term < e.term {
+		resp.ResponseCode = fsys.STALE_TERM
+	} else if !inMasterGroup(membership.Self) {
+		// Not ours to decide
+	} else if req.LastLogTerm < e.log.LastTerm() || (req.LastLogTerm == e.log.LastTerm() && req.LastLogIndex < e.log.LastIndex()) {
+		mp3util.NodeLogger.Debugf("%v's log (%v@%v) is behind ours (%v@%v), not voting for it", req.Candidate,
+			req.LastLogIndex, req.LastLogTerm, e.log.LastIndex(), e.log.LastTerm())
+	} else if e.votedFor == "" || e.votedFor == req.Candidate {
+		e.votedFor = req.Candidate
+		if e.save() == nil {
+			resp.VoteGranted = true
+			e.resetDeadline()
+		} else {
+			e.votedFor = ""
+		}
+	}
+	mp3util.NodeLogger.Debugf("Vote for %v in term %v: %v", req.Candidate, req.Term, resp.VoteGranted)
+	e.mtx.Unlock()
+	if changed {
+		e.notify()
+	}
+	return resp
+}
+
+func (e *Election) HandleHeartbeat(req fsys.TCPChannelRequest) *fsys.TCPChannelResponse {
+	e.mtx.Lock()
+	changed := false
+	if req.Term > e.term {
+		changed = e.adoptTerm(req.Term)
+	}
+	resp := &fsys.TCPChannelResponse{ResponseCode: fsys.OK, Term: e.term}
+	if req.Term < e.term {
+		resp.ResponseCode = fsys.STALE_TERM
+	} else {
+		/* Only one master per term, so if we were running in this one, we lost */
+		if e.leader != req.Candidate {
+			mp3util.NodeLogger.Infof("Following master %v of term %v", req.Candidate, req.Term)
+		}
+		e.role = FOLLOWER
+		e.leader = req.Candidate
+		e.resetDeadline()
+		membership := schema.Membership()
+		if inMasterGroup(membership.Self) {
+			e.appendFromMaster(req, resp)
+		}
+	}
+	e.mtx.Unlock()
+	if changed {
+		e.notify()
+	}
+	return resp
+}
+
+/*
+Runs the election forever: replicates the log to the group (and heartbeats everyone else) while we're master, otherwise
+waits for the deadline and runs for master.
+*/
+func (e *Election) Run() {
+	for {
+		e.mtx.Lock()
+		role, wait := e.role, time.Until(e.deadline)
+		e.mtx.Unlock()
+		if role == LEADER {
+			e.replicate()
+			select {
+			case <-time.After(config.MASTER_HEARTBEAT_PERIOD):
+			case <-e.kick:
+			}
+		} else if wait <= 0 {
+			e.campaign()
+		} else {
+			// Not all in one go, MembershipChanged might move the deadline up
+			if wait > config.MASTER_HEARTBEAT_PERIOD {
+				wait = config.MASTER_HEARTBEAT_PERIOD
+			}
+			time.Sleep(wait)
+		}
+	}
+}
+
+func (e *Election) campaign() {
+	membership := schema.Membership()
+	self := membership.Self.Member_Id
+	group, size := masterGroup(membership)
+	e.mtx.Lock()
+	e.resetDeadline()
+	if _, ok := membership.Lookup(self); !ok || !inMasterGroup(membership.Self) {
+		// Not in the membership (yet) or not in the group, nobody would count us
+		e.mtx.Unlock()
+		return
+	}
+	e.term++
+	e.role = CANDIDATE
+	e.votedFor = self
+	e.leader = ""
+	err := e.save()
+	term := e.term
+	req := &fsys.TCPChannelRequest{RequestType: fsys.ELECTION_REQUEST_VOTE, Term: term, Candidate: self,
+		LastLogIndex: e.log.LastIndex(), LastLogTerm: e.log.LastTerm()}
+	if err != nil {
+		e.role = FOLLOWER
+		e.votedFor = ""
+	}
+	e.mtx.Unlock()
+	if err != nil {
+		return
+	}
+
+	mp3util.NodeLogger.Infof("Running for master in term %v", term)
+	votes := 1
+	for _, resp := range e.broadcast(req, group) {
+		if resp.Term > term {
+			e.ObserveTerm(resp.Term)
+			return
+		}
+		if resp.VoteGranted {
+			votes++
+		}
+	}
+
+	e.mtx.Lock()
+	won := e.role == CANDIDATE && e.term == term && votes > size/2
+	if won {
+		e.role = LEADER
+		e.leader = self
+		e.deadline = time.Now().Add(config.ELECTION_TIMEOUT)
+		e.peers = make(map[string]*logPeer)
+		/* Entries from earlier terms only count as committed once one from ours is, so get one in right away */
+		err = e.appendAsMaster(fsys.MasterLogEntry{Op: fsys.MASTER_OP_NOOP})
+		if err != nil {
+			mp3util.NodeLogger.Errorf("Couldn't start term %v's log: %v", term, err)
+		}
+		// Only does anything if we're the whole group; otherwise it waits for the others to have it
+		e.advanceCommit(size)
+	}
+	e.mtx.Unlock()
+	if !won {
+		mp3util.NodeLogger.Infof("Got %v of %v votes in term %v, not master", votes, size, term)
+		return
+	}
+	mp3util.NodeLogger.Infof("Elected master of term %v with %v of %v votes", term, votes, size)
+	e.notify()
+}
+
+/*
+Sends req to each of peers at once, and returns the answers that came back within ELECTION_RPC_TIMEOUT.
+*/
+func (e *Election) broadcast(req *fsys.TCPChannelRequest, peers []ReplicaMetadata) []*fsys.TCPChannelResponse {
+	var resps []*fsys.TCPChannelResponse
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer ReplicaMetadata) {
+			defer wg.Done()
+			resp, err := unicastToReplicaAnyCode(req, peer, config.ELECTION_RPC_TIMEOUT)
+			if err != nil {
+				return
+			}
+			mtx.Lock()
+			resps = append(resps, resp)
+			mtx.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+	return resps
+}
@@ -0,0 +1,82 @@
+package fsys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+User-defined attributes: key/value pairs that go with a version, set at putfile and changed later with setattr. They're
+kept in the version's metadata (see metadata.go), next to the system metadata (sizes, content hash, uploader, MIME type)
+that the replica fills in itself. setattr only changes the replicas that have the version at the time; replication
+carries whatever a replica has to the ones that don't have the version yet.
+*/
+
+const MAX_ATTRS = 64
+const MAX_ATTR_KEY_LENGTH = 255
+const MAX_ATTR_VALUE_LENGTH = 4096
+
+/*
+Whether attrs can be stored. An empty value is fine; in a setattr, that removes the key.
+*/
+func CheckAttributes(attrs map[string]string) error {
+	if len(attrs) > MAX_ATTRS {
+		return errors.New(fmt.Sprintf("more than %v attributes", MAX_ATTRS))
+	}
+	for key, value := range attrs {
+		switch {
+		case key == "":
+			return errors.New("attribute with an empty key")
+		case len(key) > MAX_ATTR_KEY_LENGTH:
+			return errors.New(fmt.Sprintf("attribute key %.20q... is longer than %v bytes", key, MAX_ATTR_KEY_LENGTH))
+		case strings.ContainsAny(key, "=\x00"):
+			return errors.New(fmt.Sprintf("attribute key %q has a = or NUL in it", key))
+		case len(value) > MAX_ATTR_VALUE_LENGTH:
+			return errors.New(fmt.Sprintf("value of attribute %q is longer than %v bytes", key, MAX_ATTR_VALUE_LENGTH))
+		}
+	}
+	return nil
+}
+
+/*
+Applies changes to the attributes of sdfsFileName @ version: each key is set to its value, or removed if the value is
+empty. Returns the version's metadata as it is afterwards.
+*/
+func (s *LocalSDFSStorage) SetVersionAttributes(sdfsFileName string, version int64, changes map[string]string) (VersionMetadata, error) {
+	if err := CheckSDFSFileName(sdfsFileName); err != nil {
+		return VersionMetadata{}, err
+	}
+	if err := CheckAttributes(changes); err != nil {
+		return VersionMetadata{}, err
+	}
+	s.attrsMtx.Lock()
+	defer s.attrsMtx.Unlock()
+	if _, err := s.ReadManifest(sdfsFileName, version); err != nil {
+		return VersionMetadata{}, os.ErrNotExist
+	}
+	meta, err := s.ReadVersionMetadata(sdfsFileName, version)
+	if err != nil {
+		return meta, err
+	}
+	attrs := make(map[string]string)
+	for key, value := range meta.Attrs {
+		attrs[key] = value
+	}
+	for key, value := range changes {
+		if value == "" {
+			delete(attrs, key)
+		} else {
+			attrs[key] = value
+		}
+	}
+	if err := CheckAttributes(attrs); err != nil {
+		return meta, err
+	}
+	meta.Attrs = attrs
+	if len(attrs) == 0 {
+		meta.Attrs = nil
+	}
+	return meta, s.writeVersionMetadata(sdfsFileName, version, meta)
+}
@@ -0,0 +1,543 @@
+package fsys
+
+import (
+	"amogus/config"
+	"amogus/mp3util"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const CHUNK_DIR = "chunkDir"
+
+/*
+One chunk of a version. Offset and Size are in terms of the uncompressed content; CompressedSize is how big the chunk is
+on disk (and on the wire).
+*/
+type ChunkRef struct {
+	Hash           string
+	Offset         int64
+	Size           int64
+	CompressedSize int64
+}
+
+/*
+A version file under storedfileDir is no longer the blob itself, it's one of these (as JSON). The blob is the
+concatenation of the chunks in order. Every chunk is its own gzip member, so the concatenation is still one valid gzip
+stream and can go straight down the wire like before.
+*/
+type ChunkManifest struct {
+	Size           int64 // Uncompressed
+	CompressedSize int64 // Sum of the chunks' CompressedSize, i.e. what a reader of this version gets sent
+	Chunks         []ChunkRef
+}
+
+/*
+ChunkStore keeps every distinct chunk exactly once, keyed by the SHA256 of its uncompressed bytes, no matter how many
+versions (of however many files) point at it:
+
+	-------sdfs/
+			|----chunkDir/
+					|----3f/
+							|----3f9a... (a gzip member)
+
+Reference counts are only kept in memory. They're cheap to rebuild from the manifests, which is what recovery does,
+and that way they can never disagree with the manifests after a crash.
+*/
+type ChunkStore struct {
+	dir       string
+	refcounts map[string]int
+	mtx       sync.Mutex
+}
+
+func newChunkStore(dir string) *ChunkStore {
+	return &ChunkStore{dir: dir, refcounts: make(map[string]int)}
+}
+
+func (c *ChunkStore) chunkPath(hash string) string {
+	return filepath.Join(c.dir, hash[:2], hash)
+}
+
+/*
+Takes one more reference on a chunk we already have. Returns false (and takes nothing) if we don't have it.
+*/
+func (c *ChunkStore) Ref(hash string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, err := os.Stat(c.chunkPath(hash)); err != nil {
+		return false
+	}
+	c.refcounts[hash]++
+	return true
+}
+
+/*
+Stores a compressed chunk (unless we already have it) and takes n references on it.
+*/
+func (c *ChunkStore) Put(hash string, compressed []byte, n int) error {
+	target := c.chunkPath(hash)
+	err := os.MkdirAll(filepath.Dir(target), 0777)
+	if err != nil {
+		return err
+	}
+	// Write outside the lock, under a name nobody else will pick, and only swap it in under the lock.
+	fd, err := os.CreateTemp(filepath.Dir(target), hash+".*.partial")
+	if err != nil {
+		return err
+	}
+	scratch := fd.Name()
+	fd.Close()
+	err = writeFileSynced(scratch, compressed)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't write chunk %v! Error: %v", hash, err)
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, err := os.Stat(target); err == nil {
+		os.Remove(scratch) // Dedup'd!
+	} else {
+		err = os.Rename(scratch, target)
+		if err != nil {
+			os.Remove(scratch)
+			return err
+		}
+	}
+	c.refcounts[hash] += n
+	return nil
+}
+
+/*
+Drops a reference. The chunk is deleted once nothing points at it anymore.
+*/
+func (c *ChunkStore) Unref(hash string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.refcounts[hash]--
+	if c.refcounts[hash] > 0 {
+		return
+	}
+	delete(c.refcounts, hash)
+	err := os.Remove(c.chunkPath(hash))
+	if err != nil && !os.IsNotExist(err) {
+		mp3util.NodeLogger.Warnf("Couldn't remove unreferenced chunk %v! Error: %v", hash, err)
+	}
+}
+
+func (c *ChunkStore) Has(hash string) bool {
+	_, err := os.Stat(c.chunkPath(hash))
+	return err == nil
+}
+
+func (c *ChunkStore) Open(hash string) (*os.File, error) {
+	return os.Open(c.chunkPath(hash))
+}
+
+/*
+Throws away the in-memory reference counts and recounts them from manifests. Chunks that nothing references are
+deleted.
+*/
+func (c *ChunkStore) Rebuild(manifests []ChunkManifest) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.refcounts = make(map[string]int)
+	for _, m := range manifests {
+		for _, ref := range m.Chunks {
+			c.refcounts[ref.Hash]++
+		}
+	}
+	filepath.WalkDir(c.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if c.refcounts[d.Name()] == 0 {
+			mp3util.NodeLogger.Debugf("Removing unreferenced chunk %v", p)
+			os.Remove(p)
+		}
+		return nil
+	})
+	removeEmptyDirs(c.dir)
+}
+
+/*
+Decompresses a stored chunk and checks that it still hashes to its name. Returns the uncompressed size.
+*/
+func (c *ChunkStore) Verify(hash string) (int64, error) {
+	fd, err := c.Open(hash)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	gzipDecoder, err := gzip.NewReader(fd)
+	if err != nil {
+		return 0, err
+	}
+	h := sha256.New()
+	nbytes, err := io.Copy(h, gzipDecoder)
+	if err != nil {
+		return nbytes, err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != hash {
+		return nbytes, errors.New(fmt.Sprintf("chunk %v does not match its hash", hash))
+	}
+	return nbytes, nil
+}
+
+/*
+The gear table for content-defined chunking. It has to be identical on every node (otherwise identical content would be
+chunked differently and never dedup across replicas), so it's derived from a fixed seed with splitmix64.
+*/
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	seed := uint64(0x5344465343484e4b) // "SDFSCHNK"
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+/*
+Where the first chunk of data ends, FastCDC-style: never before CHUNK_MIN_SIZE, always by CHUNK_MAX_SIZE, and in
+between wherever the rolling gear hash has its low bits all zero (which happens about every CHUNK_AVG_SIZE bytes).
+Because the cut only depends on the bytes right before it, an edit early in a file only changes the chunks around the
+edit; everything after resynchronizes.
+*/
+func cutPoint(data []byte) int {
+	if len(data) <= config.CHUNK_MIN_SIZE {
+		return len(data)
+	}
+	end := len(data)
+	if end > config.CHUNK_MAX_SIZE {
+		end = config.CHUNK_MAX_SIZE
+	}
+	mask := uint64(1)
+	for mask < uint64(config.CHUNK_AVG_SIZE) {
+		mask <<= 1
+	}
+	mask--
+	var h uint64
+	for i := config.CHUNK_MIN_SIZE; i < end; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&mask == 0 {
+			return i + 1
+		}
+	}
+	return end
+}
+
+/*
+Splits everything readable from source into content-defined chunks and hands each one to emit, in order.
+*/
+func chunkStream(source io.Reader, emit func(chunk []byte) error) error {
+	buf := make([]byte, 0, 2*config.CHUNK_MAX_SIZE)
+	eof := false
+	emitted := false
+	for {
+		// Top up the buffer so cutPoint can see a whole max-size chunk.
+		for !eof && len(buf) < config.CHUNK_MAX_SIZE {
+			n, err := source.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if len(buf) == 0 && emitted {
+			return nil
+		}
+		if len(buf) == 0 {
+			// An empty file is still one (empty) chunk, otherwise there'd be no gzip stream at all to send.
+			return emit(buf)
+		}
+		cut := cutPoint(buf)
+		err := emit(buf[:cut])
+		if err != nil {
+			return err
+		}
+		emitted = true
+		buf = buf[:copy(buf, buf[cut:])]
+	}
+}
+
+/*
+Chunks the (gzipped) file at p into the chunk store, taking a reference on every chunk. Returns the manifest and the
+SHA256 of the uncompressed content. On error, every reference taken so far is dropped again.
+*/
+func (s *LocalSDFSStorage) chunkGzipFile(p string) (ChunkManifest, string, error) {
+	var manifest ChunkManifest
+	fd, err := os.Open(p)
+	if err != nil {
+		return manifest, "", err
+	}
+	defer fd.Close()
+	gzipDecoder, err := gzip.NewReader(fd)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("%v isn't gzipped! Error: %v", p, err)
+		return manifest, "", err
+	}
+
+	contentHasher := sha256.New()
+	var compressed bytes.Buffer
+	err = chunkStream(gzipDecoder, func(chunk []byte) error {
+		contentHasher.Write(chunk)
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		ref := ChunkRef{Hash: hash, Offset: manifest.Size, Size: int64(len(chunk))}
+		if s.chunks.Ref(hash) {
+			// Already have it; we only need to know how big it is on disk.
+			fi, err := os.Stat(s.chunks.chunkPath(hash))
+			if err != nil {
+				s.chunks.Unref(hash)
+				return err
+			}
+			ref.CompressedSize = fi.Size()
+		} else {
+			compressed.Reset()
+			gzipConverter := gzip.NewWriter(&compressed)
+			_, err := gzipConverter.Write(chunk)
+			if err == nil {
+				err = gzipConverter.Close()
+			}
+			if err == nil {
+				err = s.chunks.Put(hash, compressed.Bytes(), 1)
+			}
+			if err != nil {
+				return err
+			}
+			ref.CompressedSize = int64(compressed.Len())
+		}
+		manifest.Chunks = append(manifest.Chunks, ref)
+		manifest.Size += ref.Size
+		manifest.CompressedSize += ref.CompressedSize
+		return nil
+	})
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't chunk %v! Error: %v", p, err)
+		s.releaseManifest(manifest)
+		return ChunkManifest{}, "", err
+	}
+	return manifest, hex.EncodeToString(contentHasher.Sum(nil)), nil
+}
+
+/*
+Drops the references a manifest holds.
+*/
+func (s *LocalSDFSStorage) releaseManifest(manifest ChunkManifest) {
+	for _, ref := range manifest.Chunks {
+		s.chunks.Unref(ref.Hash)
+	}
+}
+
+func readManifest(p string) (ChunkManifest, error) {
+	var manifest ChunkManifest
+	j, err := os.ReadFile(p)
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(j, &manifest)
+	return manifest, err
+}
+
+func writeManifest(p string, manifest ChunkManifest) error {
+	j, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	err = writeFileSynced(p+".partial", j)
+	if err != nil {
+		return err
+	}
+	return os.Rename(p+".partial", p)
+}
+
+/*
+The manifest of one stored version.
+*/
+func (s *LocalSDFSStorage) ReadManifest(sdfsFileName string, version int64) (ChunkManifest, error) {
+	if err := CheckSDFSFileName(sdfsFileName); err != nil {
+		return ChunkManifest{}, err
+	}
+	return readManifest(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFileName, VersionName(version)))
+}
+
+/*
+Opens a stored chunk for sending it to a peer. The caller closes it.
+*/
+func (s *LocalSDFSStorage) OpenChunk(hash string) (*os.File, error) {
+	return s.chunks.Open(hash)
+}
+
+/*
+ManifestReader reads a version back as the concatenation of its chunks, opening them one at a time as it goes. It holds
+a reference on every chunk until it's closed, so that a delete (or GC, or retention) of the version can't unlink a chunk
+before we get to it.
+*/
+type ManifestReader struct {
+	store    *ChunkStore
+	manifest ChunkManifest
+	held     []string // The chunks we have a reference on
+	next     int
+	current  *os.File
+}
+
+func (s *LocalSDFSStorage) newManifestReader(manifest ChunkManifest) *ManifestReader {
+	m := &ManifestReader{store: s.chunks, manifest: manifest}
+	for _, ref := range manifest.Chunks {
+		// A chunk that's already gone fails when the read gets to it, like it always did
+		if s.chunks.Ref(ref.Hash) {
+			m.held = append(m.held, ref.Hash)
+		}
+	}
+	return m
+}
+
+func (m *ManifestReader) Read(p []byte) (int, error) {
+	for {
+		if m.current == nil {
+			if m.next >= len(m.manifest.Chunks) {
+				return 0, io.EOF
+			}
+			fd, err := m.store.Open(m.manifest.Chunks[m.next].Hash)
+			if err != nil {
+				return 0, err
+			}
+			m.current = fd
+			m.next++
+		}
+		n, err := m.current.Read(p)
+		if err == io.EOF {
+			m.current.Close()
+			m.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (m *ManifestReader) Close() error {
+	for _, hash := range m.held {
+		m.store.Unref(hash)
+	}
+	m.held = nil
+	if m.current != nil {
+		err := m.current.Close()
+		m.current = nil
+		return err
+	}
+	return nil
+}
+
+/*
+Everything a peer needs to know to only send us the chunks we lack: which chunks of the offered manifest we already
+have (those are referenced right away, so they can't get deleted out from under us while the rest is in flight), and
+which ones it has to send.
+*/
+type ChunkReservation struct {
+	s           *LocalSDFSStorage
+	manifest    ChunkManifest
+	occurrences map[string]int
+	Missing     []string
+	held        []string
+}
+
+func (s *LocalSDFSStorage) ReserveChunks(manifest ChunkManifest) *ChunkReservation {
+	res := &ChunkReservation{s: s, manifest: manifest, occurrences: make(map[string]int)}
+	for _, ref := range manifest.Chunks {
+		res.occurrences[ref.Hash]++
+	}
+	for _, ref := range manifest.Chunks {
+		if res.occurrences[ref.Hash] < 0 {
+			continue // Missing, and already listed.
+		}
+		if s.chunks.Ref(ref.Hash) {
+			res.held = append(res.held, ref.Hash)
+			continue
+		}
+		res.Missing = append(res.Missing, ref.Hash)
+		res.occurrences[ref.Hash] = -res.occurrences[ref.Hash] // Mark as missing, but remember how many refs we owe.
+	}
+	return res
+}
+
+/*
+Reads one missing chunk (compressedSize bytes) from source, checks it against its hash and stores it. A chunk that
+doesn't check out is dropped and ErrContentHashMismatch is returned; source is still positioned right after it, so the
+caller can keep reading.
+*/
+func (res *ChunkReservation) Receive(hash string, compressedSize int64, source io.Reader) error {
+	compressed := make([]byte, compressedSize)
+	_, err := io.ReadFull(source, compressed)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	gzipDecoder, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err == nil {
+		_, err = io.Copy(h, gzipDecoder)
+	}
+	if err != nil || hex.EncodeToString(h.Sum(nil)) != hash {
+		mp3util.NodeLogger.Errorf("Received chunk does not match its hash %v! (Error: %v)", hash, err)
+		return ErrContentHashMismatch
+	}
+	n := -res.occurrences[hash]
+	err = res.s.chunks.Put(hash, compressed, n)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		res.held = append(res.held, hash)
+	}
+	return nil
+}
+
+/*
+Gives back every reference the reservation took. Call this if the version never gets registered.
+*/
+func (res *ChunkReservation) Release() {
+	for _, hash := range res.held {
+		res.s.chunks.Unref(hash)
+	}
+	res.held = nil
+}
+
+/*
+Whether every chunk of the manifest is now here (and referenced).
+*/
+func (res *ChunkReservation) Complete() bool {
+	return len(res.held) == len(res.manifest.Chunks)
+}
+
+/*
+The size of a chunk as listed in the manifest.
+*/
+func (m ChunkManifest) CompressedSizeOf(hash string) (int64, bool) {
+	for _, ref := range m.Chunks {
+		if ref.Hash == hash {
+			return ref.CompressedSize, true
+		}
+	}
+	return 0, false
+}
+
+func isPartial(name string) bool {
+	return strings.HasSuffix(name, ".partial")
+}
@@ -4,7 +4,9 @@ import (
 	"amogus/mp3util"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,31 +21,83 @@ const (
 	MISC_ERROR     TCPChannelResponseCode = "INTERNAL_ERROR"
 	FILE_NOT_FOUND TCPChannelResponseCode = "FILE_NOT_FOUND"
 	NOTHING_TO_DO  TCPChannelResponseCode = "NOTHING_TO_DO"
+	CORRUPT        TCPChannelResponseCode = "CONTENT_HASH_MISMATCH"
+	STALE_TERM     TCPChannelResponseCode = "STALE_TERM" // The master (or candidate) asking is from an older term than the replica's
 )
 
+/*
+What you get when the bytes that arrived don't hash to the FileContentHash they were sent with.
+*/
+var ErrContentHashMismatch = errors.New("content hash mismatch")
+
 type TCPChannelRequestType string
 
 const (
 	CLIENT_REQ_FILE_METADATA TCPChannelRequestType = "REQ_FILE_METADATA"
 	CLIENT_REQ_FILE_DATA     TCPChannelRequestType = "REQ_FILE_DATA"
+	CLIENT_REQ_FILE_RANGE    TCPChannelRequestType = "REQ_FILE_RANGE"
 	CLIENT_REQ_KVERSIONS     TCPChannelRequestType = "REQ_K_VERSIONS"
 	CLIENT_SEND_FILE_DATA    TCPChannelRequestType = "SEND_FILE_DATA"
 	CLIENT_LIST_FILES        TCPChannelRequestType = "REQ_LIST_FILES"
+	CLIENT_REQ_HISTORY       TCPChannelRequestType = "REQ_HISTORY"
+	CLIENT_SET_ATTRS         TCPChannelRequestType = "SET_ATTRS"
+	CLIENT_READ_REPAIR       TCPChannelRequestType = "READ_REPAIR"
+	CLIENT_UPLOAD_BEGIN      TCPChannelRequestType = "UPLOAD_BEGIN"
+	CLIENT_UPLOAD_RESUME     TCPChannelRequestType = "UPLOAD_RESUME"
+	CLIENT_UPLOAD_CHUNK      TCPChannelRequestType = "UPLOAD_CHUNK"
+	CLIENT_UPLOAD_COMMIT     TCPChannelRequestType = "UPLOAD_COMMIT"
+	CLIENT_UPLOAD_ABORT      TCPChannelRequestType = "UPLOAD_ABORT"
 	MASTER_FINALIZE_WRITE    TCPChannelRequestType = "FINALIZE_WRITE"
 	MASTER_FINALIZE_DELETE   TCPChannelRequestType = "FINALIZE_DELETE"
+	MASTER_REPAIR_WRITE      TCPChannelRequestType = "REPAIR_WRITE"
 	REPLICA_QUERY_FILES      TCPChannelRequestType = "QUERY_CONTAINED_FILES"
 	REPLICA_SEND_FILE        TCPChannelRequestType = "REPLICA_SEND_FILE"
+	REPLICA_MERKLE_NODES     TCPChannelRequestType = "MERKLE_NODES"
+	REPLICA_MERKLE_LEAVES    TCPChannelRequestType = "MERKLE_LEAVES"
+	ELECTION_REQUEST_VOTE    TCPChannelRequestType = "REQUEST_VOTE"
+	ELECTION_HEARTBEAT       TCPChannelRequestType = "MASTER_HEARTBEAT"
 )
 
 type TCPChannelRequest struct {
 	RequestType       TCPChannelRequestType
 	FileVersionSet    SDFSFileVersionSet
-	SDFSFileVersion   int64
+	SDFSFileVersion   int64 // An HLC version (see version.go), like every version
 	FileSize          int64
-	FileContentHash   string
+	FileContentHash   string // REPLICA_SEND_FILE: SHA256 of the uncompressed content, checked by the receiver
 	SDFSFileName      string
 	KVersions         int
-	UpperVersionBound int64
+	UpperVersionBound int64              // CLIENT_REQ_FILE_DATA: newest version you want. CLIENT_REQ_FILE_METADATA: same, 0 meaning the latest.
+	Writer            string             // Member ID of whoever originally wrote the version being finalized or replicated
+	Manifest          *ChunkManifest     // REPLICA_SEND_FILE: the chunks making up the version being replicated
+	Attributes        *VersionAttributes // MASTER_FINALIZE_WRITE, REPLICA_SEND_FILE: to keep with the version. CLIENT_SET_ATTRS: changes to Attrs, "" removing a key.
+	UploadID          string             // CLIENT_UPLOAD_*: which upload session
+	ChunkOffset       int64              // CLIENT_UPLOAD_CHUNK: where in the upload this chunk goes (FileSize is its length)
+	ChunkChecksum     string             // CLIENT_UPLOAD_CHUNK: SHA256 of the chunk's bytes
+	RangeOffset       int64              // CLIENT_REQ_FILE_RANGE: where the range starts in the uncompressed content
+	RangeLength       int64              // CLIENT_REQ_FILE_RANGE: how many uncompressed bytes you want
+	Chain             []ReplicaAddr      // CLIENT_UPLOAD_BEGIN: replicas to forward the upload to as it comes in, in order
+	RepairTargets     []ReplicaAddr      // MASTER_REPAIR_WRITE, CLIENT_READ_REPAIR: replicas that lack SDFSFileVersion, to push it to
+	MerklePeer        string             // REPLICA_MERKLE_*: member ID of the asking replica. The tree is over the files placed on both of us.
+	MerkleDepth       int                // REPLICA_MERKLE_*: how deep a tree to build over them
+	MerkleNodes       []int              // REPLICA_MERKLE_NODES: which nodes you want the hashes of. REPLICA_MERKLE_LEAVES: which leaves
+	Term              uint64             // MASTER_*, ELECTION_*: the sender's election term. Replicas refuse ones older than the newest they've seen.
+	Candidate         string             // ELECTION_REQUEST_VOTE: member ID of who wants the vote. ELECTION_HEARTBEAT: of the master.
+	LastLogIndex      uint64             // ELECTION_REQUEST_VOTE: the candidate's last master log entry...
+	LastLogTerm       uint64             // ELECTION_REQUEST_VOTE: ...and its term. Nobody votes for a candidate whose log is behind theirs.
+	PrevLogIndex      uint64             // ELECTION_HEARTBEAT: the master log entry right before LogEntries...
+	PrevLogTerm       uint64             // ELECTION_HEARTBEAT: ...and its term, which the follower's log has to agree with
+	LogEntries        []MasterLogEntry   // ELECTION_HEARTBEAT: master log entries to append
+	LogSnapshot       *MasterSnapshot    // ELECTION_HEARTBEAT: the master's log snapshot, for followers too far behind for LogEntries
+	LeaderCommit      uint64             // ELECTION_HEARTBEAT: how much of the log the master knows is committed
+}
+
+/*
+Enough of a replica to reach it by, for requests that point at other replicas (the rest of an upload chain, or who to
+repair).
+*/
+type ReplicaAddr struct {
+	MemberId string
+	Address  string
 }
 
 func (t *TCPChannelRequest) String() string {
@@ -54,9 +108,26 @@ type TCPChannelResponse struct {
 	ResponseCode            TCPChannelResponseCode
 	ReturningSDFSFileSize   int64
 	SDFSFileVersion         int64
-	FileContentHash         string
+	FileContentHash         string // For reads, SHA256 of the uncompressed content. For puts, the tmpfile's name.
 	FileList                []SDFSFile
 	RequestedFileVersionSet SDFSFileVersionSet
+	VersionHistory          []VersionRecord
+	Tombstone               int64
+	Metadata                *VersionMetadata // CLIENT_REQ_FILE_METADATA: of the version found. CLIENT_SET_ATTRS: as it is now.
+	MissingChunks           []string         // REPLICA_SEND_FILE: the chunks the receiver doesn't have yet, in the order to send them
+	UploadID                string           // CLIENT_UPLOAD_BEGIN: the new session
+	UploadOffset            int64            // CLIENT_UPLOAD_*: how much of the upload the replica has, i.e. where to continue from
+	RangeChunks             []ChunkRef       // CLIENT_REQ_FILE_RANGE: the chunks that follow, back to back (ReturningSDFSFileSize bytes total)
+	RangeSkip               int64            // CLIENT_REQ_FILE_RANGE: bytes to throw away from the front of the first chunk
+	RangeLength             int64            // CLIENT_REQ_FILE_RANGE: bytes to keep after that; short if the range ran off the end
+	ChainCommitted          int              // CLIENT_UPLOAD_COMMIT: how many replicas down the chain, this one included, have the tmpfile now
+	MerkleHashes            []string         // REPLICA_MERKLE_NODES: in the order they were asked for
+	MerkleEntries           []MerkleEntry    // REPLICA_MERKLE_LEAVES: everything in the leaves asked for
+	MerkleTombstones        map[string]int64 // REPLICA_MERKLE_LEAVES: of the files in the leaves asked for
+	Term                    uint64           // ELECTION_*, STALE_TERM: the newest term the replica has seen
+	VoteGranted             bool             // ELECTION_REQUEST_VOTE
+	LogMatched              bool             // ELECTION_HEARTBEAT: whether our log agreed at PrevLogIndex (and so took LogEntries)
+	LastLogIndex            uint64           // ELECTION_HEARTBEAT: how far our log matches the master's now, or if it didn't match, how far it goes
 }
 
 func (t *TCPChannelResponse) String() string {
@@ -153,10 +224,26 @@ func RecvTCPChannelRequest(conn io.Reader) (*TCPChannelRequest, error) {
 }
 
 /*
-
 Blocking.
 */
 func RecvTCPChannelResponse(conn io.Reader) (*TCPChannelResponse, error) {
+	resp, err := RecvTCPChannelResponseAnyCode(conn)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ResponseCode != OK {
+		return nil, errors.New(fmt.Sprintf("Received error code: %v", resp.ResponseCode))
+	}
+	return resp, nil
+}
+
+/*
+Like RecvTCPChannelResponse, but a response that isn't OK is still handed back (not turned into an error), for when
+the caller needs to know which error it was or what else came with it.
+
+Blocking.
+*/
+func RecvTCPChannelResponseAnyCode(conn io.Reader) (*TCPChannelResponse, error) {
 	respBytes, err := RecvStructJSON(conn)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("Can't receive a TCPChannelResponse! %v", err))
@@ -167,10 +254,6 @@ func RecvTCPChannelResponse(conn io.Reader) (*TCPChannelResponse, error) {
 		return nil, errors.New(fmt.Sprintf("Couldn't unmarshal a TCPChannelResponse! %v", err))
 	}
 	mp3util.NodeLogger.Debug("Deserialized TCPChannelResponse: ", resp)
-	if resp.ResponseCode != OK {
-		return nil, errors.New(fmt.Sprintf("Received error code: %v", resp.ResponseCode))
-	}
-
 	return &resp, nil
 }
 
@@ -184,7 +267,6 @@ func TrySendTCPChannelResponseError(conn io.Writer, code TCPChannelResponseCode)
 }
 
 /*
-
 Blocking.
 */
 func (resp *TCPChannelResponse) Send(conn io.Writer) error {
@@ -196,7 +278,6 @@ func (resp *TCPChannelResponse) Send(conn io.Writer) error {
 }
 
 /*
-
 Blocking.
 */
 func (req *TCPChannelRequest) Send(conn io.Writer) error {
@@ -222,11 +303,11 @@ func GetGzipFileSize(source io.Reader) (int64, error) {
 		defer compressWrite.Close()
 		_, err := io.Copy(gzipConverter, source) // Writing to something that is ultimately an io pipe Write BLOCKS until a reader tries to read from it.
 		if err != nil {
-			mp3util.NodeLogger.Error("Couldn't read from source! Error: %v", err)
+			mp3util.NodeLogger.Errorf("Couldn't read from source! Error: %v", err)
 		}
 		err = gzipConverter.Close()
 		if err != nil {
-			mp3util.NodeLogger.Error("Couldn't close gzip converter! Error: %v", err)
+			mp3util.NodeLogger.Errorf("Couldn't close gzip converter! Error: %v", err)
 		}
 	}()
 
@@ -249,7 +330,7 @@ func SendFileAsGzip(source io.Reader, target io.Writer) error {
 	gzipConverter := gzip.NewWriter(target)
 	nbytes, err := io.Copy(gzipConverter, source)
 	if err != nil {
-		mp3util.NodeLogger.Error("Transferred %v bytes before encountering error! Error: ", err)
+		mp3util.NodeLogger.Errorf("Transferred %v bytes before encountering error! Error: %v", nbytes, err)
 		err := gzipConverter.Close()
 		if err != nil {
 			mp3util.NodeLogger.Error("Couldn't close gzip writer! Error: ", err)
@@ -264,6 +345,39 @@ func SendFileAsGzip(source io.Reader, target io.Writer) error {
 	return nil
 }
 
+/*
+The gzip of source, as something you read from rather than write to. The same source always gzips to the same bytes,
+which is what lets a resumed upload regenerate the stream and skip whatever the replica already has.
+
+Close it when you're done, even halfway through: Close waits until source isn't being read anymore, so it's safe to
+Seek source afterwards.
+*/
+func GzipStream(source io.Reader) io.ReadCloser {
+	compressRead, compressWrite := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gzipConverter := gzip.NewWriter(compressWrite)
+		_, err := io.Copy(gzipConverter, source)
+		if err == nil {
+			err = gzipConverter.Close()
+		}
+		compressWrite.CloseWithError(err) // nil means a clean EOF for the reader
+	}()
+	return &gzipStream{compressRead, done}
+}
+
+type gzipStream struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+func (g *gzipStream) Close() error {
+	err := g.PipeReader.Close() // Unblocks the writer, which then gives up
+	<-g.done
+	return err
+}
+
 /*
 source is a io.Reader with file bytes that have been encoded as gunzip. This file writes the un-gunzipped data
 to target.
@@ -297,3 +411,24 @@ func RecvFileFromGzip(source *io.LimitedReader, target io.Writer) (int64, error)
 
 	return nbytes, err
 }
+
+/*
+RecvFileFromGzip, but the unzipped bytes also have to hash (SHA256) to expectedContentHash. Otherwise this returns
+ErrContentHashMismatch, and whatever was written to target is garbage. An empty expectedContentHash skips the check.
+*/
+func RecvFileFromGzipVerified(source *io.LimitedReader, target io.Writer, expectedContentHash string) (int64, error) {
+	if expectedContentHash == "" {
+		mp3util.NodeLogger.Warn("No content hash to verify against, trusting the sender.")
+		return RecvFileFromGzip(source, target)
+	}
+	hashWriter := sha256.New()
+	nbytes, err := RecvFileFromGzip(source, io.MultiWriter(target, hashWriter))
+	if err != nil {
+		return nbytes, err
+	}
+	if actual := hex.EncodeToString(hashWriter.Sum(nil)); actual != expectedContentHash {
+		mp3util.NodeLogger.Errorf("Received content hashes to %v, expected %v!", actual, expectedContentHash)
+		return nbytes, ErrContentHashMismatch
+	}
+	return nbytes, nil
+}
@@ -0,0 +1,49 @@
+package fsys
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+const ELECTION_FILE = "election.json"
+
+/*
+What a node has to remember about master elections across a reboot: the newest term it's seen, and who it voted for in
+that term. Forgetting the vote would let it vote twice in one term, and then two masters could win it.
+*/
+type ElectionState struct {
+	Term     uint64
+	VotedFor string // Member ID, or "" if we haven't voted this term
+}
+
+/*
+The election state we last saved, or the zero state if there isn't one.
+*/
+func (s *LocalSDFSStorage) ElectionState() (ElectionState, error) {
+	var st ElectionState
+	j, err := os.ReadFile(filepath.Join(s.RootDir, ELECTION_FILE))
+	if os.IsNotExist(err) {
+		return st, nil
+	} else if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(j, &st)
+	return st, err
+}
+
+/*
+Saves st, synced to disk before it returns: we can't hand out a vote (or start an election) until it's saved.
+*/
+func (s *LocalSDFSStorage) SaveElectionState(st ElectionState) error {
+	j, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(s.RootDir, ELECTION_FILE)
+	err = writeFileSynced(p+".partial", j)
+	if err != nil {
+		return err
+	}
+	return os.Rename(p+".partial", p)
+}
@@ -12,30 +12,75 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
-	"strconv"
+	"sync"
 	"time"
 )
 
 type LocalSDFSStorage struct {
-	RootDir    string
-	tmpfileDir string
+	RootDir       string
+	tmpfileDir    string
+	metadataDir   string
+	quarantineDir string
+	journal       *Journal
+	chunks        *ChunkStore
+	uploadDir     string
+	uploads       map[string]*UploadSession // In-progress resumable uploads, by ID
+	uploadsMtx    sync.Mutex
+	hintDir       string
+	recovered     SDFSFileVersionSet // What RecoverSDFSStorage found intact on disk. Empty for a fresh storage.
+	attrsMtx      sync.Mutex         // Held across setattr's read, change and write of a version's metadata
 }
 
 type SDFSFileHandle struct {
 	SDFSFileName string
-	Handle       *os.File
-	Version      time.Time
-	FileSize     int64
+	Handle       io.ReadCloser // The version as one gzip stream, stitched together from its chunks.
+	Version      int64 // See version.go
+	FileSize     int64 // Compressed, i.e. how many bytes Handle will give you.
 }
 
 type SDFSFile struct {
 	SDFSFileName string
 	Version      int64
+	Size         int64            // Uncompressed, of Version
+	Metadata     *VersionMetadata `json:",omitempty"` // CLIENT_REQ_KVERSIONS
 }
 
 type SDFSFileVersions struct {
 }
 
+/*
+VersionMetadata is persisted next to every registered version (see metadataDir), so that after a restart we can tell a
+good version file from one that was half-written or has rotted on disk. ContentHash is the SHA256 of the *uncompressed*
+content (so it doesn't depend on how somebody happened to gzip it), FileSize is the compressed size.
+
+It's also what a version says about itself: the rest is for stat, ls and getversions.
+*/
+type VersionMetadata struct {
+	ContentHash string
+	FileSize    int64
+	Size        int64  `json:",omitempty"` // Uncompressed
+	Writer      string `json:",omitempty"` // Member ID of the node that uploaded it
+	VersionAttributes
+}
+
+/*
+What the writer says about a version at putfile (see attributes.go). Travels with the version wherever it's replicated.
+*/
+type VersionAttributes struct {
+	LocalFileName string            `json:",omitempty"` // What the file was called where it was uploaded from
+	MimeType      string            `json:",omitempty"`
+	Attrs         map[string]string `json:",omitempty"` // User-defined; setattr can change them later
+	Path          string            `json:",omitempty"` // Where in the namespace it was written, as the master had it. See retention.go.
+}
+
+/*
+Who put a version on this replica, and why. This only ends up in the journal.
+*/
+type VersionOrigin struct {
+	Writer string // Member ID of whoever originally wrote the version
+	Reason string
+}
+
 // One file, multiple versions. Implementing hashset of versions wibth a map[int64]bool. Why doesn't golang have a hashset? I'm in pain.
 type SDFSFileVersionSet map[string]map[int64]bool
 
@@ -47,12 +92,16 @@ const (
 	ROOTDIR        = "sdfs"
 	TMPFILE_DIR    = "tmpfileDir"
 	STOREDFILE_DIR = "storedfileDir"
+	METADATA_DIR   = "metadataDir"
+	QUARANTINE_DIR = "quarantineDir"
 	LOCALFILE_DIR  = "fetchedfiles" // he's an outlier
 )
 
 /*
 Initialize the LocalSDFSStorage. This will completely destroy the sdfs directory if it finds that it exists.
 Don't put your bitcoin in this folder! :)
+
+If you want to keep what's already on disk, use RecoverSDFSStorage instead.
 */
 func NewSDFSStorage() (*LocalSDFSStorage, error) {
 	// -pwd
@@ -60,8 +109,15 @@ func NewSDFSStorage() (*LocalSDFSStorage, error) {
 	//   \----sdfs (ROOTDIR)
 	// 		    \----tmpfileDir (TMPFILE_DIR)
 	// 	        \----storedfileDir (STOREDFILE_DIR)
+	// 	        \----metadataDir (METADATA_DIR)
+	// 	        \----quarantineDir (QUARANTINE_DIR)
+	// 	        \----journalDir (JOURNAL_DIR)
+	// 	        \----chunkDir (CHUNK_DIR)
+	// 	        \----uploadDir (UPLOAD_DIR)
+	// 	        \----hintDir (HINT_DIR)
+	// 	        \----masterLogDir (MASTER_LOG_DIR)
+	// 	        \----election.json (ELECTION_FILE)
 	rootDir := filepath.Join(".", ROOTDIR)
-	tmpfileDir := filepath.Join(rootDir, TMPFILE_DIR)
 	// First, see if the whole directory exists. If so, we nuke it.
 	err := os.Mkdir(rootDir, 0777)
 	if os.IsExist(err) {
@@ -72,23 +128,39 @@ func NewSDFSStorage() (*LocalSDFSStorage, error) {
 			return nil, err
 		}
 	}
-	// Create the directory storing the tempfiles.
+	return makeSDFSStorage(rootDir)
+}
+
+/*
+Creates (if they don't already exist) all the directories under rootDir and returns a storage rooted there.
+*/
+func makeSDFSStorage(rootDir string) (*LocalSDFSStorage, error) {
+	s := &LocalSDFSStorage{}
+	s.RootDir = rootDir
+	s.tmpfileDir = filepath.Join(rootDir, TMPFILE_DIR)
+	s.metadataDir = filepath.Join(rootDir, METADATA_DIR)
+	s.quarantineDir = filepath.Join(rootDir, QUARANTINE_DIR)
+	s.chunks = newChunkStore(filepath.Join(rootDir, CHUNK_DIR))
+	s.uploadDir = filepath.Join(rootDir, UPLOAD_DIR)
+	s.uploads = make(map[string]*UploadSession)
+	s.hintDir = filepath.Join(rootDir, HINT_DIR)
+	s.recovered = make(SDFSFileVersionSet)
 	// TODO: Refactor so that we don't actually make the directory here
-	err = os.MkdirAll(tmpfileDir, 0777)
-	if err != nil {
-		mp3util.NodeLogger.Errorf("Error creating directory %v: %v\n", tmpfileDir, err)
-		return nil, err
+	for _, dir := range []string{s.tmpfileDir, filepath.Join(rootDir, STOREDFILE_DIR), s.metadataDir, s.quarantineDir, s.chunks.dir, s.uploadDir, s.hintDir} {
+		err := os.MkdirAll(dir, 0777)
+		if err != nil {
+			mp3util.NodeLogger.Errorf("Error creating directory %v: %v\n", dir, err)
+			return nil, err
+		}
 	}
-	err = os.MkdirAll(filepath.Join(rootDir, STOREDFILE_DIR), 0777)
+	journal, err := OpenJournal(filepath.Join(rootDir, JOURNAL_DIR))
 	if err != nil {
-		mp3util.NodeLogger.Errorf("Error creating directory %v: %v\n", tmpfileDir, err)
+		mp3util.NodeLogger.Errorf("Couldn't open the journal! Error: %v\n", err)
 		return nil, err
 	}
-	var s LocalSDFSStorage
-	s.RootDir = rootDir
-	s.tmpfileDir = tmpfileDir
+	s.journal = journal
 
-	return &s, nil
+	return s, nil
 }
 
 // Assumes that the client is actually sending the gunzipped version of the file through the connection.
@@ -100,7 +172,7 @@ func (s *LocalSDFSStorage) DumpBytesToTmpfile(byteSource *io.LimitedReader) (str
 	blobFileWriter, err := os.OpenFile(blobTarget, os.O_CREATE|os.O_RDWR, os.ModePerm)
 	blobWriter := bufio.NewWriter(blobFileWriter)
 	if err != nil {
-		mp3util.NodeLogger.Errorf("Couldn't open %v for writing! Error: %v\n", err)
+		mp3util.NodeLogger.Errorf("Couldn't open %v for writing! Error: %v\n", blobTarget, err)
 		return "", err
 	}
 	hashWriter := sha256.New()
@@ -133,7 +205,7 @@ func (s *LocalSDFSStorage) DumpBytesToTmpfile(byteSource *io.LimitedReader) (str
 	mp3util.NodeLogger.Debugf("Caclulated ContentHash of %v is %v. Renaming the file to %v...\n", blobName, hashName, hashName)
 	err = os.Rename(blobTarget, filepath.Join(s.tmpfileDir, hashName))
 	if err != nil {
-		mp3util.NodeLogger.Errorf("Couldn't rename the file from %v to %v! Error: %v", blobTarget, hashName)
+		mp3util.NodeLogger.Errorf("Couldn't rename the file from %v to %v! Error: %v", blobTarget, hashName, err)
 	}
 	mp3util.NodeLogger.Debugf("Successfully written all data to tmpfile: %v\n", filepath.Join(s.tmpfileDir, hashName))
 	return hashName, nil
@@ -156,17 +228,69 @@ To this:
 -------sdfs/
 		|----storedfileDir/
 				|----amongus/
-						|----111156363265365 (this is a Unix nano)
+						|----111156363265365 (this is a Unix nano, and the file is a ChunkManifest)
+		|----chunkDir/
+				|...(whichever chunks of the file we didn't already have)
 		|-----tmpfileDir/
 				|...
 
-Inspired by Git.
+Inspired by Git. The tmpfile is unzipped and cut into content-defined chunks on the way in, so versions that mostly
+look alike (which is most versions of the same file) share most of their chunks on disk.
+
+The registration is journaled (with origin) before anything on disk moves.
+*/
+func (s *LocalSDFSStorage) RegisterTmpfileToSDFS(contentHash string, version int64, sdfsFileName string, origin VersionOrigin, attrs VersionAttributes) error {
+	return s.registerTmpfile(contentHash, "", version, sdfsFileName, origin, attrs)
+}
+
+/*
+Like RegisterTmpfileToSDFS, but for putting back a version we already had (and lost to bit rot): the tmpfile only gets
+registered if its content hashes to expectedContentHash, i.e. it's really the same version. Whatever is currently
+registered under that version is replaced.
 */
-func (s *LocalSDFSStorage) RegisterTmpfileToSDFS(contentHash string, version time.Time, sdfsFileName string) error {
-	if _, err := os.Stat(filepath.Join(s.tmpfileDir, contentHash)); os.IsNotExist(err) {
+func (s *LocalSDFSStorage) RestoreTmpfileToSDFS(contentHash string, expectedContentHash string, version int64, sdfsFileName string, origin VersionOrigin, attrs VersionAttributes) error {
+	return s.registerTmpfile(contentHash, expectedContentHash, version, sdfsFileName, origin, attrs)
+}
+
+func (s *LocalSDFSStorage) registerTmpfile(contentHash string, expectedContentHash string, version int64, sdfsFileName string, origin VersionOrigin, attrs VersionAttributes) error {
+	tmpFilePath := filepath.Join(s.tmpfileDir, contentHash)
+	if _, err := os.Stat(tmpFilePath); os.IsNotExist(err) {
 		mp3util.NodeLogger.Errorf("Tmpfile with contentHash: %v not found.\n", contentHash)
 		return errors.New("TmpfileNotPresent")
 	}
+	manifest, uncompressedHash, err := s.chunkGzipFile(tmpFilePath)
+	if err != nil {
+		return err
+	}
+	if expectedContentHash != "" && uncompressedHash != expectedContentHash {
+		mp3util.NodeLogger.Errorf("Tmpfile %v hashes to %v, expected %v!", contentHash, uncompressedHash, expectedContentHash)
+		s.releaseManifest(manifest)
+		os.Remove(tmpFilePath)
+		return ErrContentHashMismatch
+	}
+	err = s.registerManifest(manifest, uncompressedHash, version, sdfsFileName, origin, attrs)
+	if err != nil {
+		s.releaseManifest(manifest)
+		return err
+	}
+	err = os.Remove(tmpFilePath)
+	if err != nil {
+		mp3util.NodeLogger.Warnf("Couldn't remove tmpfile %v after registering it! Error: %v", tmpFilePath, err)
+	}
+	mp3util.NodeLogger.Debugf("Successfully registered file with contentHash=%v as %v (%v chunks) in SDFS.", contentHash,
+		filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFileName, VersionName(version)), len(manifest.Chunks))
+	return nil
+}
+
+/*
+Registers a version whose chunks are all already in the chunk store (and referenced on its behalf). This is the second
+half of RegisterTmpfileToSDFS, and all of receiving a replicated version.
+*/
+func (s *LocalSDFSStorage) registerManifest(manifest ChunkManifest, contentHash string, version int64, sdfsFileName string, origin VersionOrigin, attrs VersionAttributes) error {
+	if err := CheckSDFSFileName(sdfsFileName); err != nil {
+		mp3util.NodeLogger.Error(err)
+		return err
+	}
 	// This is the directory that will contain *all* the versions for this particular file (sdfsFileName)
 	fileHome := filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFileName)
 	err := os.MkdirAll(fileHome, 0777)
@@ -178,10 +302,9 @@ func (s *LocalSDFSStorage) RegisterTmpfileToSDFS(contentHash string, version tim
 			return err
 		}
 	}
-	tmpFilePath := filepath.Join(s.tmpfileDir, contentHash)
-	newFilePath := filepath.Join(fileHome, fmt.Sprintf("%v", version.UnixNano()))
+	newFilePath := filepath.Join(fileHome, VersionName(version))
 	// Handle this really weird edge case
-	if _, err = os.Stat(newFilePath); !os.IsNotExist(err) && err != nil {
+	if old, err := readManifest(newFilePath); err == nil {
 		mp3util.NodeLogger.Warnf("There already exists filename with this version %v. "+
 			"Please verify no race condition has occurred. We will proceed and overwrite.", newFilePath)
 		err = os.Remove(newFilePath)
@@ -189,13 +312,77 @@ func (s *LocalSDFSStorage) RegisterTmpfileToSDFS(contentHash string, version tim
 			mp3util.NodeLogger.Error("Couldn't remove the file! Error: ", err)
 			return err
 		}
+		s.releaseManifest(old)
+	}
+	err = s.journal.Append(JournalEntry{
+		Op:           JOURNAL_REGISTER,
+		SDFSFileName: sdfsFileName,
+		Version:      version,
+		ContentHash:  contentHash,
+		FileSize:     manifest.CompressedSize,
+		Writer:       origin.Writer,
+		Reason:       origin.Reason,
+	})
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't journal registration of %v! Error: %v\n", newFilePath, err)
+		return err
+	}
+	// Metadata goes down first. If we crash between these two steps, recovery finds metadata with no version file and
+	// throws it away, which is much better than finding a version file we can't verify.
+	err = s.writeVersionMetadata(sdfsFileName, version, VersionMetadata{
+		ContentHash:       contentHash,
+		FileSize:          manifest.CompressedSize,
+		Size:              manifest.Size,
+		Writer:            origin.Writer,
+		VersionAttributes: attrs,
+	})
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't write metadata for %v! Error: %v\n", newFilePath, err)
+		return err
+	}
+	err = writeManifest(newFilePath, manifest)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't write manifest %v! Error: %v\n", newFilePath, err)
+		return err
+	}
+	return nil
+}
+
+/*
+Registers a version we got from another replica. All of the manifest's chunks have to have come in through res.
+*/
+func (s *LocalSDFSStorage) RegisterReplicatedVersion(res *ChunkReservation, contentHash string, version int64, sdfsFileName string, origin VersionOrigin, attrs VersionAttributes) error {
+	if !res.Complete() {
+		return errors.New(fmt.Sprintf("not all chunks of %v@%v arrived", sdfsFileName, version))
+	}
+	// Sizes on disk are what readers will be told, so they have to be ours, not the sender's.
+	manifest := res.manifest
+	manifest.Chunks = make([]ChunkRef, len(res.manifest.Chunks))
+	manifest.CompressedSize = 0
+	for i, ref := range res.manifest.Chunks {
+		fi, err := os.Stat(s.chunks.chunkPath(ref.Hash))
+		if err != nil {
+			return err
+		}
+		ref.CompressedSize = fi.Size()
+		manifest.Chunks[i] = ref
+		manifest.CompressedSize += ref.CompressedSize
+	}
+	// Every chunk that came over the wire was checked on arrival, but the ones we already had weren't, and neither was
+	// the order. So check that the whole thing is what the sender says it is before it can spread any further.
+	actual, err := s.hashManifestContent(manifest)
+	if err != nil {
+		return err
+	}
+	if actual != contentHash {
+		mp3util.NodeLogger.Errorf("Replicated %v@%v hashes to %v, but the sender said %v!", sdfsFileName, version, actual, contentHash)
+		return ErrContentHashMismatch
 	}
-	err = os.Rename(tmpFilePath, newFilePath) // This is golang being stupid. This is a mv.
+	err = s.registerManifest(manifest, contentHash, version, sdfsFileName, origin, attrs)
 	if err != nil {
-		mp3util.NodeLogger.Errorf("Couldn't move %v to %v! Error: %v\n", tmpFilePath, newFilePath, err)
 		return err
 	}
-	mp3util.NodeLogger.Debugf("Successfully registered file with contentHash=%v as %v in SDFS.", contentHash, newFilePath)
+	res.held = nil // The version owns the references now.
 	return nil
 }
 
@@ -203,12 +390,16 @@ func (s *LocalSDFSStorage) RegisterTmpfileToSDFS(contentHash string, version tim
 Return a slice of OPEN *os.File handles representing the `kLatest` latest versions of the file `sdfsFileName` in question.
 The onus is on the caller to close the file handles.
 */
-func (s *LocalSDFSStorage) AcquireFileHandles(kLatest int, sdfsFileName string, upperVersionBound time.Time) ([]SDFSFileHandle, error) {
+func (s *LocalSDFSStorage) AcquireFileHandles(kLatest int, sdfsFileName string, upperVersionBound int64) ([]SDFSFileHandle, error) {
 	mp3util.NodeLogger.Debugf("Acquiring file handles for: %v", sdfsFileName)
 	if kLatest <= 0 {
 		mp3util.NodeLogger.Errorf("Cannot fetch %v latest versions, out of range.", kLatest)
 		return []SDFSFileHandle{}, os.ErrInvalid
 	}
+	if err := CheckSDFSFileName(sdfsFileName); err != nil {
+		mp3util.NodeLogger.Error(err)
+		return nil, err
+	}
 	directoryWithFiles := filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFileName)
 	mp3util.NodeLogger.Debugf("The filepath we will traverse is: %v", directoryWithFiles)
 	_, err := os.Stat(directoryWithFiles)
@@ -222,50 +413,44 @@ func (s *LocalSDFSStorage) AcquireFileHandles(kLatest int, sdfsFileName string,
 			mp3util.NodeLogger.Debugf("%v is a directory. Skipping...", p)
 			return nil
 		}
-		mp3util.NodeLogger.Debugf("Attempting to open %v...", filepath.Join(directoryWithFiles, d.Name()))
-		fd, err := os.Open(filepath.Join(directoryWithFiles, d.Name()))
-		if err != nil {
-			mp3util.NodeLogger.Errorf("Could not open file %v! Error: %v", p, err)
-			return err
+		if isPartial(d.Name()) {
+			return nil // Manifest that's still being written.
 		}
-		t, err := strconv.ParseInt(d.Name(), 10, 64)
+		version, err := ParseVersion(d.Name()) // The filename *is* the version so we parse it.
 		if err != nil {
-			mp3util.NodeLogger.Errorf("Coudln't parse filename!")
+			mp3util.NodeLogger.Errorf("Filename %v is not a version!", d.Name())
 			return err
 		}
-		fileTime := time.Unix(0, t)
-
-		if fileTime.After(upperVersionBound) {
+		if version > upperVersionBound {
 			return nil
 		}
-		h := SDFSFileHandle{
-			SDFSFileName: sdfsFileName,
-			Handle:       fd,
-			Version:      time.Unix(0, t),
-		}
-		fi, err := os.Stat(filepath.Join(directoryWithFiles, d.Name()))
-		if err != nil {
-			mp3util.NodeLogger.Errorf("Couldn't stat file %v! Error: %v", fi, err)
-			return err
-		}
-		h.FileSize = fi.Size()
-		versionAsNumber, err := strconv.ParseInt(d.Name(), 10, 64) // The filename *is* the version so we parse it.
+		mp3util.NodeLogger.Debugf("Attempting to open %v...", p)
+		manifest, err := readManifest(p)
 		if err != nil {
-			mp3util.NodeLogger.Errorf("Filename %v could not be converted to int64!", d.Name())
+			mp3util.NodeLogger.Errorf("Could not read manifest %v! Error: %v", p, err)
 			return err
 		}
-		h.Version = time.Unix(0, versionAsNumber) // Parse unix timestamp.
-		handles = append(handles, h)
+		handles = append(handles, SDFSFileHandle{
+			SDFSFileName: sdfsFileName,
+			Handle:       s.newManifestReader(manifest), // Holds the chunks, but doesn't open any until somebody reads it
+			Version:      version,
+			FileSize:     manifest.CompressedSize,
+		})
 		return nil
 	})
+	if err != nil {
+		CloseHandles(handles)
+		return nil, err
+	}
 	sort.Slice(handles, func(i, j int) bool {
-		return handles[i].Version.After(handles[j].Version)
+		return handles[i].Version > handles[j].Version
 	})
 	numToReturn := kLatest
 	if len(handles) < kLatest {
 		numToReturn = len(handles)
 	}
 	mp3util.NodeLogger.Debugf("Versions to be returned are: %v", handles[:numToReturn])
+	CloseHandles(handles[numToReturn:])
 	return handles[:numToReturn], nil
 }
 
@@ -279,23 +464,43 @@ func (s *LocalSDFSStorage) ListDirectory() ([]SDFSFile, error) {
 	}
 	localSDFSFiles, err := os.ReadDir(sdfsDir)
 	if err != nil {
-		mp3util.NodeLogger.Debugf("Couldn't open the SDFS directory. Error: ", err)
+		mp3util.NodeLogger.Debugf("Couldn't open the SDFS directory. Error: %v", err)
 		return nil, err
 	}
 	for _, f := range localSDFSFiles {
-		handles, err := s.AcquireFileHandles(1, f.Name(), time.Now())
-		defer CloseHandles(handles)
+		sdfsfile, ok, err := s.latestStoredFile(f.Name())
 		if err != nil {
 			mp3util.NodeLogger.Errorf("Couldn't get the latest version of file: %v!", f.Name())
 			return nil, err
 		}
-		sdfsfile := SDFSFile{handles[0].SDFSFileName, handles[0].Version.UnixNano()}
+		if !ok {
+			continue // Its first version is still being registered
+		}
 		storedSDFSFiles = append(storedSDFSFiles, sdfsfile)
 	}
 
 	return storedSDFSFiles, nil
 }
 
+/*
+The latest version of sdfsFileName we have, if there's one that's done registering.
+*/
+func (s *LocalSDFSStorage) latestStoredFile(sdfsFileName string) (SDFSFile, bool, error) {
+	handles, err := s.AcquireFileHandles(1, sdfsFileName, LATEST_VERSION)
+	if err != nil {
+		return SDFSFile{}, false, err
+	}
+	defer CloseHandles(handles)
+	if len(handles) == 0 {
+		return SDFSFile{}, false, nil
+	}
+	sdfsfile := SDFSFile{SDFSFileName: handles[0].SDFSFileName, Version: handles[0].Version}
+	if manifest, err := s.ReadManifest(sdfsfile.SDFSFileName, sdfsfile.Version); err == nil {
+		sdfsfile.Size = manifest.Size
+	}
+	return sdfsfile, true, nil
+}
+
 /*
 Suppose we have a sequence of commands from master like so:
 	Put Amogus(t=0); PutAmogus(t=1); PutAmogus(t=2); RemoveAmogus(t=3); PutAmogus(t=4)
@@ -305,85 +510,184 @@ Then, we should NOT delete all versions and instead only delete the versions tha
 This returns a boolean. If the boolean is true, this means that the directory EXISTS after deletion. (Which means a write happened soon after, which means we have a partial delete).
 
 I think we need a full replica delete, NOT just a quorum delete. Otherwise there are weird edge cases.
+
+The deletion is journaled as a tombstone at timeOfDeletion (the version the master gave the delete) before any version
+is touched.
+*/
+func (s *LocalSDFSStorage) RemoveSDFSFile(sdfsFile string, timeOfDeletion int64) (bool, error) {
+	if err := CheckSDFSFileName(sdfsFile); err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFile)); os.IsNotExist(err) {
+		mp3util.NodeLogger.Warnf("SDFSFile %v not found on this replica.", sdfsFile)
+		return false, err
+	}
+	err := s.journal.Append(JournalEntry{
+		Op:           JOURNAL_REMOVE,
+		SDFSFileName: sdfsFile,
+		Version:      timeOfDeletion,
+		Reason:       "deleted by master",
+	})
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't journal removal of %v! Error: %v", sdfsFile, err)
+		return false, err
+	}
+	return s.removeVersionsUpTo(sdfsFile, timeOfDeletion)
+}
+
+/*
+Drops every version of a file we are no longer responsible for. Unlike RemoveSDFSFile this leaves no tombstone behind:
+the file wasn't deleted, it just lives somewhere else now.
+*/
+func (s *LocalSDFSStorage) GarbageCollectSDFSFile(sdfsFile string) (bool, error) {
+	if err := CheckSDFSFileName(sdfsFile); err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFile)); os.IsNotExist(err) {
+		mp3util.NodeLogger.Warnf("SDFSFile %v not found on this replica.", sdfsFile)
+		return false, err
+	}
+	err := s.journal.Append(JournalEntry{
+		Op:           JOURNAL_GC,
+		SDFSFileName: sdfsFile,
+		Version:      LATEST_VERSION,
+		Reason:       "no longer a replica for this file",
+	})
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't journal garbage collection of %v! Error: %v", sdfsFile, err)
+		return false, err
+	}
+	return s.removeVersionsUpTo(sdfsFile, LATEST_VERSION)
+}
+
+/*
+What the journal knows about the versions of sdfsFile we hold, newest first, plus its tombstone (0 if none).
+*/
+func (s *LocalSDFSStorage) History(sdfsFile string) ([]VersionRecord, int64) {
+	records, tombstone := s.journal.FileRecords(sdfsFile)
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Version > records[j].Version
+	})
+	return records, tombstone
+}
+
+/*
+What the journal knows about a single version.
 */
-func (s *LocalSDFSStorage) RemoveSDFSFile(sdfsFile string, timeOfDeletion time.Time) (bool, error) {
+func (s *LocalSDFSStorage) VersionRecord(sdfsFile string, version int64) (VersionRecord, bool) {
+	return s.journal.Record(sdfsFile, version)
+}
+
+func (s *LocalSDFSStorage) removeVersionsUpTo(sdfsFile string, timeOfDeletion int64) (bool, error) {
 	// Check the maximum timestamp of the files that's in here.
-	maxTimestamp := time.Unix(0, 0)
+	maxTimestamp := int64(0)
 	if _, err := os.Stat(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFile)); os.IsNotExist(err) {
 		mp3util.NodeLogger.Warnf("SDFSFile %v not found on this replica.", sdfsFile)
 		return false, err
 	}
 	err := filepath.WalkDir(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFile), func(p string, d fs.DirEntry, err error) error {
 		// First entry is always the directory!!!!!1111111111!!!
-		if d.Type() == os.ModeDir {
+		if d.Type() == os.ModeDir || isPartial(d.Name()) {
 			//mp3util.NodeLogger.Warnf("Not a file: %v", d.Name())
 			return nil
 		}
-		tStamp, err := strconv.ParseInt(d.Name(), 10, 64)
+		tStamp, err := ParseVersion(d.Name())
 		if err != nil {
-			mp3util.NodeLogger.Debugf("Couldn't parse filename into a number! Offending file: %v", tStamp)
+			mp3util.NodeLogger.Debugf("Couldn't parse filename into a version! Offending file: %v", d.Name())
 			return err
 		}
-		tStampTime := time.Unix(0, tStamp)
-		if err != nil {
-			mp3util.NodeLogger.Debugf("Couldn't parse filename %v to int64! Error: ", p, err)
-			return err
-		}
-		if tStampTime.After(maxTimestamp) {
-			maxTimestamp = tStampTime
+		if tStamp > maxTimestamp {
+			maxTimestamp = tStamp
 		}
 		return nil
 	})
 	if err != nil {
-		mp3util.NodeLogger.Debugf("Encountered error when walking directory! Error: ", err)
+		mp3util.NodeLogger.Debugf("Encountered error when walking directory! Error: %v", err)
 		return false, err
 	}
 
-	mp3util.NodeLogger.Debug("The maximum timestamp is: ", maxTimestamp.UnixNano())
-	if timeOfDeletion.After(maxTimestamp) {
-		// Remove the entire directory
+	mp3util.NodeLogger.Debug("The maximum timestamp is: ", maxTimestamp)
+	if timeOfDeletion >= maxTimestamp {
+		// Remove the entire directory, letting go of the chunks first.
+		s.releaseAllManifests(sdfsFile)
 		err = os.RemoveAll(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFile))
 		if err != nil {
 			mp3util.NodeLogger.Debugf("Couldn't remove the path: %v! Error: %v", filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFile), err)
 			return false, err
 		}
+		s.removeFileMetadata(sdfsFile)
 		return false, nil
 	} else {
 		preservedDirectory := false
 		// Only remove the versions that are older than the removal timestamp.
 		err = filepath.WalkDir(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFile), func(p string, d fs.DirEntry, err error) error {
-			tStamp, err := strconv.ParseInt(d.Name(), 10, 64)
-			tStampTime := time.Unix(0, tStamp)
+			// Same deal as above, the first entry is the directory itself. Trying to parse it used to abort the walk
+			// before anything was removed, and then we'd nuke the whole directory, newer versions and all.
+			if d.Type() == os.ModeDir || isPartial(d.Name()) {
+				return nil
+			}
+			tStamp, err := ParseVersion(d.Name())
 			if err != nil {
-				mp3util.NodeLogger.Debugf("Couldn't parse filename %v to int64! Error: ", p, err)
+				mp3util.NodeLogger.Debugf("Couldn't parse filename %v to a version! Error: %v", p, err)
 				return err
 			}
-			if tStampTime.After(timeOfDeletion) {
+			if tStamp > timeOfDeletion {
 				preservedDirectory = true
 			} else {
+				manifest, manifestErr := readManifest(p)
 				err = os.Remove(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFile, d.Name()))
 				if err != nil {
 					mp3util.NodeLogger.Debugf("Couldn't remove stale version of file, %v! Error: %v", p, err)
 					return err
 				}
+				s.removeVersionMetadata(sdfsFile, tStamp)
+				if manifestErr == nil {
+					s.releaseManifest(manifest)
+				}
 			}
 			return nil
 		})
 
+		if err != nil {
+			mp3util.NodeLogger.Debugf("Encountered error when removing stale versions! Error: %v", err)
+			return preservedDirectory, err
+		}
 		if !preservedDirectory {
 			err = os.RemoveAll(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFile))
-			return true, nil
-		} else {
+			s.removeFileMetadata(sdfsFile)
 			return false, nil
+		} else {
+			return true, nil
 		}
 
 	}
 }
 
+/*
+Drops the chunk references of every version of sdfsFile, right before the whole directory goes.
+*/
+func (s *LocalSDFSStorage) releaseAllManifests(sdfsFile string) {
+	entries, err := os.ReadDir(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFile))
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || isPartial(e.Name()) {
+			continue
+		}
+		manifest, err := readManifest(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFile, e.Name()))
+		if err != nil {
+			mp3util.NodeLogger.Warnf("Couldn't read manifest %v/%v while removing it! Error: %v", sdfsFile, e.Name(), err)
+			continue
+		}
+		s.releaseManifest(manifest)
+	}
+}
+
 func (s *LocalSDFSStorage) ListStoredSDFSFilesAllVersions() (SDFSFileVersionSet, error) {
 	files, err := s.ListDirectory()
 	if err != nil {
-		mp3util.NodeLogger.Errorf("Could not list directory! Error: ", err)
+		mp3util.NodeLogger.Errorf("Could not list directory! Error: %v", err)
 		return nil, err
 	}
 
@@ -392,16 +696,16 @@ func (s *LocalSDFSStorage) ListStoredSDFSFilesAllVersions() (SDFSFileVersionSet,
 		fiVersionSet := make(map[int64]bool)
 		err = filepath.WalkDir(filepath.Join(s.RootDir, STOREDFILE_DIR, fi.SDFSFileName), func(p string, d fs.DirEntry, err error) error {
 			mp3util.NodeLogger.Debugf("%v ; %v ; %v", p, d, err)
-			if d.Type() == os.ModeDir {
+			if d.Type() == os.ModeDir || isPartial(d.Name()) {
 				mp3util.NodeLogger.Debugf("Not a file: %v", d.Name())
 				return nil
 			}
-			timeAsUnix, err := strconv.ParseInt(d.Name(), 10, 64)
+			version, err := ParseVersion(d.Name())
 			if err != nil {
-				mp3util.NodeLogger.Errorf("Couldn't parse %v relating to file %v as a unix timestamp!", d.Name(), fi.SDFSFileName)
+				mp3util.NodeLogger.Errorf("Couldn't parse %v relating to file %v as a version!", d.Name(), fi.SDFSFileName)
 				return err
 			}
-			fiVersionSet[timeAsUnix] = true
+			fiVersionSet[version] = true
 			return nil
 		})
 
@@ -457,80 +761,6 @@ func DiffForDeletes(fromSet SDFSFileVersionSet, toSet SDFSFileVersionSet) SDFSFi
 	return deletions
 }
 
-/*
-Returns a set which, when merged with alreadyHaveSet, will contain up to K latest versions for each file. The second
-argument would be the versions that, would be discarded by alreadyHaveSet.
-
-For example, for a specific file X, if alreadyHaveSet[x] = {7, 5, 3, 2, 1}, and offeredSet[x] = {9, 5, 4, 3, 2}, k=5, then
-the returned value will be ({9, 4},) because the K=5 latest versions would be
-	{ 9 (theirs), 7 (ours), 5 (both have it, so skip), 4 (theirs), 3 (ours) }.
-Anything that's "theirs" is what we have to fetch.
-
-This is leetcode now.
-
-This function exists so that we don't unknowingly fetch really stale versions and waste bandwidth.
-But, then again, we ARE talking over JSON almost always...
-But then again, when file sizes are ~1.25GB like the wikipedia corpus, JSON is but a drop in the ocean.
-
-*/
-func MergedKLatestVersions(alreadyHaveSet map[int64]bool, offeredSet map[int64]bool, k int) (fetch map[int64]bool, discard map[int64]bool) {
-	type VersionFromWho struct {
-		us_or_them_or_both string
-		version            int64
-	}
-
-	var allMerged []VersionFromWho
-	for ver := range alreadyHaveSet {
-		_, existsInOffered := offeredSet[ver]
-		if existsInOffered {
-			continue
-			// Commenting this out, because we don't *actually* care.
-			//allMerged = append(allMerged, VersionFromWho{
-			//	us_or_them_or_both: "both",
-			//	version:            ver,
-			//})
-		} else {
-			allMerged = append(allMerged, VersionFromWho{
-				us_or_them_or_both: "us",
-				version:            ver,
-			})
-		}
-	}
-
-	for ver := range offeredSet {
-		_, existsInAlreadyHave := alreadyHaveSet[ver]
-		if existsInAlreadyHave {
-			continue // Already considered "both" in the prior loop.
-		} else {
-			allMerged = append(allMerged, VersionFromWho{
-				us_or_them_or_both: "them",
-				version:            ver,
-			})
-		}
-	}
-	sort.Slice(allMerged, func(i, j int) bool {
-		timeI := time.Unix(0, allMerged[i].version)
-		timeJ := time.Unix(0, allMerged[j].version)
-		return timeI.After(timeJ)
-	})
-	mp3util.NodeLogger.Debugf("After sorting, allMerged contains: %v", allMerged)
-
-	fetch = make(map[int64]bool)
-	discard = make(map[int64]bool)
-	for i := 0; i < len(allMerged); i++ {
-		if i < k { // We're still
-			if allMerged[i].us_or_them_or_both == "them" {
-				fetch[allMerged[i].version] = true
-			}
-		} else { // Anything in "us" should be added to `discard`.
-			if allMerged[i].us_or_them_or_both == "us" {
-				discard[allMerged[i].version] = true
-			}
-		}
-	}
-	return fetch, discard
-}
-
 /*
 Deferrable statement to close all file handle
 */
@@ -0,0 +1,108 @@
+package fsys
+
+import (
+	"amogus/mp3util"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const HINT_DIR = "hintDir"
+
+/*
+Hinted handoff: a version we hold on behalf of a replica that couldn't be reached when it was written. We keep the hint
+(next to nothing, the version itself is just one of ours) until the owner is back and we've pushed the version to it.
+Hints live in hintDir/<ID>.json, so they outlast a reboot of ours too.
+*/
+type Hint struct {
+	ID           string
+	SDFSFileName string
+	Version      int64
+	Owner        ReplicaAddr
+	Created      time.Time
+}
+
+func (s *LocalSDFSStorage) hintPath(id string) string {
+	return filepath.Join(s.hintDir, id+".json")
+}
+
+/*
+Remembers to deliver sdfsFileName @ version to owner. A hint for the same version and owner replaces the old one.
+*/
+func (s *LocalSDFSStorage) StoreHint(sdfsFileName string, version int64, owner ReplicaAddr) error {
+	for _, h := range s.Hints() {
+		if h.SDFSFileName == sdfsFileName && h.Version == version && h.Owner == owner {
+			s.DropHint(h.ID)
+		}
+	}
+	idBytes := make([]byte, 8)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return err
+	}
+	h := Hint{
+		ID:           hex.EncodeToString(idBytes),
+		SDFSFileName: sdfsFileName,
+		Version:      version,
+		Owner:        owner,
+		Created:      time.Now(),
+	}
+	j, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	scratch := s.hintPath(h.ID) + ".partial"
+	err = writeFileSynced(scratch, j)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't write hint to %v! Error: %v", scratch, err)
+		return err
+	}
+	mp3util.NodeLogger.Infof("Holding %v @ %v for replica %v (%v) until it's back", sdfsFileName, version, owner.MemberId, owner.Address)
+	return os.Rename(scratch, s.hintPath(h.ID))
+}
+
+/*
+Every hint we're holding, oldest first. Unreadable ones (and leftovers from a crash mid-write) are thrown away.
+*/
+func (s *LocalSDFSStorage) Hints() []Hint {
+	entries, err := os.ReadDir(s.hintDir)
+	if err != nil {
+		mp3util.NodeLogger.Warnf("Couldn't read %v! Error: %v", s.hintDir, err)
+		return nil
+	}
+	var hints []Hint
+	for _, e := range entries {
+		p := filepath.Join(s.hintDir, e.Name())
+		if !strings.HasSuffix(e.Name(), ".json") {
+			os.Remove(p)
+			continue
+		}
+		var h Hint
+		j, err := os.ReadFile(p)
+		if err == nil {
+			err = json.Unmarshal(j, &h)
+		}
+		if err != nil {
+			mp3util.NodeLogger.Warnf("Dropping unreadable hint %v: %v", e.Name(), err)
+			os.Remove(p)
+			continue
+		}
+		hints = append(hints, h)
+	}
+	sort.Slice(hints, func(i, j int) bool {
+		return hints[i].Created.Before(hints[j].Created)
+	})
+	return hints
+}
+
+func (s *LocalSDFSStorage) DropHint(id string) {
+	err := os.Remove(s.hintPath(id))
+	if err != nil && !os.IsNotExist(err) {
+		mp3util.NodeLogger.Warnf("Couldn't remove hint %v! Error: %v", id, err)
+	}
+}
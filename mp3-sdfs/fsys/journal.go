@@ -0,0 +1,373 @@
+package fsys
+
+import (
+	"amogus/config"
+	"amogus/mp3util"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type JournalOp string
+
+const (
+	JOURNAL_REGISTER   JournalOp = "REGISTER"        // A version was registered (RegisterTmpfileToSDFS)
+	JOURNAL_REMOVE     JournalOp = "REMOVE"          // A delete from master. Leaves a tombstone at Version.
+	JOURNAL_GC         JournalOp = "GARBAGE_COLLECT" // We stopped owning the file and dropped its versions up to Version
+	JOURNAL_DISCARD    JournalOp = "DISCARD"         // A single version went away for some other reason (see Reason)
+	JOURNAL_QUARANTINE JournalOp = "QUARANTINE"      // A single version failed verification and was moved aside
+)
+
+const (
+	JOURNAL_DIR      = "journalDir"
+	JOURNAL_LOG      = "journal.log"
+	JOURNAL_SNAPSHOT = "snapshot.json"
+)
+
+/*
+One line of the journal. Only the fields that make sense for Op are filled in.
+*/
+type JournalEntry struct {
+	Seq          int64
+	Time         int64 // Unix nano, when the entry was appended
+	Op           JournalOp
+	SDFSFileName string
+	Version      int64
+	ContentHash  string
+	FileSize     int64
+	Writer       string
+	Reason       string
+}
+
+/*
+Everything the journal knows about one version that is (supposedly) on disk. This is the answer to "why is this version
+here?".
+*/
+type VersionRecord struct {
+	SDFSFileName string
+	Version      int64
+	ContentHash  string
+	FileSize     int64
+	Writer       string
+	Reason       string
+	RecordedAt   int64
+	Seq          int64
+}
+
+/*
+The result of replaying the journal. Snapshots are just this struct serialized.
+*/
+type JournalState struct {
+	Seq        int64
+	Files      map[string]map[int64]VersionRecord
+	Tombstones map[string]int64 // Versions of a file at or below its tombstone were deleted by master.
+}
+
+/*
+Journal is an append-only, fsynced log of everything LocalSDFSStorage does to storedfileDir, plus a snapshot of the
+replayed state every config.JOURNAL_SNAPSHOT_INTERVAL entries so the log doesn't grow forever.
+
+	-------sdfs/
+			|----journalDir/
+					|----snapshot.json (JournalState as of snapshot.Seq)
+					|----journal.log (one JournalEntry per line, all with Seq > snapshot.Seq)
+*/
+type Journal struct {
+	dir           string
+	fd            *os.File
+	state         JournalState
+	sinceSnapshot int
+	mtx           sync.Mutex
+}
+
+func newJournalState() JournalState {
+	return JournalState{
+		Files:      make(map[string]map[int64]VersionRecord),
+		Tombstones: make(map[string]int64),
+	}
+}
+
+/*
+Loads the snapshot (if any), replays the log on top of it, and opens the log for appending.
+*/
+func OpenJournal(dir string) (*Journal, error) {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't create journal directory %v! Error: %v", dir, err)
+		return nil, err
+	}
+	j := &Journal{dir: dir, state: newJournalState()}
+
+	snapshotBytes, err := os.ReadFile(filepath.Join(dir, JOURNAL_SNAPSHOT))
+	if err == nil {
+		err = json.Unmarshal(snapshotBytes, &j.state)
+		if err != nil {
+			mp3util.NodeLogger.Errorf("Journal snapshot is corrupted! Error: %v", err)
+			return nil, err
+		}
+		if j.state.Files == nil || j.state.Tombstones == nil {
+			fresh := newJournalState()
+			fresh.Seq = j.state.Seq
+			for k, v := range j.state.Files {
+				fresh.Files[k] = v
+			}
+			for k, v := range j.state.Tombstones {
+				fresh.Tombstones[k] = v
+			}
+			j.state = fresh
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	logPath := filepath.Join(dir, JOURNAL_LOG)
+	numReplayed, err := j.replay(logPath)
+	if err != nil {
+		return nil, err
+	}
+	j.fd, err = os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't open journal %v for appending! Error: %v", logPath, err)
+		return nil, err
+	}
+	j.sinceSnapshot = numReplayed
+	mp3util.NodeLogger.Debugf("Opened journal at %v: snapshot + %v replayed entries, now at seq %v", dir, numReplayed, j.state.Seq)
+	return j, nil
+}
+
+/*
+Applies every entry in the log newer than the snapshot. A torn last line (we crashed mid-append) is dropped, and the log
+is truncated right before it so the next append doesn't glue onto garbage.
+*/
+func (j *Journal) replay(logPath string) (int, error) {
+	fd, err := os.OpenFile(logPath, os.O_RDWR, 0666)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	numReplayed := 0
+	var goodBytes int64
+	reader := bufio.NewReader(fd)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			break
+		}
+		var e JournalEntry
+		if err != nil || json.Unmarshal(line, &e) != nil {
+			mp3util.NodeLogger.Warnf("Dropping torn journal entry at byte %v", goodBytes)
+			break
+		}
+		goodBytes += int64(len(line))
+		if e.Seq <= j.state.Seq {
+			continue // Already folded into the snapshot.
+		}
+		j.state.apply(e)
+		numReplayed++
+	}
+	return numReplayed, fd.Truncate(goodBytes)
+}
+
+func (st *JournalState) apply(e JournalEntry) {
+	st.Seq = e.Seq
+	switch e.Op {
+	case JOURNAL_REGISTER:
+		if _, ok := st.Files[e.SDFSFileName]; !ok {
+			st.Files[e.SDFSFileName] = make(map[int64]VersionRecord)
+		}
+		st.Files[e.SDFSFileName][e.Version] = VersionRecord{
+			SDFSFileName: e.SDFSFileName,
+			Version:      e.Version,
+			ContentHash:  e.ContentHash,
+			FileSize:     e.FileSize,
+			Writer:       e.Writer,
+			Reason:       e.Reason,
+			RecordedAt:   e.Time,
+			Seq:          e.Seq,
+		}
+	case JOURNAL_REMOVE:
+		if e.Version > st.Tombstones[e.SDFSFileName] {
+			st.Tombstones[e.SDFSFileName] = e.Version
+		}
+		for v := range st.Files[e.SDFSFileName] {
+			if v <= e.Version {
+				delete(st.Files[e.SDFSFileName], v)
+			}
+		}
+	case JOURNAL_GC:
+		for v := range st.Files[e.SDFSFileName] {
+			if v <= e.Version {
+				delete(st.Files[e.SDFSFileName], v)
+			}
+		}
+	case JOURNAL_DISCARD, JOURNAL_QUARANTINE:
+		delete(st.Files[e.SDFSFileName], e.Version)
+	}
+	if len(st.Files[e.SDFSFileName]) == 0 {
+		delete(st.Files, e.SDFSFileName)
+	}
+}
+
+/*
+Appends e to the journal and fsyncs before returning. Seq and Time are filled in here. Every
+config.JOURNAL_SNAPSHOT_INTERVAL appends we also write a snapshot and start a fresh log.
+*/
+func (j *Journal) Append(e JournalEntry) error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	e.Seq = j.state.Seq + 1
+	e.Time = time.Now().UnixNano()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = j.fd.Write(append(line, '\n'))
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't append to journal! Error: %v", err)
+		return err
+	}
+	err = j.fd.Sync()
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't fsync journal! Error: %v", err)
+		return err
+	}
+	j.state.apply(e)
+	mp3util.NodeLogger.Tracef("Journaled: %+v", e)
+
+	j.sinceSnapshot++
+	if j.sinceSnapshot >= config.JOURNAL_SNAPSHOT_INTERVAL {
+		err = j.snapshot()
+		if err != nil {
+			// Not fatal, the log still has everything. We'll try again on the next append.
+			mp3util.NodeLogger.Warnf("Couldn't snapshot journal! Error: %v", err)
+		}
+	}
+	return nil
+}
+
+/*
+Writes the current state as the snapshot, then swaps in an empty log. Crashing anywhere in here is safe: replay skips log
+entries the snapshot already covers.
+
+ASSUMES CALLER GRABS LOCK.
+*/
+func (j *Journal) snapshot() error {
+	snapshotBytes, err := json.Marshal(j.state)
+	if err != nil {
+		return err
+	}
+	snapshotPath := filepath.Join(j.dir, JOURNAL_SNAPSHOT)
+	err = writeFileSynced(snapshotPath+".partial", snapshotBytes)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(snapshotPath+".partial", snapshotPath)
+	if err != nil {
+		return err
+	}
+
+	logPath := filepath.Join(j.dir, JOURNAL_LOG)
+	err = writeFileSynced(logPath+".partial", nil)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(logPath+".partial", logPath)
+	if err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't reopen journal after snapshot: %v", err))
+	}
+	j.fd.Close()
+	j.fd = fd
+	j.sinceSnapshot = 0
+	mp3util.NodeLogger.Debugf("Snapshotted journal at seq %v", j.state.Seq)
+	return nil
+}
+
+func (j *Journal) Close() error {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	return j.fd.Close()
+}
+
+/*
+What the journal says about one version, if anything.
+*/
+func (j *Journal) Record(sdfsFileName string, version int64) (VersionRecord, bool) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	rec, ok := j.state.Files[sdfsFileName][version]
+	return rec, ok
+}
+
+/*
+Every version the journal believes we hold for sdfsFileName, plus the file's tombstone (0 if it was never deleted).
+*/
+func (j *Journal) FileRecords(sdfsFileName string) ([]VersionRecord, int64) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	var records []VersionRecord
+	for _, rec := range j.state.Files[sdfsFileName] {
+		records = append(records, rec)
+	}
+	return records, j.state.Tombstones[sdfsFileName]
+}
+
+/*
+The sequence number of the last entry appended.
+*/
+func (j *Journal) Seq() int64 {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	return j.state.Seq
+}
+
+/*
+A deep copy of the replayed state, so callers can walk it without holding the lock.
+*/
+func (j *Journal) State() JournalState {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	st := newJournalState()
+	st.Seq = j.state.Seq
+	for name, versions := range j.state.Files {
+		st.Files[name] = make(map[int64]VersionRecord)
+		for v, rec := range versions {
+			st.Files[name][v] = rec
+		}
+	}
+	for name, t := range j.state.Tombstones {
+		st.Tombstones[name] = t
+	}
+	return st
+}
+
+func writeFileSynced(p string, contents []byte) error {
+	fd, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(contents)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(p)
+	}
+	return err
+}
@@ -0,0 +1,326 @@
+package fsys
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/*
+The master's replicated log (see election.go and masterlog.go in amogus/): every decision the master makes goes in as an
+entry, and once a majority of the master group has it, it's applied to MasterState everywhere. So whoever is master next
+knows everything the masters before it decided.
+
+On disk, in masterLogDir/: snapshot.json, the state as of some index, and entries.jsonl, every entry after that, one
+JSON object per line. MasterLog isn't safe for concurrent use; the election's lock covers it.
+*/
+
+const MASTER_LOG_DIR = "masterLogDir"
+
+type MasterOp string
+
+const (
+	MASTER_OP_NOOP    MasterOp = "NOOP"    // What a new master starts its term with, so that it can commit what came before
+	MASTER_OP_VERSION MasterOp = "VERSION" // A version for a write that's only logged once enough replicas have it
+	MASTER_OP_WRITE   MasterOp = "WRITE"
+	MASTER_OP_DELETE  MasterOp = "DELETE"
+	MASTER_OP_MKDIR   MasterOp = "MKDIR"
+	MASTER_OP_RMDIR   MasterOp = "RMDIR"
+	MASTER_OP_RENAME  MasterOp = "RENAME" // Path to NewPath, file or directory
+)
+
+type MasterLogEntry struct {
+	Index        uint64
+	Term         uint64
+	Op           MasterOp
+	SDFSFileName string `json:",omitempty"` // The file's ID, i.e. what replicas store it as (see namespace.go)
+	Path         string `json:",omitempty"` // Where in the namespace. Empty for WRITEs and DELETEs from before there was one.
+	NewPath      string `json:",omitempty"` // RENAME
+	Version      int64  `json:",omitempty"` // VERSION, WRITE: the version the write got. DELETE: versions at or before this go.
+	ContentHash  string `json:",omitempty"`
+	Writer       string `json:",omitempty"`
+}
+
+/*
+What the master knows about a file.
+*/
+type MasterFileRecord struct {
+	Latest      int64 // Newest version written
+	ContentHash string
+	Writer      string
+	Tombstone   int64 // Versions at or before this were deleted
+}
+
+type MasterState struct {
+	LastVersion int64                        // The newest version handed out, to a write or a delete
+	Files       map[string]*MasterFileRecord // By file ID
+	Names       map[string]string            // The namespace (see namespace.go): file path -> ID...
+	Paths       map[string]string            // ...and back
+	Dirs        map[string]bool              // Directories, by path. The root isn't in here, it's always there.
+}
+
+func (st *MasterState) Apply(e MasterLogEntry) {
+	st.init()
+	if e.Version > st.LastVersion {
+		st.LastVersion = e.Version
+	}
+	st.applyNamespace(e)
+	if e.Op != MASTER_OP_WRITE && e.Op != MASTER_OP_DELETE {
+		return
+	}
+	f, ok := st.Files[e.SDFSFileName]
+	if !ok {
+		f = &MasterFileRecord{}
+		st.Files[e.SDFSFileName] = f
+	}
+	if e.Op == MASTER_OP_WRITE && e.Version > f.Latest {
+		f.Latest, f.ContentHash, f.Writer = e.Version, e.ContentHash, e.Writer
+	} else if e.Op == MASTER_OP_DELETE && e.Version > f.Tombstone {
+		f.Tombstone = e.Version
+	}
+}
+
+func (st *MasterState) init() {
+	if st.Files == nil {
+		st.Files = make(map[string]*MasterFileRecord)
+	}
+	if st.Names == nil {
+		st.Names = make(map[string]string)
+	}
+	if st.Paths == nil {
+		st.Paths = make(map[string]string)
+	}
+	if st.Dirs == nil {
+		st.Dirs = make(map[string]bool)
+	}
+}
+
+func (st *MasterState) Copy() MasterState {
+	var c MasterState
+	c.init()
+	c.LastVersion = st.LastVersion
+	for name, f := range st.Files {
+		record := *f
+		c.Files[name] = &record
+	}
+	for path, id := range st.Names {
+		c.Names[path] = id
+	}
+	for id, path := range st.Paths {
+		c.Paths[id] = path
+	}
+	for dir := range st.Dirs {
+		c.Dirs[dir] = true
+	}
+	return c
+}
+
+type MasterSnapshot struct {
+	Index uint64 // The last entry State includes...
+	Term  uint64 // ...and its term
+	State MasterState
+}
+
+type MasterLog struct {
+	dir      string
+	snapshot MasterSnapshot
+	entries  []MasterLogEntry // Everything after snapshot.Index, in order
+}
+
+/*
+Opens the master log under the storage root, picking up whatever was there.
+*/
+func (s *LocalSDFSStorage) OpenMasterLog() (*MasterLog, error) {
+	l := &MasterLog{dir: filepath.Join(s.RootDir, MASTER_LOG_DIR)}
+	err := os.MkdirAll(l.dir, 0777)
+	if err != nil {
+		return nil, err
+	}
+	j, err := os.ReadFile(filepath.Join(l.dir, "snapshot.json"))
+	if err == nil {
+		err = json.Unmarshal(j, &l.snapshot)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.New(fmt.Sprintf("couldn't read the master log snapshot: %v", err))
+	}
+	contents, err := os.ReadFile(l.entriesPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var e MasterLogEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			// A crash halfway through an append. Nobody was told it was there, so it goes.
+			break
+		}
+		if e.Index == l.snapshot.Index+uint64(len(l.entries))+1 {
+			l.entries = append(l.entries, e)
+		}
+	}
+	return l, l.rewrite()
+}
+
+func (l *MasterLog) entriesPath() string {
+	return filepath.Join(l.dir, "entries.jsonl")
+}
+
+func (l *MasterLog) LastIndex() uint64 {
+	return l.snapshot.Index + uint64(len(l.entries))
+}
+
+func (l *MasterLog) LastTerm() uint64 {
+	if len(l.entries) == 0 {
+		return l.snapshot.Term
+	}
+	return l.entries[len(l.entries)-1].Term
+}
+
+/*
+The term of the entry at index, if we have it: the log goes that far, and it hasn't been compacted away (the snapshot's
+last entry still counts).
+*/
+func (l *MasterLog) Term(index uint64) (uint64, bool) {
+	if index == l.snapshot.Index {
+		return l.snapshot.Term, true
+	}
+	if index < l.snapshot.Index || index > l.LastIndex() {
+		return 0, false
+	}
+	return l.entries[index-l.snapshot.Index-1].Term, true
+}
+
+/*
+Up to max entries, starting at from, which has to be after the snapshot.
+*/
+func (l *MasterLog) Entries(from uint64, max int) []MasterLogEntry {
+	if from <= l.snapshot.Index || from > l.LastIndex() {
+		return nil
+	}
+	entries := l.entries[from-l.snapshot.Index-1:]
+	if len(entries) > max {
+		entries = entries[:max]
+	}
+	return append([]MasterLogEntry(nil), entries...)
+}
+
+/*
+The last entry the snapshot covers. Entries up to here are gone from the log.
+*/
+func (l *MasterLog) SnapshotIndex() uint64 {
+	return l.snapshot.Index
+}
+
+func (l *MasterLog) Snapshot() MasterSnapshot {
+	return MasterSnapshot{Index: l.snapshot.Index, Term: l.snapshot.Term, State: l.snapshot.State.Copy()}
+}
+
+/*
+Appends entries (which have to pick up right where the log ends), synced to disk before it returns.
+*/
+func (l *MasterLog) Append(entries ...MasterLogEntry) error {
+	var buf bytes.Buffer
+	for i, e := range entries {
+		if e.Index != l.LastIndex()+uint64(i)+1 {
+			return errors.New(fmt.Sprintf("entry %v doesn't follow the end of the log (%v)", e.Index, l.LastIndex()+uint64(i)))
+		}
+		j, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(j)
+		buf.WriteByte('\n')
+	}
+	fd, err := os.OpenFile(l.entriesPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(buf.Bytes())
+	if err == nil {
+		err = fd.Sync()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Whatever made it to disk is half an append; put the file back the way it was
+		l.rewrite()
+		return err
+	}
+	l.entries = append(l.entries, entries...)
+	return nil
+}
+
+/*
+Throws away every entry after index, because the master has different ones there.
+*/
+func (l *MasterLog) TruncateAfter(index uint64) error {
+	if index < l.snapshot.Index {
+		return errors.New(fmt.Sprintf("can't truncate to %v, entries up to %v are in the snapshot", index, l.snapshot.Index))
+	}
+	if index < l.LastIndex() {
+		l.entries = l.entries[:index-l.snapshot.Index]
+	}
+	return l.rewrite()
+}
+
+/*
+Replaces everything up to index (which has to be in the log) with state, which should be the log applied up to there.
+*/
+func (l *MasterLog) Compact(index uint64, state MasterState) error {
+	term, ok := l.Term(index)
+	if !ok {
+		return errors.New(fmt.Sprintf("can't compact up to %v, it isn't in the log", index))
+	}
+	return l.InstallSnapshot(MasterSnapshot{Index: index, Term: term, State: state})
+}
+
+/*
+Takes snap as our snapshot. The entries after it are kept if our log agrees with it; otherwise they can't be right.
+*/
+func (l *MasterLog) InstallSnapshot(snap MasterSnapshot) error {
+	var entries []MasterLogEntry
+	if term, ok := l.Term(snap.Index); ok && term == snap.Term {
+		entries = append(entries, l.entries[snap.Index-l.snapshot.Index:]...)
+	}
+	j, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(l.dir, "snapshot.json")
+	err = writeFileSynced(p+".partial", j)
+	if err == nil {
+		err = os.Rename(p+".partial", p)
+	}
+	if err != nil {
+		return err
+	}
+	l.snapshot = snap
+	l.entries = entries
+	return l.rewrite()
+}
+
+/*
+Writes out entries.jsonl from scratch.
+*/
+func (l *MasterLog) rewrite() error {
+	var buf bytes.Buffer
+	for _, e := range l.entries {
+		j, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(j)
+		buf.WriteByte('\n')
+	}
+	err := writeFileSynced(l.entriesPath()+".partial", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return os.Rename(l.entriesPath()+".partial", l.entriesPath())
+}
@@ -0,0 +1,160 @@
+package fsys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+const MERKLE_MAX_DEPTH = 16
+
+/*
+One (file, version, hash) tuple, i.e. what anti-entropy compares between replicas.
+*/
+type MerkleEntry struct {
+	SDFSFileName string
+	Version      int64
+	ContentHash  string
+}
+
+/*
+A Merkle tree over the versions we hold for some set of files (in practice, the ones we share with one other replica).
+Files are spread over the 1<<Depth leaves by MerkleBucket, so two replicas holding the same versions end up with the
+same tree no matter how they got there.
+
+Nodes is laid out like a heap: Nodes[1] is the root, the children of node i are 2i and 2i+1, and the leaves are
+Nodes[1<<Depth:]. Nodes[0] is unused.
+*/
+type MerkleTree struct {
+	Depth      int
+	Nodes      []string
+	Leaves     [][]MerkleEntry  // What went into each leaf, sorted
+	Tombstones map[string]int64 // Of the files the tree covers. Not hashed, but handed out along with leaves.
+}
+
+/*
+Which leaf sdfsFileName goes into. This only has to agree between replicas, so it's a different hash than the ring's
+(files next to each other on the ring would all land in the same few leaves otherwise).
+*/
+func MerkleBucket(sdfsFileName string, depth int) int {
+	h := fnv.New32a()
+	h.Write([]byte(sdfsFileName))
+	return int(h.Sum32() & (1<<depth - 1))
+}
+
+func BuildMerkleTree(entries []MerkleEntry, tombstones map[string]int64, depth int) *MerkleTree {
+	if depth < 0 {
+		depth = 0
+	} else if depth > MERKLE_MAX_DEPTH {
+		depth = MERKLE_MAX_DEPTH
+	}
+	t := &MerkleTree{
+		Depth:      depth,
+		Nodes:      make([]string, 2<<depth),
+		Leaves:     make([][]MerkleEntry, 1<<depth),
+		Tombstones: tombstones,
+	}
+	for _, e := range entries {
+		b := MerkleBucket(e.SDFSFileName, depth)
+		t.Leaves[b] = append(t.Leaves[b], e)
+	}
+	for b, leaf := range t.Leaves {
+		sort.Slice(leaf, func(i, j int) bool {
+			if leaf[i].SDFSFileName != leaf[j].SDFSFileName {
+				return leaf[i].SDFSFileName < leaf[j].SDFSFileName
+			}
+			return leaf[i].Version < leaf[j].Version
+		})
+		h := sha256.New()
+		for _, e := range leaf {
+			fmt.Fprintf(h, "%v\x00%v\x00%v\n", e.SDFSFileName, e.Version, e.ContentHash)
+		}
+		t.Nodes[1<<depth+b] = hex.EncodeToString(h.Sum(nil))
+	}
+	for i := 1<<depth - 1; i >= 1; i-- {
+		h := sha256.Sum256([]byte(t.Nodes[2*i] + t.Nodes[2*i+1]))
+		t.Nodes[i] = hex.EncodeToString(h[:])
+	}
+	return t
+}
+
+func (t *MerkleTree) Root() string {
+	return t.Nodes[1]
+}
+
+/*
+Whether node is a leaf, and if so which one.
+*/
+func (t *MerkleTree) LeafIndex(node int) (int, bool) {
+	if node < 1<<t.Depth {
+		return 0, false
+	}
+	return node - 1<<t.Depth, true
+}
+
+/*
+The hashes of the given nodes, "" for ones that aren't in the tree.
+*/
+func (t *MerkleTree) Hashes(nodes []int) []string {
+	hashes := make([]string, len(nodes))
+	for i, n := range nodes {
+		if n >= 1 && n < len(t.Nodes) {
+			hashes[i] = t.Nodes[n]
+		}
+	}
+	return hashes
+}
+
+/*
+Everything in the given leaves, plus the tombstones of the files that would land in them.
+*/
+func (t *MerkleTree) LeafContents(leaves []int) ([]MerkleEntry, map[string]int64) {
+	var entries []MerkleEntry
+	wanted := make(map[int]bool)
+	for _, b := range leaves {
+		if b >= 0 && b < len(t.Leaves) {
+			entries = append(entries, t.Leaves[b]...)
+			wanted[b] = true
+		}
+	}
+	tombstones := make(map[string]int64)
+	for name, version := range t.Tombstones {
+		if wanted[MerkleBucket(name, t.Depth)] {
+			tombstones[name] = version
+		}
+	}
+	return entries, tombstones
+}
+
+/*
+The (file, version, hash) tuples of every version we hold of a file that keep says yes to, the tombstones of those
+files, and the journal sequence number they're as of (so callers can tell when a tree built from them is out of date).
+*/
+func (s *LocalSDFSStorage) MerkleEntries(keep func(sdfsFileName string) bool) ([]MerkleEntry, map[string]int64, int64) {
+	st := s.journal.State()
+	var entries []MerkleEntry
+	for name, versions := range st.Files {
+		if !keep(name) {
+			continue
+		}
+		for version, rec := range versions {
+			entries = append(entries, MerkleEntry{SDFSFileName: name, Version: version, ContentHash: rec.ContentHash})
+		}
+	}
+	tombstones := make(map[string]int64)
+	for name, version := range st.Tombstones {
+		if keep(name) {
+			tombstones[name] = version
+		}
+	}
+	return entries, tombstones, st.Seq
+}
+
+/*
+The journal sequence number, which goes up every time what we store changes.
+*/
+func (s *LocalSDFSStorage) JournalSeq() int64 {
+	return s.journal.Seq()
+}
@@ -0,0 +1,114 @@
+package fsys
+
+import (
+	"amogus/mp3util"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+/*
+Metadata lives in a directory tree that mirrors storedfileDir, so that nothing walking storedfileDir has to learn to skip
+our files:
+
+	-------sdfs/
+			|----storedfileDir/
+					|----amongus/
+							|----111156363265365
+			|----metadataDir/
+					|----amongus/
+							|----111156363265365 (JSON VersionMetadata)
+*/
+func (s *LocalSDFSStorage) versionMetadataPath(sdfsFileName string, version int64) string {
+	return filepath.Join(s.metadataDir, sdfsFileName, VersionName(version))
+}
+
+/*
+Writes the metadata to a scratch file and renames it into place, so a crash never leaves a half-written JSON blob behind.
+*/
+func (s *LocalSDFSStorage) writeVersionMetadata(sdfsFileName string, version int64, meta VersionMetadata) error {
+	target := s.versionMetadataPath(sdfsFileName, version)
+	err := os.MkdirAll(filepath.Dir(target), 0777)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't create metadata directory for %v! Error: %v", sdfsFileName, err)
+		return err
+	}
+	j, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	scratch := target + ".partial"
+	err = writeFileSynced(scratch, j)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't write metadata to %v! Error: %v", scratch, err)
+		return err
+	}
+	return os.Rename(scratch, target)
+}
+
+func (s *LocalSDFSStorage) ReadVersionMetadata(sdfsFileName string, version int64) (VersionMetadata, error) {
+	var meta VersionMetadata
+	j, err := os.ReadFile(s.versionMetadataPath(sdfsFileName, version))
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(j, &meta)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Metadata for %v @ %v is corrupted! Error: %v", sdfsFileName, version, err)
+		return meta, err
+	}
+	return meta, nil
+}
+
+func (s *LocalSDFSStorage) removeVersionMetadata(sdfsFileName string, version int64) {
+	err := os.Remove(s.versionMetadataPath(sdfsFileName, version))
+	if err != nil && !os.IsNotExist(err) {
+		mp3util.NodeLogger.Warnf("Couldn't remove metadata for %v @ %v! Error: %v", sdfsFileName, version, err)
+	}
+}
+
+func (s *LocalSDFSStorage) removeFileMetadata(sdfsFileName string) {
+	err := os.RemoveAll(filepath.Join(s.metadataDir, sdfsFileName))
+	if err != nil {
+		mp3util.NodeLogger.Warnf("Couldn't remove metadata directory for %v! Error: %v", sdfsFileName, err)
+	}
+}
+
+/*
+Re-reads a stored version end to end and checks it against its metadata. Returns ErrContentHashMismatch if the bytes on
+disk aren't what was registered.
+*/
+func (s *LocalSDFSStorage) VerifyVersion(sdfsFileName string, version int64) error {
+	meta, err := s.ReadVersionMetadata(sdfsFileName, version)
+	if err != nil {
+		return err
+	}
+	manifest, err := s.ReadManifest(sdfsFileName, version)
+	if err != nil {
+		return err
+	}
+	if manifest.CompressedSize != meta.FileSize {
+		return ErrContentHashMismatch
+	}
+	actual, err := s.hashManifestContent(manifest)
+	if err != nil || actual != meta.ContentHash {
+		mp3util.NodeLogger.Errorf("%v @ %v hashes to %v, expected %v! (Error: %v)", sdfsFileName, version, actual, meta.ContentHash, err)
+		return ErrContentHashMismatch
+	}
+	return nil
+}
+
+/*
+Takes a version we know is bad out of circulation: the manifest goes to quarantineDir, and its chunks are released.
+Somebody else's good copy will come back to us through replication.
+*/
+func (s *LocalSDFSStorage) QuarantineVersion(sdfsFileName string, version int64, reason string) {
+	manifest, manifestErr := s.ReadManifest(sdfsFileName, version)
+	s.quarantineVersion(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFileName, VersionName(version)), sdfsFileName, version, reason)
+	if manifestErr == nil {
+		s.releaseManifest(manifest)
+	}
+	// Only fails (harmlessly) if there are other versions left.
+	os.Remove(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFileName))
+	os.Remove(filepath.Join(s.metadataDir, sdfsFileName))
+}
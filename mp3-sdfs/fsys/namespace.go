@@ -0,0 +1,293 @@
+package fsys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+The SDFS namespace: directories, and files in them, kept by the master group as part of MasterState (so every change
+to it is a master log entry, and renames are atomic). Paths look like a/b/c, with an optional leading slash; the root
+directory is "" (or "/").
+
+A file's path only matters to the master. Replicas, the partitioner and everything on disk go by the file's ID, which
+the file keeps when it's renamed, so moving a file (or a whole directory of them) doesn't move any data. New files get
+a made-up ID, except for ones right in the root, whose ID is their name when that's free. That's what their ID was
+before there was a namespace, so files written back then are still found under the same name. (They aren't in the
+namespace itself, though, so they don't show up in listings and can't be moved, until they're next written.)
+*/
+
+const MAX_PATH_LENGTH = 4096
+const MAX_NAME_LENGTH = 255
+
+var ErrIsDirectory = errors.New("is a directory")
+var ErrNotDirectory = errors.New("not a directory")
+var ErrDirectoryNotEmpty = errors.New("directory not empty")
+
+/*
+Checks path and puts it in canonical form: no leading or trailing slash, no empty, "." or ".." components. The root
+comes out as "".
+*/
+func CleanPath(path string) (string, error) {
+	if len(path) > MAX_PATH_LENGTH {
+		return "", errors.New(fmt.Sprintf("path is longer than %v bytes", MAX_PATH_LENGTH))
+	}
+	path = strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/")
+	if path == "" {
+		return "", nil
+	}
+	for _, name := range strings.Split(path, "/") {
+		switch {
+		case name == "":
+			return "", errors.New(fmt.Sprintf("%v has an empty component", path))
+		case name == "." || name == "..":
+			return "", errors.New(fmt.Sprintf("%v has a %v component", path, name))
+		case len(name) > MAX_NAME_LENGTH:
+			return "", errors.New(fmt.Sprintf("%v has a component longer than %v bytes", path, MAX_NAME_LENGTH))
+		case strings.ContainsAny(name, "\x00\\"):
+			return "", errors.New(fmt.Sprintf("%v has a NUL or backslash in it", path))
+		}
+	}
+	return path, nil
+}
+
+/*
+Like CleanPath, but for files, so the root doesn't count.
+*/
+func CleanFilePath(path string) (string, error) {
+	clean, err := CleanPath(path)
+	if err == nil && clean == "" {
+		err = ErrIsDirectory
+	}
+	return clean, err
+}
+
+/*
+Whether name can be what a file is stored as under sdfs/, i.e. be a file ID: a single clean path component. Anything
+else could land outside of sdfs/, or in some other file's directory.
+*/
+func CheckSDFSFileName(name string) error {
+	clean, err := CleanPath(name)
+	if err == nil && (clean != name || clean == "" || strings.Contains(clean, "/")) {
+		err = errors.New("not a single path component")
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("bad SDFS file name %q: %v", name, err))
+	}
+	return nil
+}
+
+func parentDir(path string) string {
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+/*
+Whether path is inside dir (at any depth).
+*/
+func underDir(path string, dir string) bool {
+	return dir == "" || strings.HasPrefix(path, dir+"/")
+}
+
+type NamespaceEntry struct {
+	Path   string
+	Dir    bool
+	FileId string // Files only
+}
+
+func (st *MasterState) IsDir(path string) bool {
+	return path == "" || st.Dirs[path]
+}
+
+/*
+The ID of the file at path (which has to be clean). A path in the root that nothing was ever created at still gets its
+own name as the ID, in case it's a file from before there was a namespace; if it isn't, the replicas won't have it
+either.
+*/
+func (st *MasterState) ResolveFile(path string) (string, error) {
+	if st.IsDir(path) {
+		return "", ErrIsDirectory
+	}
+	if id, ok := st.Names[path]; ok {
+		return id, nil
+	}
+	if _, taken := st.Paths[path]; taken || strings.Contains(path, "/") {
+		// Nothing here, or the file that had this ID lives somewhere else now
+		return "", os.ErrNotExist
+	}
+	return path, nil
+}
+
+/*
+Whether the file at path exists as far as the namespace knows (not counting files from before it).
+*/
+func (st *MasterState) HasFile(path string) bool {
+	_, ok := st.Names[path]
+	return ok
+}
+
+/*
+The ID a new file at path should get, given newId made up by the master: a file right in the root gets its own name,
+if no other file has that as its ID.
+*/
+func (st *MasterState) IdForNewFile(path string, newId string) string {
+	if !strings.Contains(path, "/") {
+		if _, taken := st.Paths[path]; !taken {
+			return path
+		}
+	}
+	return newId
+}
+
+/*
+Whether e can be applied to the namespace as it is. The master checks before logging anything, but since entries can
+be logged (by a master that's since been deposed) before ones committed ahead of them, Apply checks again and leaves
+the namespace alone if it's no good anymore.
+*/
+func (st *MasterState) Check(e MasterLogEntry) error {
+	switch e.Op {
+	case MASTER_OP_WRITE:
+		if e.Path == "" {
+			return nil // From before the namespace
+		}
+		if st.IsDir(e.Path) {
+			return ErrIsDirectory
+		}
+		if id, ok := st.Names[e.Path]; ok {
+			if id != e.SDFSFileName {
+				return errors.New(fmt.Sprintf("%v was created as another file in the meantime", e.Path))
+			}
+			return nil
+		}
+		if !st.IsDir(parentDir(e.Path)) {
+			return errors.New(fmt.Sprintf("%v: %v", parentDir(e.Path), os.ErrNotExist))
+		}
+		if path, taken := st.Paths[e.SDFSFileName]; taken && path != e.Path {
+			return errors.New(fmt.Sprintf("file ID %v is already %v", e.SDFSFileName, path))
+		}
+	case MASTER_OP_MKDIR:
+		if e.Path == "" || st.IsDir(e.Path) {
+			return os.ErrExist
+		}
+		if _, ok := st.Names[e.Path]; ok {
+			return os.ErrExist
+		}
+		if !st.IsDir(parentDir(e.Path)) {
+			return errors.New(fmt.Sprintf("%v: %v", parentDir(e.Path), os.ErrNotExist))
+		}
+	case MASTER_OP_RMDIR:
+		if e.Path == "" {
+			return errors.New("can't remove the root directory")
+		}
+		if !st.IsDir(e.Path) {
+			if _, ok := st.Names[e.Path]; ok {
+				return ErrNotDirectory
+			}
+			return os.ErrNotExist
+		}
+		if len(st.List(e.Path, false)) > 0 {
+			return ErrDirectoryNotEmpty
+		}
+	case MASTER_OP_RENAME:
+		_, isFile := st.Names[e.Path]
+		if e.Path == "" || (!isFile && !st.IsDir(e.Path)) {
+			return errors.New(fmt.Sprintf("%v: %v", e.Path, os.ErrNotExist))
+		}
+		if _, ok := st.Names[e.NewPath]; ok || st.IsDir(e.NewPath) {
+			return errors.New(fmt.Sprintf("%v: %v", e.NewPath, os.ErrExist))
+		}
+		if !st.IsDir(parentDir(e.NewPath)) {
+			return errors.New(fmt.Sprintf("%v: %v", parentDir(e.NewPath), os.ErrNotExist))
+		}
+		if !isFile && underDir(e.NewPath, e.Path) {
+			return errors.New(fmt.Sprintf("can't move %v into itself", e.Path))
+		}
+	}
+	return nil
+}
+
+/*
+Applies e's change to the namespace, if it's still good (see Check).
+*/
+func (st *MasterState) applyNamespace(e MasterLogEntry) {
+	if st.Check(e) != nil {
+		return
+	}
+	switch e.Op {
+	case MASTER_OP_WRITE:
+		if e.Path != "" {
+			st.Names[e.Path] = e.SDFSFileName
+			st.Paths[e.SDFSFileName] = e.Path
+		}
+	case MASTER_OP_DELETE:
+		if id, ok := st.Names[e.Path]; ok && id == e.SDFSFileName {
+			delete(st.Names, e.Path)
+			delete(st.Paths, id)
+		}
+	case MASTER_OP_MKDIR:
+		st.Dirs[e.Path] = true
+	case MASTER_OP_RMDIR:
+		delete(st.Dirs, e.Path)
+	case MASTER_OP_RENAME:
+		if id, ok := st.Names[e.Path]; ok {
+			delete(st.Names, e.Path)
+			st.Names[e.NewPath] = id
+			st.Paths[id] = e.NewPath
+			return
+		}
+		/* A directory: it and everything under it. Gathered up first, so nothing gets moved twice. */
+		moved := map[string]string{e.Path: e.NewPath}
+		for dir := range st.Dirs {
+			if underDir(dir, e.Path) {
+				moved[dir] = e.NewPath + strings.TrimPrefix(dir, e.Path)
+			}
+		}
+		for from, to := range moved {
+			delete(st.Dirs, from)
+			st.Dirs[to] = true
+		}
+		moved = make(map[string]string)
+		for path := range st.Names {
+			if underDir(path, e.Path) {
+				moved[path] = e.NewPath + strings.TrimPrefix(path, e.Path)
+			}
+		}
+		for from, to := range moved {
+			if id, ok := st.Names[from]; ok {
+				delete(st.Names, from)
+				st.Names[to] = id
+				st.Paths[id] = to
+			}
+		}
+	}
+}
+
+/*
+What's in dir, or with recursive, under it, sorted by path. Only what the namespace knows about: files from before it
+aren't in any directory.
+*/
+func (st *MasterState) List(dir string, recursive bool) []NamespaceEntry {
+	var entries []NamespaceEntry
+	in := func(path string) bool {
+		return underDir(path, dir) && path != dir && (recursive || parentDir(path) == dir)
+	}
+	for path := range st.Dirs {
+		if in(path) {
+			entries = append(entries, NamespaceEntry{Path: path, Dir: true})
+		}
+	}
+	for path, id := range st.Names {
+		if in(path) {
+			entries = append(entries, NamespaceEntry{Path: path, FileId: id})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
@@ -0,0 +1,113 @@
+package fsys
+
+import (
+	"amogus/mp3util"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*
+Part of a version, for ranged reads. Since every chunk is its own gzip member and the manifest says where each chunk
+starts in the uncompressed content, we never have to unzip anything on our side: we send the chunks that overlap the
+range, and the reader throws away Skip bytes at the front and keeps Length.
+*/
+type RangeHandle struct {
+	SDFSFileName   string
+	Version        int64
+	Chunks         []ChunkRef    // Only the chunks overlapping the range, in order
+	Skip           int64         // Uncompressed bytes before the range, in the first chunk
+	Length         int64         // Uncompressed bytes in the range (can be less than asked for, at the end of the file)
+	CompressedSize int64         // What Handle will give you
+	Handle         io.ReadCloser // The chunks, concatenated
+}
+
+/*
+Opens [offset, offset+length) of the uncompressed content of the latest version of sdfsFileName at or before
+upperVersionBound. A range starting at or past the end of the file is empty, not an error.
+*/
+func (s *LocalSDFSStorage) AcquireRange(sdfsFileName string, upperVersionBound int64, offset int64, length int64) (*RangeHandle, error) {
+	if offset < 0 || length <= 0 {
+		return nil, os.ErrInvalid
+	}
+	handles, err := s.AcquireFileHandles(1, sdfsFileName, upperVersionBound)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseHandles(handles) // Keeps the chunks around until the range's own reader has them
+	if len(handles) == 0 {
+		return nil, os.ErrNotExist
+	}
+	version := handles[0].Version
+	manifest, err := s.ReadManifest(sdfsFileName, version)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't read manifest of %v @ %v! Error: %v", sdfsFileName, version, err)
+		return nil, err
+	}
+
+	h := &RangeHandle{SDFSFileName: sdfsFileName, Version: version}
+	if offset < manifest.Size {
+		h.Length = length
+		if offset+length > manifest.Size {
+			h.Length = manifest.Size - offset
+		}
+		for _, ref := range manifest.Chunks {
+			if ref.Offset+ref.Size <= offset || ref.Offset >= offset+h.Length {
+				continue
+			}
+			if len(h.Chunks) == 0 {
+				h.Skip = offset - ref.Offset
+			}
+			h.Chunks = append(h.Chunks, ref)
+			h.CompressedSize += ref.CompressedSize
+		}
+	}
+	h.Handle = s.newManifestReader(ChunkManifest{Chunks: h.Chunks})
+	return h, nil
+}
+
+/*
+The receiving end of a RangeHandle: unzips the chunks from source one at a time, checks each against its hash, and
+writes just the requested range to target. Returns how many bytes were written.
+*/
+func RecvRangeFromGzip(source io.Reader, chunks []ChunkRef, skip int64, length int64, target io.Writer) (int64, error) {
+	var written int64
+	for _, ref := range chunks {
+		gzipDecoder, err := gzip.NewReader(&io.LimitedReader{R: source, N: ref.CompressedSize})
+		if err != nil {
+			return written, err
+		}
+		gzipDecoder.Multistream(false)
+		hashWriter := sha256.New()
+		uncompressed, err := io.ReadAll(io.TeeReader(gzipDecoder, hashWriter))
+		if err != nil {
+			return written, err
+		}
+		if hex.EncodeToString(hashWriter.Sum(nil)) != ref.Hash {
+			mp3util.NodeLogger.Errorf("Chunk %v of the range doesn't match its hash!", ref.Hash)
+			return written, ErrContentHashMismatch
+		}
+		if int64(len(uncompressed)) <= skip {
+			skip -= int64(len(uncompressed))
+			continue
+		}
+		uncompressed = uncompressed[skip:]
+		skip = 0
+		if int64(len(uncompressed)) > length-written {
+			uncompressed = uncompressed[:length-written]
+		}
+		n, err := target.Write(uncompressed)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	if written != length {
+		return written, errors.New(fmt.Sprintf("range came up short: got %v of %v bytes", written, length))
+	}
+	return written, nil
+}
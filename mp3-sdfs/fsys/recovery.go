@@ -0,0 +1,439 @@
+package fsys
+
+import (
+	"amogus/config"
+	"amogus/mp3util"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+RecoverSDFSStorage is the non-destructive sibling of NewSDFSStorage. Instead of nuking the sdfs directory, it rescans
+whatever a previous incarnation of this replica left behind:
+  - Every chunk in chunkDir is unzipped and checked against its name. Bad chunks are quarantined.
+  - Every version (manifest) under storedfileDir is checked against its VersionMetadata (or, if that's gone, against
+    the journal): all its chunks have to be there and intact, and the content they add up to has to hash right.
+    Anything that doesn't check out (no metadata, wrong hash, wrong size, missing chunk, unparseable version) is moved
+    into quarantineDir. Version files from before the chunk store (plain gzip blobs) are verified the old way and then
+    moved into the chunk store.
+  - Versions the journal says were deleted (at or below the file's tombstone) are deleted again, since we evidently
+    crashed halfway through.
+  - Tmpfiles that were half-written when we went down (tmp-*) or that nobody finalized in time are quarantined too.
+  - Resumable uploads that haven't expired are picked back up (see recoverUploads).
+  - Metadata with no version file attached is thrown away.
+  - Finally the journal is reconciled with what survived, so that it describes exactly what is on disk, and the chunk
+    reference counts are rebuilt from the surviving manifests (chunks nobody references are deleted).
+
+Whatever survives is remembered in s.recovered, for reconcileJournal.
+*/
+func RecoverSDFSStorage() (*LocalSDFSStorage, error) {
+	rootDir := filepath.Join(".", ROOTDIR)
+	s, err := makeSDFSStorage(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	mp3util.NodeLogger.Infof("Recovering SDFS storage from %v...", rootDir)
+	err = s.quarantineStaleTmpfiles()
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't sweep tmpfiles during recovery! Error: %v", err)
+		return nil, err
+	}
+	goodChunks := s.verifyChunks()
+	manifests, err := s.verifyStoredVersions(goodChunks)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't verify stored versions during recovery! Error: %v", err)
+		return nil, err
+	}
+	s.dropOrphanedMetadata()
+	s.recoverUploads()
+	err = s.reconcileJournal()
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't reconcile the journal during recovery! Error: %v", err)
+		return nil, err
+	}
+	s.chunks.Rebuild(manifests)
+
+	numVersions := 0
+	for _, versions := range s.recovered {
+		numVersions += len(versions)
+	}
+	mp3util.NodeLogger.Infof("Recovered %v versions of %v files (%v chunks).", numVersions, len(s.recovered), len(s.chunks.refcounts))
+	return s, nil
+}
+
+/*
+Moves p into quarantineDir. The quarantined name keeps the original path (slashes flattened) so a human can figure out
+where it came from.
+*/
+func (s *LocalSDFSStorage) quarantine(p string, reason string) {
+	rel, err := filepath.Rel(s.RootDir, p)
+	if err != nil {
+		rel = filepath.Base(p)
+	}
+	target := filepath.Join(s.quarantineDir, fmt.Sprintf("%v-%v", time.Now().UnixNano(), strings.ReplaceAll(rel, string(filepath.Separator), "_")))
+	mp3util.NodeLogger.Warnf("Quarantining %v (%v) to %v", p, reason, target)
+	err = os.Rename(p, target)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't quarantine %v! Removing it instead. Error: %v", p, err)
+		os.Remove(p)
+	}
+}
+
+/*
+Quarantines a version file and forgets about it everywhere else, journal included.
+*/
+func (s *LocalSDFSStorage) quarantineVersion(p string, sdfsFileName string, version int64, reason string) {
+	s.quarantine(p, reason)
+	s.removeVersionMetadata(sdfsFileName, version)
+	err := s.journal.Append(JournalEntry{
+		Op:           JOURNAL_QUARANTINE,
+		SDFSFileName: sdfsFileName,
+		Version:      version,
+		Reason:       reason,
+	})
+	if err != nil {
+		mp3util.NodeLogger.Warnf("Couldn't journal quarantine of %v @ %v! Error: %v", sdfsFileName, version, err)
+	}
+}
+
+func (s *LocalSDFSStorage) quarantineStaleTmpfiles() error {
+	entries, err := os.ReadDir(s.tmpfileDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		p := filepath.Join(s.tmpfileDir, e.Name())
+		if strings.HasPrefix(e.Name(), "tmp-") {
+			// DumpBytesToTmpfile never got to rename this one, so it's missing bytes.
+			s.quarantine(p, "partial tmpfile")
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			s.quarantine(p, "unreadable tmpfile")
+			continue
+		}
+		if time.Since(info.ModTime()) > config.TMPFILE_ORPHAN_AGE {
+			// Nobody sent a FINALIZE_WRITE for this in time. Nobody ever will.
+			s.quarantine(p, "orphaned tmpfile")
+			continue
+		}
+		hash, err := hashFile(p)
+		if err != nil || hash != e.Name() {
+			s.quarantine(p, "tmpfile does not match its content hash")
+		}
+	}
+	return nil
+}
+
+/*
+Checks every chunk in the chunk store. Returns the ones that are intact (and their uncompressed sizes); the rest are
+quarantined.
+*/
+func (s *LocalSDFSStorage) verifyChunks() map[string]int64 {
+	good := make(map[string]int64)
+	filepath.WalkDir(s.chunks.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if isPartial(d.Name()) {
+			os.Remove(p) // Never got renamed into place, so nothing can be pointing at it.
+			return nil
+		}
+		if len(d.Name()) < 2 || filepath.Base(filepath.Dir(p)) != d.Name()[:2] {
+			s.quarantine(p, "not a chunk")
+			return nil
+		}
+		size, err := s.chunks.Verify(d.Name())
+		if err != nil {
+			s.quarantine(p, fmt.Sprintf("corrupt chunk: %v", err))
+			return nil
+		}
+		good[d.Name()] = size
+		return nil
+	})
+	return good
+}
+
+/*
+Verifies every version under storedfileDir, see RecoverSDFSStorage. Returns the manifests of the versions that
+survived.
+*/
+func (s *LocalSDFSStorage) verifyStoredVersions(goodChunks map[string]int64) ([]ChunkManifest, error) {
+	storedDir := filepath.Join(s.RootDir, STOREDFILE_DIR)
+	var manifests []ChunkManifest
+	err := filepath.WalkDir(storedDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if isPartial(d.Name()) {
+			os.Remove(p) // A manifest we crashed while writing. The version was never registered.
+			return nil
+		}
+		// The sdfs file name is the path of the containing directory, relative to storedfileDir.
+		sdfsFileName, err := filepath.Rel(storedDir, filepath.Dir(p))
+		if err != nil || sdfsFileName == "." {
+			s.quarantine(p, "not inside a file directory")
+			return nil
+		}
+		version, err := ParseVersion(d.Name())
+		if err != nil {
+			s.quarantine(p, "filename is not a version")
+			return nil
+		}
+		if _, tombstone := s.journal.FileRecords(sdfsFileName); version <= tombstone {
+			mp3util.NodeLogger.Infof("Finishing interrupted delete of %v @ %v", sdfsFileName, version)
+			os.Remove(p)
+			s.removeVersionMetadata(sdfsFileName, version)
+			return nil
+		}
+		rec, journaled := s.journal.Record(sdfsFileName, version)
+		meta, err := s.ReadVersionMetadata(sdfsFileName, version)
+		metadataLost := err != nil
+		if metadataLost {
+			if !journaled {
+				s.quarantineVersion(p, sdfsFileName, version, "no usable metadata")
+				return nil
+			}
+			meta = VersionMetadata{ContentHash: rec.ContentHash, FileSize: rec.FileSize, Writer: rec.Writer} // Attributes are gone, though
+		}
+
+		var manifest ChunkManifest
+		if isGzipFile(p) {
+			manifest, err = s.migrateLegacyVersion(p, sdfsFileName, version, meta, rec.Writer)
+			if err != nil {
+				s.quarantineVersion(p, sdfsFileName, version, err.Error())
+				return nil
+			}
+		} else {
+			manifest, err = readManifest(p)
+			if err != nil {
+				s.quarantineVersion(p, sdfsFileName, version, "unreadable manifest")
+				return nil
+			}
+			if manifest.CompressedSize != meta.FileSize {
+				s.quarantineVersion(p, sdfsFileName, version, "size does not match metadata")
+				return nil
+			}
+			for _, ref := range manifest.Chunks {
+				if size, ok := goodChunks[ref.Hash]; !ok || size != ref.Size {
+					s.quarantineVersion(p, sdfsFileName, version, fmt.Sprintf("chunk %v is missing or corrupt", ref.Hash))
+					return nil
+				}
+			}
+			// Without taking references: there aren't any to take until Rebuild, and dropping one would delete the chunk
+			hash, err := hashManifestReader(&ManifestReader{store: s.chunks, manifest: manifest})
+			if err != nil || hash != meta.ContentHash {
+				s.quarantineVersion(p, sdfsFileName, version, "content hash does not match metadata")
+				return nil
+			}
+			if metadataLost {
+				// The journal vouched for it, so put the metadata back.
+				meta.Size = manifest.Size
+				err = s.writeVersionMetadata(sdfsFileName, version, meta)
+				if err != nil {
+					mp3util.NodeLogger.Warnf("Couldn't restore metadata for %v @ %v! Error: %v", sdfsFileName, version, err)
+				}
+			}
+		}
+		manifests = append(manifests, manifest)
+		if _, ok := s.recovered[sdfsFileName]; !ok {
+			s.recovered[sdfsFileName] = make(map[int64]bool)
+		}
+		s.recovered[sdfsFileName][version] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	removeEmptyDirs(storedDir)
+	return manifests, nil
+}
+
+/*
+Version files from before the chunk store are the gzip blob itself, and their metadata is the hash of that blob. Check
+it the old way, then chunk it and replace it with a manifest like any other version.
+*/
+func (s *LocalSDFSStorage) migrateLegacyVersion(p string, sdfsFileName string, version int64, meta VersionMetadata, writer string) (ChunkManifest, error) {
+	info, err := os.Stat(p)
+	if err != nil || info.Size() != meta.FileSize {
+		return ChunkManifest{}, errors.New("size does not match metadata")
+	}
+	hash, err := hashFile(p)
+	if err != nil || hash != meta.ContentHash {
+		return ChunkManifest{}, errors.New("content hash does not match metadata")
+	}
+	mp3util.NodeLogger.Infof("Moving %v @ %v into the chunk store", sdfsFileName, version)
+	manifest, contentHash, err := s.chunkGzipFile(p)
+	if err != nil {
+		return ChunkManifest{}, errors.New(fmt.Sprintf("couldn't chunk legacy version: %v", err))
+	}
+	// Same order as registerManifest: journal, metadata, manifest. Reference counts get rebuilt at the end of recovery
+	// anyway, so there's nothing to release on failure.
+	err = s.journal.Append(JournalEntry{
+		Op:           JOURNAL_REGISTER,
+		SDFSFileName: sdfsFileName,
+		Version:      version,
+		ContentHash:  contentHash,
+		FileSize:     manifest.CompressedSize,
+		Writer:       writer,
+		Reason:       "moved into the chunk store during recovery",
+	})
+	if err == nil {
+		err = s.writeVersionMetadata(sdfsFileName, version, VersionMetadata{ContentHash: contentHash, FileSize: manifest.CompressedSize,
+			Size: manifest.Size, Writer: writer})
+	}
+	if err == nil {
+		err = writeManifest(p, manifest)
+	}
+	if err != nil {
+		return ChunkManifest{}, errors.New(fmt.Sprintf("couldn't migrate legacy version: %v", err))
+	}
+	return manifest, nil
+}
+
+/*
+SHA256 of the uncompressed content a manifest adds up to.
+*/
+func (s *LocalSDFSStorage) hashManifestContent(manifest ChunkManifest) (string, error) {
+	return hashManifestReader(s.newManifestReader(manifest))
+}
+
+/*
+Hashes what reader reads, and closes it.
+*/
+func hashManifestReader(reader *ManifestReader) (string, error) {
+	defer reader.Close()
+	h := sha256.New()
+	if len(reader.manifest.Chunks) > 0 {
+		gzipDecoder, err := gzip.NewReader(reader) // Multistream, so this walks through every chunk.
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, gzipDecoder)
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func isGzipFile(p string) bool {
+	fd, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+	magic := make([]byte, 2)
+	_, err = io.ReadFull(fd, magic)
+	return err == nil && magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+func (s *LocalSDFSStorage) dropOrphanedMetadata() {
+	err := filepath.WalkDir(s.metadataDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		sdfsFileName, _ := filepath.Rel(s.metadataDir, filepath.Dir(p))
+		version, err := ParseVersion(d.Name())
+		if err != nil || !s.recovered[sdfsFileName][version] {
+			mp3util.NodeLogger.Debugf("Dropping orphaned metadata %v", p)
+			os.Remove(p)
+		}
+		return nil
+	})
+	if err != nil {
+		mp3util.NodeLogger.Warnf("Couldn't sweep orphaned metadata! Error: %v", err)
+	}
+	removeEmptyDirs(s.metadataDir)
+}
+
+/*
+Makes the journal agree with s.recovered: versions the journal remembers but that didn't survive are discarded, and
+versions that survived without the journal knowing about them (e.g. the journal itself was lost) are registered.
+*/
+func (s *LocalSDFSStorage) reconcileJournal() error {
+	state := s.journal.State()
+	for sdfsFileName, versions := range state.Files {
+		for version := range versions {
+			if s.recovered[sdfsFileName][version] {
+				continue
+			}
+			mp3util.NodeLogger.Warnf("Journal has %v @ %v but it did not survive recovery.", sdfsFileName, version)
+			err := s.journal.Append(JournalEntry{
+				Op:           JOURNAL_DISCARD,
+				SDFSFileName: sdfsFileName,
+				Version:      version,
+				Reason:       "missing from disk during recovery",
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for sdfsFileName, versions := range s.recovered {
+		for version := range versions {
+			if _, ok := state.Files[sdfsFileName][version]; ok {
+				continue
+			}
+			meta, err := s.ReadVersionMetadata(sdfsFileName, version)
+			if err != nil {
+				return err
+			}
+			err = s.journal.Append(JournalEntry{
+				Op:           JOURNAL_REGISTER,
+				SDFSFileName: sdfsFileName,
+				Version:      version,
+				ContentHash:  meta.ContentHash,
+				FileSize:     meta.FileSize,
+				Reason:       "found on disk during recovery without a journal record",
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+Removes every empty directory strictly below root, deepest first.
+*/
+func removeEmptyDirs(root string) {
+	var dirs []string
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && p != root {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i]) // Fails (harmlessly) if the directory isn't empty.
+	}
+}
+
+func hashFile(p string) (string, error) {
+	fd, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, fd)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,230 @@
+package fsys
+
+import (
+	"amogus/config"
+	"amogus/mp3util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+/*
+Retention: which versions of a file replicas hold on to. A policy is for one file (File) or for every file under the
+directory Prefix, at any depth ("logs" covers logs/a and logs/x/b, but not logs2/a); a file's own policy wins over any
+prefix, and longer prefixes over shorter ones. A version stays if
+any of the policy's rules keeps it, and the newest version always stays. Files no policy covers keep their newest
+config.NUM_VERSIONS, like before there were policies.
+
+What a policy keeps depends on nothing but the versions themselves (and the clock, for KeepFor), so replicas holding the
+same versions under the same policies keep the same ones. That means every node needs the same policies file. A file
+goes by the path its newest version was written under (see VersionAttributes.Path), or its ID, for versions from
+before there was a namespace; for files in the root that's the same thing.
+*/
+
+type RetentionPolicy struct {
+	File       string  `json:",omitempty"` // The path of the file this is for...
+	Prefix     string  `json:",omitempty"` // ...or the directory the files it's for are in. Neither means every file.
+	KeepLast   int     `json:",omitempty"` // The newest this many versions
+	KeepFor    string  `json:",omitempty"` // Versions younger than this, e.g. "72h" (see time.ParseDuration)
+	KeepDaily  int     `json:",omitempty"` // The newest version of each of the last this many days (UTC) that have any
+	KeepWeekly int     `json:",omitempty"` // Same, for ISO weeks
+	Pinned     []int64 `json:",omitempty"` // Versions to keep forever
+	keepFor    time.Duration
+}
+
+var retentionPolicies []RetentionPolicy
+
+/*
+Reads the retention policies in path (a JSON list of RetentionPolicy). No file is fine, everything just keeps
+config.NUM_VERSIONS versions.
+*/
+func LoadRetentionPolicies(path string) error {
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		mp3util.NodeLogger.Debugf("No retention policies file at %v", path)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var policies []RetentionPolicy
+	err = json.Unmarshal(contents, &policies)
+	if err != nil {
+		return err
+	}
+	for i := range policies {
+		p := &policies[i]
+		if p.File != "" && p.Prefix != "" {
+			return errors.New(fmt.Sprintf("retention policy %v has both a File and a Prefix", i))
+		}
+		if p.File != "" {
+			if p.File, err = CleanFilePath(p.File); err != nil {
+				return errors.New(fmt.Sprintf("retention policy %v: %v", i, err))
+			}
+		}
+		if p.Prefix, err = CleanPath(p.Prefix); err != nil {
+			return errors.New(fmt.Sprintf("retention policy %v: %v", i, err))
+		}
+		if p.KeepLast < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 {
+			return errors.New(fmt.Sprintf("retention policy %v keeps a negative number of versions", i))
+		}
+		if p.KeepFor != "" {
+			if p.keepFor, err = time.ParseDuration(p.KeepFor); err != nil {
+				return errors.New(fmt.Sprintf("retention policy %v: %v", i, err))
+			}
+		}
+	}
+	retentionPolicies = policies
+	mp3util.NodeLogger.Infof("Loaded %v retention policies from %v", len(policies), path)
+	return nil
+}
+
+/*
+The policy for the file at path.
+*/
+func RetentionPolicyFor(path string) RetentionPolicy {
+	var best *RetentionPolicy
+	for i := range retentionPolicies {
+		p := &retentionPolicies[i]
+		switch {
+		case p.File != "":
+			if p.File == path {
+				return *p
+			}
+		case underDir(path, p.Prefix) && (best == nil || len(p.Prefix) > len(best.Prefix)):
+			best = p
+		}
+	}
+	if best == nil {
+		return RetentionPolicy{KeepLast: config.NUM_VERSIONS}
+	}
+	return *best
+}
+
+/*
+The versions, out of versions, that the policy keeps as of now.
+*/
+func (p RetentionPolicy) Retain(versions map[int64]bool, now time.Time) map[int64]bool {
+	var newestFirst []int64
+	for version := range versions {
+		newestFirst = append(newestFirst, version)
+	}
+	sort.Slice(newestFirst, func(i, j int) bool {
+		return newestFirst[i] > newestFirst[j]
+	})
+	keep := make(map[int64]bool)
+	if len(newestFirst) > 0 {
+		keep[newestFirst[0]] = true
+	}
+	for i, version := range newestFirst {
+		if i < p.KeepLast || p.keepFor > 0 && now.Sub(VersionTime(version)) < p.keepFor {
+			keep[version] = true
+		}
+	}
+	keepNewestPer := func(n int, period func(time.Time) string) {
+		periods := make(map[string]bool)
+		for _, version := range newestFirst {
+			key := period(VersionTime(version).UTC())
+			if !periods[key] && len(periods) < n {
+				periods[key] = true
+				keep[version] = true
+			}
+		}
+	}
+	keepNewestPer(p.KeepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keepNewestPer(p.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%v-W%v", year, week)
+	})
+	for _, version := range p.Pinned {
+		if versions[version] {
+			keep[version] = true
+		}
+	}
+	return keep
+}
+
+/*
+For a replication offer: of offeredSet, the versions we should fetch, i.e. the ones the policy keeps out of everything
+we'd have if we took all of them; and of alreadyHaveSet, the ones we'd then drop. This is so we don't fetch versions
+we'd throw away right after.
+*/
+func MergedRetainedVersions(alreadyHaveSet map[int64]bool, offeredSet map[int64]bool, policy RetentionPolicy, now time.Time) (fetch map[int64]bool, discard map[int64]bool) {
+	merged := make(map[int64]bool)
+	for version := range alreadyHaveSet {
+		merged[version] = true
+	}
+	for version := range offeredSet {
+		merged[version] = true
+	}
+	keep := policy.Retain(merged, now)
+	fetch = make(map[int64]bool)
+	discard = make(map[int64]bool)
+	for version := range offeredSet {
+		if keep[version] && !alreadyHaveSet[version] {
+			fetch[version] = true
+		}
+	}
+	for version := range alreadyHaveSet {
+		if !keep[version] {
+			discard[version] = true
+		}
+	}
+	return fetch, discard
+}
+
+/*
+The path retention goes by for sdfsFileName: what its newest version that has one was written under, otherwise the ID.
+*/
+func (s *LocalSDFSStorage) RetentionPath(sdfsFileName string, versions map[int64]bool) string {
+	newest := int64(0)
+	path := sdfsFileName
+	for version := range versions {
+		if version < newest {
+			continue
+		}
+		if meta, err := s.ReadVersionMetadata(sdfsFileName, version); err == nil && meta.Path != "" {
+			newest, path = version, meta.Path
+		}
+	}
+	return path
+}
+
+/*
+Drops a single version, e.g. one its retention policy doesn't keep. Unlike a delete, this leaves no tombstone behind.
+*/
+func (s *LocalSDFSStorage) DiscardVersion(sdfsFileName string, version int64, reason string) error {
+	if err := CheckSDFSFileName(sdfsFileName); err != nil {
+		return err
+	}
+	p := filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFileName, VersionName(version))
+	manifest, err := readManifest(p)
+	if err != nil {
+		return err
+	}
+	err = s.journal.Append(JournalEntry{
+		Op:           JOURNAL_DISCARD,
+		SDFSFileName: sdfsFileName,
+		Version:      version,
+		Reason:       reason,
+	})
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't journal discarding %v @ %v! Error: %v", sdfsFileName, version, err)
+		return err
+	}
+	err = os.Remove(p)
+	if err != nil {
+		return err
+	}
+	s.removeVersionMetadata(sdfsFileName, version)
+	s.releaseManifest(manifest)
+	// Only fails (harmlessly) if there are other versions left.
+	os.Remove(filepath.Join(s.RootDir, STOREDFILE_DIR, sdfsFileName))
+	os.Remove(filepath.Join(s.metadataDir, sdfsFileName))
+	return nil
+}
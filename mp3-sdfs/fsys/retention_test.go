@@ -0,0 +1,223 @@
+package fsys
+
+import (
+	"amogus/config"
+	"amogus/mp3util"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	mp3util.ConfigureLogger("test", "error", false)
+	os.Exit(m.Run())
+}
+
+/*
+Uses policies until the test ends.
+*/
+func setRetentionPolicies(t *testing.T, policies []RetentionPolicy) {
+	old := retentionPolicies
+	retentionPolicies = policies
+	t.Cleanup(func() { retentionPolicies = old })
+}
+
+func versionsOf(times ...time.Time) map[int64]bool {
+	versions := make(map[int64]bool)
+	for _, t := range times {
+		versions[VersionAt(t)] = true
+	}
+	return versions
+}
+
+func TestRetain(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC) // A Friday, in ISO week 11
+	at := func(month time.Month, day int, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	ago := func(d time.Duration) time.Time {
+		return now.Add(-d)
+	}
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		versions []time.Time
+		want     []time.Time
+	}{
+		{"no versions", RetentionPolicy{KeepLast: 3}, nil, nil},
+		{"keep last",
+			RetentionPolicy{KeepLast: 2},
+			[]time.Time{ago(time.Hour), ago(2 * time.Hour), ago(3 * time.Hour), ago(4 * time.Hour)},
+			[]time.Time{ago(time.Hour), ago(2 * time.Hour)}},
+		{"keep last more than there are",
+			RetentionPolicy{KeepLast: 10},
+			[]time.Time{ago(time.Hour), ago(2 * time.Hour)},
+			[]time.Time{ago(time.Hour), ago(2 * time.Hour)}},
+		{"newest always stays",
+			RetentionPolicy{},
+			[]time.Time{ago(time.Hour), ago(2 * time.Hour)},
+			[]time.Time{ago(time.Hour)}},
+		{"newest stays even when it's too old",
+			RetentionPolicy{KeepFor: "1h", keepFor: time.Hour},
+			[]time.Time{ago(30 * time.Hour), ago(40 * time.Hour)},
+			[]time.Time{ago(30 * time.Hour)}},
+		{"keep for",
+			RetentionPolicy{KeepFor: "48h", keepFor: 48 * time.Hour},
+			[]time.Time{ago(time.Hour), ago(30 * time.Hour), ago(50 * time.Hour), ago(100 * time.Hour)},
+			[]time.Time{ago(time.Hour), ago(30 * time.Hour)}},
+		{"keep daily",
+			RetentionPolicy{KeepDaily: 2},
+			[]time.Time{at(3, 15, 10), at(3, 15, 8), at(3, 14, 20), at(3, 14, 9), at(3, 12, 10)},
+			[]time.Time{at(3, 15, 10), at(3, 14, 20)}},
+		{"keep daily skips days without versions",
+			RetentionPolicy{KeepDaily: 3},
+			[]time.Time{at(3, 15, 10), at(3, 15, 8), at(3, 14, 20), at(3, 12, 10), at(3, 12, 9), at(3, 1, 0)},
+			[]time.Time{at(3, 15, 10), at(3, 14, 20), at(3, 12, 10)}},
+		{"keep weekly",
+			RetentionPolicy{KeepWeekly: 2},
+			[]time.Time{at(3, 14, 0), at(3, 11, 0), at(3, 10, 23), at(3, 4, 0), at(2, 20, 0)}, // Mar 11 is a Monday
+			[]time.Time{at(3, 14, 0), at(3, 10, 23)}},
+		{"pinned",
+			RetentionPolicy{KeepLast: 1, Pinned: []int64{VersionAt(at(1, 1, 0)), VersionAt(at(1, 2, 0))}},
+			[]time.Time{at(3, 14, 0), at(3, 13, 0), at(1, 1, 0)},
+			[]time.Time{at(3, 14, 0), at(1, 1, 0)}},
+		{"rules add up",
+			RetentionPolicy{KeepLast: 2, KeepDaily: 3, Pinned: []int64{VersionAt(at(1, 1, 0))}},
+			[]time.Time{at(3, 15, 10), at(3, 15, 9), at(3, 15, 8), at(3, 14, 20), at(3, 13, 20), at(3, 12, 20), at(1, 1, 0)},
+			[]time.Time{at(3, 15, 10), at(3, 15, 9), at(3, 14, 20), at(3, 13, 20), at(1, 1, 0)}},
+	}
+	for _, test := range tests {
+		got := test.policy.Retain(versionsOf(test.versions...), now)
+		if want := versionsOf(test.want...); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: kept %v, want %v", test.name, got, want)
+		}
+	}
+}
+
+func TestRetentionPolicyFor(t *testing.T) {
+	setRetentionPolicies(t, []RetentionPolicy{
+		{File: "logs/special/keep.txt", KeepLast: 10},
+		{Prefix: "logs", KeepLast: 2},
+		{Prefix: "logs/special", KeepLast: 1},
+		{File: "other", KeepLast: 7},
+	})
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"logs/a", 2},
+		{"logs/x/b", 2},
+		{"logs/special/b", 1},
+		{"logs/special/deeper/b", 1},
+		{"logs/specialist", 2},           // Not under logs/special
+		{"logs/special/keep.txt", 10},    // Its own policy wins over any prefix
+		{"logs2/a", config.NUM_VERSIONS}, // Not under logs
+		{"logs", config.NUM_VERSIONS},    // A file named like the directory isn't in it
+		{"other", 7},
+		{"other/x", config.NUM_VERSIONS}, // File policies are just for the one file
+		{"id-0123456789abcdef", config.NUM_VERSIONS},
+	}
+	for _, test := range tests {
+		if got := RetentionPolicyFor(test.path); got.KeepLast != test.want {
+			t.Errorf("RetentionPolicyFor(%v) keeps the last %v, want %v", test.path, got.KeepLast, test.want)
+		}
+	}
+
+	setRetentionPolicies(t, []RetentionPolicy{{KeepLast: 3}, {Prefix: "logs", KeepLast: 2}})
+	if got := RetentionPolicyFor("anything").KeepLast; got != 3 {
+		t.Errorf("a policy without File or Prefix should cover everything, but a file outside logs keeps %v", got)
+	}
+	if got := RetentionPolicyFor("logs/a").KeepLast; got != 2 {
+		t.Errorf("a longer prefix should win over the catch-all, but logs/a keeps %v", got)
+	}
+}
+
+func TestLoadRetentionPolicies(t *testing.T) {
+	setRetentionPolicies(t, nil)
+	dir := t.TempDir()
+	load := func(contents string) error {
+		path := filepath.Join(dir, "retention.json")
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadRetentionPolicies(path)
+	}
+
+	err := load(`[{"Prefix": "/logs/", "KeepFor": "72h"}, {"File": "/a/b", "KeepLast": 1}]`)
+	if err != nil {
+		t.Fatalf("couldn't load good policies: %v", err)
+	}
+	if p := RetentionPolicyFor("logs/x"); p.Prefix != "logs" || p.keepFor != 72*time.Hour {
+		t.Errorf("loaded /logs/ as %+v", p)
+	}
+	if RetentionPolicyFor("logs2/x").Prefix == "logs" {
+		t.Errorf("/logs/ covers logs2/x")
+	}
+	if p := RetentionPolicyFor("a/b"); p.File != "a/b" {
+		t.Errorf("loaded /a/b as %+v", p)
+	}
+
+	for _, bad := range []string{
+		`[{"File": "a", "Prefix": "b"}]`,
+		`[{"KeepLast": -1}]`,
+		`[{"KeepFor": "a while"}]`,
+		`[{"Prefix": "a/../b"}]`,
+		`[{"File": "/"}]`,
+		`{}`,
+	} {
+		if load(bad) == nil {
+			t.Errorf("loaded %v", bad)
+		}
+	}
+	if RetentionPolicyFor("a/b").File != "a/b" {
+		t.Errorf("bad policies replaced the ones already loaded")
+	}
+
+	if err := LoadRetentionPolicies(filepath.Join(dir, "nothing-here.json")); err != nil {
+		t.Errorf("no policies file should be fine, got %v", err)
+	}
+}
+
+func TestMergedRetainedVersions(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	v := func(hoursAgo int) int64 {
+		return VersionAt(now.Add(-time.Duration(hoursAgo) * time.Hour))
+	}
+	set := func(versions ...int64) map[int64]bool {
+		s := make(map[int64]bool)
+		for _, version := range versions {
+			s[version] = true
+		}
+		return s
+	}
+	tests := []struct {
+		name                string
+		have, offered       map[int64]bool
+		policy              RetentionPolicy
+		wantFetch, wantDrop map[int64]bool
+	}{
+		{"newer versions push old ones out",
+			set(v(5), v(4)), set(v(2), v(1)), RetentionPolicy{KeepLast: 3},
+			set(v(2), v(1)), set(v(5))},
+		{"older versions aren't worth fetching",
+			set(v(2), v(1)), set(v(5)), RetentionPolicy{KeepLast: 2},
+			set(), set()},
+		{"what we have isn't fetched again",
+			set(v(2)), set(v(2), v(1)), RetentionPolicy{KeepLast: 2},
+			set(v(1)), set()},
+		{"pinned old versions are fetched",
+			set(v(1)), set(v(100)), RetentionPolicy{KeepLast: 1, Pinned: []int64{v(100)}},
+			set(v(100)), set()},
+		{"nothing offered",
+			set(v(3), v(2), v(1)), set(), RetentionPolicy{KeepLast: 2},
+			set(), set(v(3))},
+	}
+	for _, test := range tests {
+		fetch, drop := MergedRetainedVersions(test.have, test.offered, test.policy, now)
+		if !reflect.DeepEqual(fetch, test.wantFetch) || !reflect.DeepEqual(drop, test.wantDrop) {
+			t.Errorf("%v: fetch %v and drop %v, want %v and %v", test.name, fetch, drop, test.wantFetch, test.wantDrop)
+		}
+	}
+}
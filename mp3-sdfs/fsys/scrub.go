@@ -0,0 +1,114 @@
+package fsys
+
+import (
+	"amogus/mp3util"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+Reads from r no faster than bytesPerSecond (on average), so that scrubbing doesn't starve the reads and writes we're
+actually here for. bytesPerSecond <= 0 means no limit.
+*/
+type throttledReader struct {
+	r              io.Reader
+	bytesPerSecond int64
+	start          time.Time
+	n              int64
+}
+
+func newThrottledReader(r io.Reader, bytesPerSecond int64) *throttledReader {
+	return &throttledReader{r: r, bytesPerSecond: bytesPerSecond, start: time.Now()}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	if t.bytesPerSecond > 0 {
+		due := t.start.Add(time.Duration(float64(t.n) / float64(t.bytesPerSecond) * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return n, err
+}
+
+/*
+Re-reads one stored version at no more than bytesPerSecond and checks it, chunk by chunk and then as a whole, against
+what was registered. Returns nil if it's fine, os.ErrNotExist if it went away while we were looking (deleted or garbage
+collected, which is fine too), and ErrContentHashMismatch if it rotted.
+
+Chunks that rotted are evicted from the chunk store on the spot. Until a good copy comes back (see
+RestoreTmpfileToSDFS), every version that shares them is broken too; that's better than dedup'ing fresh data onto a
+bad chunk.
+
+Also returns how many bytes were read, so callers can budget.
+*/
+func (s *LocalSDFSStorage) ScrubVersion(sdfsFileName string, version int64, bytesPerSecond int64) (int64, error) {
+	meta, err := s.ReadVersionMetadata(sdfsFileName, version)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, os.ErrNotExist
+		}
+		return 0, ErrContentHashMismatch // Metadata itself rotted.
+	}
+	manifest, err := s.ReadManifest(sdfsFileName, version)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, os.ErrNotExist
+		}
+		return 0, ErrContentHashMismatch
+	}
+
+	var nbytes int64
+	rotten := false
+	wholeHasher := sha256.New()
+	for _, ref := range manifest.Chunks {
+		fd, err := s.chunks.Open(ref.Hash)
+		if err != nil {
+			if _, stillThere := s.ReadManifest(sdfsFileName, version); stillThere != nil {
+				return nbytes, os.ErrNotExist // Removed out from under us, chunks and all.
+			}
+			mp3util.NodeLogger.Errorf("Scrubber: chunk %v of %v @ %v is missing!", ref.Hash, sdfsFileName, version)
+			rotten = true
+			continue
+		}
+		counter := newThrottledReader(fd, bytesPerSecond)
+		chunkHasher := sha256.New()
+		gzipDecoder, err := gzip.NewReader(counter)
+		if err == nil {
+			_, err = io.Copy(io.MultiWriter(chunkHasher, wholeHasher), gzipDecoder)
+		}
+		fd.Close()
+		nbytes += counter.n
+		if err != nil || hex.EncodeToString(chunkHasher.Sum(nil)) != ref.Hash {
+			mp3util.NodeLogger.Errorf("Scrubber: chunk %v of %v @ %v has rotted! (Error: %v)", ref.Hash, sdfsFileName, version, err)
+			s.evictChunk(ref.Hash, fmt.Sprintf("rotten chunk of %v @ %v", sdfsFileName, version))
+			rotten = true
+		}
+	}
+	if rotten {
+		return nbytes, ErrContentHashMismatch
+	}
+	if actual := hex.EncodeToString(wholeHasher.Sum(nil)); actual != meta.ContentHash || manifest.CompressedSize != meta.FileSize {
+		// Every chunk is fine, so it's the manifest (or the metadata) that's wrong.
+		mp3util.NodeLogger.Errorf("Scrubber: %v @ %v hashes to %v, expected %v!", sdfsFileName, version, actual, meta.ContentHash)
+		return nbytes, ErrContentHashMismatch
+	}
+	return nbytes, nil
+}
+
+/*
+Moves a chunk into quarantineDir without touching its reference count: the versions pointing at it still point at it,
+they just can't be read until somebody puts the chunk back.
+*/
+func (s *LocalSDFSStorage) evictChunk(hash string, reason string) {
+	s.chunks.mtx.Lock()
+	defer s.chunks.mtx.Unlock()
+	s.quarantine(s.chunks.chunkPath(hash), reason)
+}
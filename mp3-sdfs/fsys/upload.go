@@ -0,0 +1,286 @@
+package fsys
+
+import (
+	"amogus/config"
+	"amogus/mp3util"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const UPLOAD_DIR = "uploadDir"
+
+var ErrUnknownUpload = errors.New("no such upload session")
+var ErrWrongUploadOffset = errors.New("chunk does not start where the upload left off")
+
+/*
+An upload in progress. Chunks are appended to uploadDir/<ID>.part, and after every chunk this struct is written out
+to uploadDir/<ID>.json, so Offset only ever covers bytes that were checksummed and fsynced. If the client (or we) drop
+off halfway, the client asks for Offset and carries on from there instead of starting from byte zero.
+
+	-------sdfs/
+			|----uploadDir/
+					|----9f86d081884c7d65.part
+					|----9f86d081884c7d65.json
+			|----tmpfileDir/
+					|----(where the .part ends up, named by its content hash, once the upload is committed)
+*/
+type UploadSession struct {
+	ID           string
+	SDFSFileName string
+	FileSize     int64 // Compressed size of the whole upload
+	Offset       int64 // How much of it we have
+	LastActivity time.Time
+	mtx          sync.Mutex
+}
+
+func (s *LocalSDFSStorage) uploadPath(id string, ext string) string {
+	return filepath.Join(s.uploadDir, id+ext)
+}
+
+/*
+Starts a new upload session of fileSize (compressed) bytes for sdfsFileName, and returns its ID.
+*/
+func (s *LocalSDFSStorage) BeginUpload(sdfsFileName string, fileSize int64) (string, error) {
+	if err := CheckSDFSFileName(sdfsFileName); err != nil {
+		return "", err
+	}
+	idBytes := make([]byte, 8)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return "", err
+	}
+	session := &UploadSession{
+		ID:           hex.EncodeToString(idBytes),
+		SDFSFileName: sdfsFileName,
+		FileSize:     fileSize,
+		LastActivity: time.Now(),
+	}
+	err = writeFileSynced(s.uploadPath(session.ID, ".part"), nil)
+	if err != nil {
+		return "", err
+	}
+	err = s.saveUploadSession(session)
+	if err != nil {
+		os.Remove(s.uploadPath(session.ID, ".part"))
+		return "", err
+	}
+	s.uploadsMtx.Lock()
+	s.uploads[session.ID] = session
+	s.uploadsMtx.Unlock()
+	mp3util.NodeLogger.Debugf("Began upload %v of %v (%v bytes)", session.ID, sdfsFileName, fileSize)
+	return session.ID, nil
+}
+
+func (s *LocalSDFSStorage) saveUploadSession(session *UploadSession) error {
+	j, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	scratch := s.uploadPath(session.ID, ".json.partial")
+	err = writeFileSynced(scratch, j)
+	if err != nil {
+		return err
+	}
+	return os.Rename(scratch, s.uploadPath(session.ID, ".json"))
+}
+
+func (s *LocalSDFSStorage) lookupUpload(id string) (*UploadSession, error) {
+	s.uploadsMtx.Lock()
+	defer s.uploadsMtx.Unlock()
+	session, ok := s.uploads[id]
+	if !ok {
+		return nil, ErrUnknownUpload
+	}
+	return session, nil
+}
+
+/*
+How many bytes of the upload we already have, i.e. where the client should resume from.
+*/
+func (s *LocalSDFSStorage) UploadOffset(id string) (int64, error) {
+	session, err := s.lookupUpload(id)
+	if err != nil {
+		return 0, err
+	}
+	session.mtx.Lock()
+	defer session.mtx.Unlock()
+	return session.Offset, nil
+}
+
+/*
+Reads a chunk of length bytes from source and appends it to the upload, but only if it starts exactly where the upload
+left off and hashes (SHA256) to checksum. Returns the upload's offset afterwards.
+
+The chunk is always read off source in full, even if it gets rejected, so the connection stays in sync. A rejected
+chunk returns ErrWrongUploadOffset or ErrContentHashMismatch, and the offset the client should resend from.
+*/
+func (s *LocalSDFSStorage) WriteUploadChunk(id string, offset int64, length int64, checksum string, source io.Reader) (int64, error) {
+	if length < 0 || length > config.UPLOAD_MAX_CHUNK_SIZE {
+		return 0, errors.New(fmt.Sprintf("refusing a %v byte chunk", length))
+	}
+	buf := make([]byte, length)
+	_, err := io.ReadFull(source, buf)
+	if err != nil {
+		return 0, err
+	}
+	session, err := s.lookupUpload(id)
+	if err != nil {
+		return 0, err
+	}
+	session.mtx.Lock()
+	defer session.mtx.Unlock()
+	if offset != session.Offset || offset+length > session.FileSize {
+		return session.Offset, ErrWrongUploadOffset
+	}
+	sum := sha256.Sum256(buf)
+	if hex.EncodeToString(sum[:]) != checksum {
+		mp3util.NodeLogger.Warnf("Chunk at %v of upload %v doesn't match its checksum", offset, id)
+		return session.Offset, ErrContentHashMismatch
+	}
+	fd, err := os.OpenFile(s.uploadPath(id, ".part"), os.O_WRONLY, 0666)
+	if err != nil {
+		return session.Offset, err
+	}
+	_, err = fd.WriteAt(buf, offset)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return session.Offset, err
+	}
+	session.Offset += length
+	session.LastActivity = time.Now()
+	err = s.saveUploadSession(session)
+	if err != nil {
+		// The bytes are there, we just can't vouch for them after a crash. Make the client send them again.
+		session.Offset -= length
+		return session.Offset, err
+	}
+	return session.Offset, nil
+}
+
+/*
+Finishes an upload: the .part becomes a tmpfile, named by its content hash exactly like DumpBytesToTmpfile would have
+named it, ready for RegisterTmpfileToSDFS. Returns that hash.
+*/
+func (s *LocalSDFSStorage) CommitUpload(id string) (string, error) {
+	session, err := s.lookupUpload(id)
+	if err != nil {
+		return "", err
+	}
+	session.mtx.Lock()
+	defer session.mtx.Unlock()
+	if session.Offset != session.FileSize {
+		return "", errors.New(fmt.Sprintf("upload %v only has %v of %v bytes", id, session.Offset, session.FileSize))
+	}
+	hashName, err := hashFile(s.uploadPath(id, ".part"))
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(s.uploadPath(id, ".part"), filepath.Join(s.tmpfileDir, hashName))
+	if err != nil {
+		return "", err
+	}
+	s.dropUpload(session)
+	mp3util.NodeLogger.Debugf("Committed upload %v of %v as tmpfile %v", id, session.SDFSFileName, hashName)
+	return hashName, nil
+}
+
+/*
+Throws away an upload the client isn't going to finish after all.
+*/
+func (s *LocalSDFSStorage) AbortUpload(id string) error {
+	session, err := s.lookupUpload(id)
+	if err != nil {
+		return err
+	}
+	session.mtx.Lock()
+	defer session.mtx.Unlock()
+	mp3util.NodeLogger.Debugf("Aborting upload %v of %v at %v of %v bytes", id, session.SDFSFileName, session.Offset, session.FileSize)
+	s.dropUpload(session)
+	return nil
+}
+
+func (s *LocalSDFSStorage) dropUpload(session *UploadSession) {
+	os.Remove(s.uploadPath(session.ID, ".part"))
+	os.Remove(s.uploadPath(session.ID, ".json"))
+	s.uploadsMtx.Lock()
+	delete(s.uploads, session.ID)
+	s.uploadsMtx.Unlock()
+}
+
+/*
+Throws away uploads nobody has touched in maxIdle. Their clients gave up (or will have to start over).
+*/
+func (s *LocalSDFSStorage) ExpireUploads(maxIdle time.Duration) {
+	s.uploadsMtx.Lock()
+	var sessions []*UploadSession
+	for _, session := range s.uploads {
+		sessions = append(sessions, session)
+	}
+	s.uploadsMtx.Unlock()
+	for _, session := range sessions {
+		session.mtx.Lock()
+		if time.Since(session.LastActivity) > maxIdle {
+			mp3util.NodeLogger.Infof("Expiring idle upload %v of %v (%v of %v bytes)", session.ID, session.SDFSFileName, session.Offset, session.FileSize)
+			s.dropUpload(session)
+		}
+		session.mtx.Unlock()
+	}
+}
+
+/*
+Picks the upload sessions a previous incarnation left behind back up, so their clients can resume against us. The
+.part is cut back to the last offset we recorded, since anything after it may not have made it to disk intact.
+*/
+func (s *LocalSDFSStorage) recoverUploads() {
+	entries, err := os.ReadDir(s.uploadDir)
+	if err != nil {
+		mp3util.NodeLogger.Warnf("Couldn't read %v! Error: %v", s.uploadDir, err)
+		return
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		var session UploadSession
+		j, err := os.ReadFile(filepath.Join(s.uploadDir, e.Name()))
+		if err == nil {
+			err = json.Unmarshal(j, &session)
+		}
+		if err == nil && time.Since(session.LastActivity) <= config.UPLOAD_SESSION_TTL {
+			err = os.Truncate(s.uploadPath(session.ID, ".part"), session.Offset)
+		} else if err == nil {
+			err = errors.New("expired")
+		}
+		if err != nil {
+			mp3util.NodeLogger.Infof("Dropping upload session %v: %v", e.Name(), err)
+			os.Remove(filepath.Join(s.uploadDir, e.Name()))
+			continue
+		}
+		s.uploads[session.ID] = &session
+	}
+	// Whatever isn't attached to a session we kept is junk.
+	for _, e := range entries {
+		id := strings.SplitN(e.Name(), ".", 2)[0]
+		if _, ok := s.uploads[id]; !ok || isPartial(e.Name()) {
+			os.Remove(filepath.Join(s.uploadDir, e.Name()))
+		}
+	}
+	if len(s.uploads) > 0 {
+		mp3util.NodeLogger.Infof("Recovered %v resumable uploads.", len(s.uploads))
+	}
+}
@@ -0,0 +1,119 @@
+package fsys
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+SDFS versions are hybrid logical clock timestamps, so that they go in the order the master group decided things in, even
+when the masters' clocks don't agree. Each one is an int64 laid out to sort (and read) just like the unix-nano timestamps
+versions used to be: all but the bottom HLC_LOGICAL_BITS bits are wall-clock nanoseconds, rounded down to a tick of
+1<<HLC_LOGICAL_BITS ns (about 65us), and the bottom bits count versions handed out within the same tick, or since the
+clock last caught up with a newer version from elsewhere.
+
+So an old unix-nano version is a perfectly good HLC version too, one that happened at about the time it says, and files
+named after them (sdfs/<file>/<version>) read just like new ones. Nothing on disk needs converting.
+*/
+
+const HLC_LOGICAL_BITS = 16
+const hlcLogicalMask = 1<<HLC_LOGICAL_BITS - 1
+
+/*
+Newer than any version, for when every version counts (as an upper bound, or for deletes).
+*/
+const LATEST_VERSION = int64(math.MaxInt64)
+
+/*
+About when version was handed out. Only good for showing people: two versions from the same tick have the same time,
+and versions handed out while the clock was catching up can be ahead of it.
+*/
+func VersionTime(version int64) time.Time {
+	return time.Unix(0, version&^hlcLogicalMask)
+}
+
+/*
+The newest version that could have been handed out at t, i.e. an upper bound for "as of t".
+*/
+func VersionAt(t time.Time) int64 {
+	return t.UnixNano() | hlcLogicalMask
+}
+
+/*
+The version's logical counter, what tells it apart from others in the same tick.
+*/
+func VersionLogical(version int64) int64 {
+	return version & hlcLogicalMask
+}
+
+/*
+What the version is called in sdfs/ (and everywhere else on disk).
+*/
+func VersionName(version int64) string {
+	return strconv.FormatInt(version, 10)
+}
+
+/*
+The version a file in sdfs/ is named after. Takes old unix-nano names just the same.
+*/
+func ParseVersion(name string) (int64, error) {
+	version, err := strconv.ParseInt(name, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if version <= 0 {
+		return 0, errors.New(fmt.Sprintf("%v isn't a version", name))
+	}
+	return version, nil
+}
+
+/*
+Where HLCs get the time from. Only tests set it, to make the clock jump around.
+*/
+var wallClock = time.Now
+
+type HLC struct {
+	last int64 // The newest version we've handed out or seen
+	mtx  sync.Mutex
+}
+
+/*
+A version newer than every one the clock has handed out or observed: the current tick, or if the clock has already
+been there, the one after the last.
+*/
+func (c *HLC) Now() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	physical := wallClock().UnixNano() &^ hlcLogicalMask
+	if physical > c.last {
+		c.last = physical
+	} else {
+		// Carries over into the physical part if the counter runs out, which just means we're a bit ahead of the clock
+		c.last++
+	}
+	return c.last
+}
+
+/*
+Takes note of a version from somewhere else, so that whatever Now hands out next comes after it.
+*/
+func (c *HLC) Observe(version int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if version > c.last {
+		c.last = version
+	}
+}
+
+/*
+The newest version the clock has handed out or observed.
+*/
+func (c *HLC) Last() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.last
+}
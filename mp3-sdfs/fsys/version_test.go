@@ -0,0 +1,125 @@
+package fsys
+
+import (
+	"testing"
+	"time"
+)
+
+/*
+Makes HLCs read the time from *now until the test ends.
+*/
+func setWallClock(t *testing.T, now *time.Time) {
+	wallClock = func() time.Time { return *now }
+	t.Cleanup(func() { wallClock = time.Now })
+}
+
+/*
+The start of the tick t is in, which is what VersionTime gives back for versions handed out at t.
+*/
+func tickOf(t time.Time) time.Time {
+	return time.Unix(0, t.UnixNano()&^hlcLogicalMask)
+}
+
+func TestHLCMonotonicWhenClockGoesBackwards(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	setWallClock(t, &now)
+	var c HLC
+
+	first := c.Now()
+	if VersionLogical(first) != 0 || !VersionTime(first).Equal(tickOf(now)) {
+		t.Fatalf("first version %v should be the current tick, %v with no counter", first, now)
+	}
+	if second := c.Now(); second != first+1 {
+		t.Errorf("second version in the same tick is %v, want %v", second, first+1)
+	}
+
+	now = now.Add(-time.Hour)
+	last := c.Last()
+	for i := 0; i < 10; i++ {
+		v := c.Now()
+		if v <= last {
+			t.Fatalf("version %v after the clock went back an hour isn't newer than %v", v, last)
+		}
+		last = v
+	}
+	if !VersionTime(last).Equal(tickOf(time.Unix(1700000000, 0))) {
+		t.Errorf("versions while the clock is behind should stay in the newest tick seen, got %v", VersionTime(last))
+	}
+
+	now = time.Unix(1700000001, 0)
+	if v := c.Now(); v <= last || VersionLogical(v) != 0 || !VersionTime(v).Equal(tickOf(now)) {
+		t.Errorf("once the clock passes the last version it should go back to the current tick, got %v (%v)", v, VersionTime(v))
+	}
+}
+
+func TestHLCCounterCarriesIntoPhysical(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	setWallClock(t, &now)
+	var c HLC
+	c.Observe(VersionAt(now))
+	v := c.Now()
+	if VersionLogical(v) != 0 || VersionTime(v).Sub(tickOf(now)) != 1<<HLC_LOGICAL_BITS {
+		t.Errorf("running out of counter should move on to the next tick, got %v (%v, counter %v)", v, VersionTime(v), VersionLogical(v))
+	}
+}
+
+func TestHLCObserve(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	setWallClock(t, &now)
+	var c HLC
+	before := c.Now()
+
+	ahead := now.Add(time.Minute).UnixNano() + 7
+	c.Observe(ahead)
+	if c.Last() != ahead {
+		t.Fatalf("Last() = %v after observing %v", c.Last(), ahead)
+	}
+	if v := c.Now(); v != ahead+1 {
+		t.Errorf("Now() after observing %v from a faster clock = %v, want %v", ahead, v, ahead+1)
+	}
+
+	c.Observe(before)
+	if v := c.Now(); v != ahead+2 {
+		t.Errorf("observing an older version moved the clock: Now() = %v, want %v", v, ahead+2)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    int64
+		wantErr bool
+	}{
+		{"1665000000123456789", 1665000000123456789, false}, // A unix-nano name from before HLC versions
+		{VersionName(1700000000000000000 | 42), 1700000000000000000 | 42, false},
+		{"9223372036854775807", LATEST_VERSION, false},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"abc", 0, true},
+		{"", 0, true},
+		{"12.5", 0, true},
+		{"9223372036854775808", 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseVersion(test.name)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("ParseVersion(%q) = %v, %v; want %v, error %v", test.name, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestLegacyVersionsReadAsHLC(t *testing.T) {
+	legacy := int64(1665000000123456789)
+	if got := VersionTime(legacy); got.Sub(time.Unix(0, legacy)) > 0 || time.Unix(0, legacy).Sub(got) >= 1<<HLC_LOGICAL_BITS {
+		t.Errorf("VersionTime(%v) = %v, should be within a tick of when it was written", legacy, got)
+	}
+	at := time.Unix(0, legacy)
+	if VersionAt(at) < legacy {
+		t.Errorf("VersionAt(%v) = %v is older than the version written then, %v", at, VersionAt(at), legacy)
+	}
+	var c HLC
+	c.Observe(legacy)
+	if v := c.Now(); v <= legacy {
+		t.Errorf("a version handed out after observing legacy %v is %v", legacy, v)
+	}
+}
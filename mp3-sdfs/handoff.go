@@ -0,0 +1,50 @@
+package amogus
+
+import (
+	"amogus/config"
+	"amogus/fsys"
+	"amogus/mp3util"
+	"amogus/schema"
+	"os"
+	"time"
+)
+
+/*
+Hands the versions we've been holding hints for (see fsys/hints.go) to their owners, if they're in the membership list.
+Nodes come back with a new member ID after a reboot, so owners are matched by address.
+*/
+func (r *ReplicaService) DeliverHints() error {
+	hints := r.sdfs.Hints()
+	if len(hints) == 0 {
+		return nil
+	}
+	members := make(map[string]ReplicaMetadata)
+	for _, m := range schema.Membership().Members {
+		members[m.Address] = replicaMetadataOf(m)
+	}
+
+	for _, h := range hints {
+		if time.Since(h.Created) > config.HINT_TTL {
+			mp3util.NodeLogger.Warnf("Giving up on handing %v @ %v to %v (%v), it's been gone too long", h.SDFSFileName, h.Version, h.Owner.MemberId, h.Owner.Address)
+			r.sdfs.DropHint(h.ID)
+			continue
+		}
+		if _, err := r.sdfs.ReadVersionMetadata(h.SDFSFileName, h.Version); os.IsNotExist(err) {
+			mp3util.NodeLogger.Infof("Dropping hint for %v @ %v, we don't have that version anymore", h.SDFSFileName, h.Version)
+			r.sdfs.DropHint(h.ID)
+			continue
+		}
+		owner, ok := members[h.Owner.Address]
+		if !ok {
+			continue
+		}
+		err := r.offerFileSetToReplica(fsys.SDFSFileVersionSet{h.SDFSFileName: {h.Version: true}}, owner)
+		if err != nil {
+			mp3util.NodeLogger.Debugf("Owner %v of hinted %v @ %v is back, but we couldn't hand it over yet: %v", owner.MemberId, h.SDFSFileName, h.Version, err)
+			continue
+		}
+		mp3util.NodeLogger.Infof("Handed %v @ %v off to %v", h.SDFSFileName, h.Version, owner.MemberId)
+		r.sdfs.DropHint(h.ID)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"amogus/api"
+	"amogus/fsys"
 	"amogus/mp3util"
 	"amogus/schema"
 	"bufio"
@@ -9,6 +10,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -24,12 +26,22 @@ import (
  *		join => GET mp2/join
  *		leave => GET mp2/leave
  *		quit => GET mp2/quit
- *		putfile <localfilename> <sdfsfilename>
- *		getfile <sdfsfilename> <localfilename> => POST mp3/get {sdfsfilename: <sdfsfilename, localfilename: <localfilename}
+ *		putfile <localfilename> <sdfsfilename> [key=value ...]
+ *		getfile <sdfsfilename> <localfilename> [--version <version> | --at <timestamp>] => POST mp3/get {sdfsfilename: <sdfsfilename, localfilename: <localfilename}
  *		deletefile <sdfsfilename>
  *		getversions <sdfsfilename> <num-versions> <localfilename>
- * 		ls <sdfsfilename>
+ * 		ls [-R] <sdfsfilename or directory>
  *		store
+ *		history <sdfsfilename>
+ *		getrange <sdfsfilename> <offset> <length> <localfilename>
+ *		ownership
+ *		mkdir <directory>
+ *		rmdir <directory>
+ *		mv <from> <to>
+ *		lsall [--prefix <prefix>] [--glob <pattern>] [--limit <n>] [--after <path>] [--json]
+ *		stat <sdfsfilename> [version]
+ *		setattr <sdfsfilename> [--version <version>] key=value ... (key= removes it)
+ *		diffversions <sdfsfilename> <version> <version>
  */
 func main() {
 	fmt.Fprintf(os.Stderr, "MP3 CLI PID: %v\n", os.Getpid())
@@ -46,12 +58,22 @@ func main() {
 			"join\n",
 			"leave\n",
 			"quit\n",
-			"putfile <localfilename> <sdfsfilename>\n",
-			"getfile <sdfsfilename> <localfilename>\n",
+			"putfile <localfilename> <sdfsfilename> [key=value ...]\n",
+			"getfile <sdfsfilename> <localfilename> [--version <version> | --at <RFC 3339 or unix-nano timestamp>]\n",
 			"deletefile <sdfsfilename>\n",
 			"getversions <sdfsfilename> <num-versions> <localfilename>\n",
-			"ls <sdfsfilename>\n",
+			"ls [-R] <sdfsfilename or directory>\n",
 			"store\n",
+			"history <sdfsfilename>\n",
+			"getrange <sdfsfilename> <offset> <length> <localfilename>\n",
+			"ownership\n",
+			"mkdir <directory>\n",
+			"rmdir <directory>\n",
+			"mv <from> <to>\n",
+			"lsall [--prefix <prefix>] [--glob <pattern>] [--limit <n>] [--after <path>] [--json]\n",
+			"stat <sdfsfilename> [version]\n",
+			"setattr <sdfsfilename> [--version <version>] key=value ... (key= removes it)\n",
+			"diffversions <sdfsfilename> <version> <version>\n",
 			"help")
 	}
 	help()
@@ -166,15 +188,27 @@ func main() {
 			fmt.Printf("Command %v executed.\n", opcode)
 
 		case "getfile":
-			if len(cmd) != 3 {
-				fmt.Println("Usage: getfile <sdfsfilename> <localfilename>")
-				continue
+			usage := len(cmd) != 3 && len(cmd) != 5
+			args := schema.CliArgs{}
+			if len(cmd) == 5 {
+				var err error
+				switch cmd[3] {
+				case "--version":
+					args.Version, err = fsys.ParseVersion(cmd[4])
+				case "--at":
+					var at time.Time
+					at, err = parseTimestamp(cmd[4])
+					args.UpperVersionBound = fsys.VersionAt(at)
+				default:
+					usage = true
+				}
+				usage = usage || err != nil
 			}
-
-			args := schema.CliArgs{
-				SdfsFileName:  cmd[1],
-				LocalFileName: cmd[2],
+			if usage {
+				fmt.Println("Usage: getfile <sdfsfilename> <localfilename> [--version <version> | --at <RFC 3339 or unix-nano timestamp>]")
+				continue
 			}
+			args.SdfsFileName, args.LocalFileName = cmd[1], cmd[2]
 
 			_, err := api.IssueMP3Command(opcode, args)
 			if err != nil {
@@ -184,14 +218,16 @@ func main() {
 			fmt.Printf("Command %v executed.\n", opcode)
 
 		case "putfile":
-			if len(cmd) != 3 {
-				fmt.Println("Usage: putfile <localfilename> <sdfsfilename>")
+			attrs, ok := parseAttrs(cmd[min(3, len(cmd)):])
+			if len(cmd) < 3 || !ok {
+				fmt.Println("Usage: putfile <localfilename> <sdfsfilename> [key=value ...]")
 				continue
 			}
 
 			args := schema.CliArgs{
 				LocalFileName: cmd[1],
 				SdfsFileName:  cmd[2],
+				Attrs:         attrs,
 			}
 			_, err := api.IssueMP3Command(opcode, args)
 			if err != nil {
@@ -217,12 +253,14 @@ func main() {
 			fmt.Printf("Command %v executed.\n", opcode)
 
 		case "ls":
-			if len(cmd) != 2 {
-				fmt.Println("Usage: ls <sdfsfilename>")
+			recursive := len(cmd) == 3 && cmd[1] == "-R"
+			if len(cmd) != 2 && !recursive {
+				fmt.Println("Usage: ls [-R] <sdfsfilename or directory>")
 				continue
 			}
 			args := schema.CliArgs{
-				SdfsFileName: cmd[1],
+				SdfsFileName: cmd[len(cmd)-1],
+				Recursive:    recursive,
 			}
 			_, err := api.IssueMP3Command(opcode, args)
 			if err != nil {
@@ -243,6 +281,200 @@ func main() {
 			}
 			fmt.Printf("Command %v executed.\n", opcode)
 
+		case "ownership":
+			if len(cmd) != 1 {
+				fmt.Println("Usage: ownership")
+				continue
+			}
+			_, err := api.IssueMP3Command(opcode, schema.CliArgs{})
+			if err != nil {
+				fmt.Printf("MP3 failed command %v with error: %v\n", opcode, err)
+				continue
+			}
+			fmt.Printf("Command %v executed.\n", opcode)
+
+		case "history":
+			if len(cmd) != 2 {
+				fmt.Println("Usage: history <sdfsfilename>")
+				continue
+			}
+			args := schema.CliArgs{
+				SdfsFileName: cmd[1],
+			}
+			_, err := api.IssueMP3Command(opcode, args)
+			if err != nil {
+				fmt.Printf("MP3 failed command %v with error: %v\n", opcode, err)
+				continue
+			}
+			fmt.Printf("Command %v executed.\n", opcode)
+
+		case "getrange":
+			if len(cmd) != 5 {
+				fmt.Println("Usage: getrange <sdfsfilename> <offset> <length> <localfilename>")
+				continue
+			}
+			offset, err := strconv.ParseInt(cmd[2], 10, 64)
+			if err != nil || offset < 0 {
+				fmt.Println("Couldn't parse <offset>!")
+				continue
+			}
+			length, err := strconv.ParseInt(cmd[3], 10, 64)
+			if err != nil || length <= 0 {
+				fmt.Println("Couldn't parse <length>!")
+				continue
+			}
+			args := schema.CliArgs{
+				SdfsFileName:  cmd[1],
+				Offset:        offset,
+				Length:        length,
+				LocalFileName: cmd[4],
+			}
+			_, err = api.IssueMP3Command(opcode, args)
+			if err != nil {
+				fmt.Printf("MP3 failed command %v with error: %v\n", opcode, err)
+				continue
+			}
+			fmt.Printf("Command %v executed.\n", opcode)
+
+		case "mkdir", "rmdir":
+			if len(cmd) != 2 {
+				fmt.Printf("Usage: %v <directory>\n", opcode)
+				continue
+			}
+			args := schema.CliArgs{
+				SdfsFileName: cmd[1],
+			}
+			_, err := api.IssueMP3Command(opcode, args)
+			if err != nil {
+				fmt.Printf("MP3 failed command %v with error: %v\n", opcode, err)
+				continue
+			}
+			fmt.Printf("Command %v executed.\n", opcode)
+
+		case "mv":
+			if len(cmd) != 3 {
+				fmt.Println("Usage: mv <from> <to>")
+				continue
+			}
+			args := schema.CliArgs{
+				SdfsFileName: cmd[1],
+				DestFileName: cmd[2],
+			}
+			_, err := api.IssueMP3Command(opcode, args)
+			if err != nil {
+				fmt.Printf("MP3 failed command %v with error: %v\n", opcode, err)
+				continue
+			}
+			fmt.Printf("Command %v executed.\n", opcode)
+
+		case "lsall":
+			args := schema.CliArgs{}
+			usage := false
+			for i := 1; i < len(cmd) && !usage; i++ {
+				if cmd[i] == "--json" {
+					args.JSON = true
+					continue
+				}
+				if i+1 == len(cmd) {
+					usage = true
+					break
+				}
+				switch cmd[i] {
+				case "--prefix":
+					args.Prefix = cmd[i+1]
+				case "--glob":
+					args.Glob = cmd[i+1]
+				case "--after":
+					args.After = cmd[i+1]
+				case "--limit":
+					limit, err := strconv.Atoi(cmd[i+1])
+					usage = err != nil || limit <= 0
+					args.Limit = limit
+				default:
+					usage = true
+				}
+				i++
+			}
+			if usage {
+				fmt.Println("Usage: lsall [--prefix <prefix>] [--glob <pattern>] [--limit <n>] [--after <path>] [--json]")
+				continue
+			}
+			_, err := api.IssueMP3Command(opcode, args)
+			if err != nil {
+				fmt.Printf("MP3 failed command %v with error: %v\n", opcode, err)
+				continue
+			}
+			fmt.Printf("Command %v executed.\n", opcode)
+
+		case "stat":
+			if len(cmd) != 2 && len(cmd) != 3 {
+				fmt.Println("Usage: stat <sdfsfilename> [version]")
+				continue
+			}
+			args := schema.CliArgs{SdfsFileName: cmd[1]}
+			if len(cmd) == 3 {
+				version, err := fsys.ParseVersion(cmd[2])
+				if err != nil {
+					fmt.Println("Usage: stat <sdfsfilename> [version]")
+					continue
+				}
+				args.Version = version
+			}
+			_, err := api.IssueMP3Command(opcode, args)
+			if err != nil {
+				fmt.Printf("MP3 failed command %v with error: %v\n", opcode, err)
+				continue
+			}
+			fmt.Printf("Command %v executed.\n", opcode)
+
+		case "setattr":
+			args := schema.CliArgs{}
+			rest := cmd[min(2, len(cmd)):]
+			usage := len(cmd) < 3
+			if !usage && rest[0] == "--version" {
+				version, err := fsys.ParseVersion(rest[min(1, len(rest)-1)])
+				usage = err != nil || len(rest) < 3
+				args.Version = version
+				rest = rest[min(2, len(rest)):]
+			}
+			attrs, ok := parseAttrs(rest)
+			if usage || !ok || len(attrs) == 0 {
+				fmt.Println("Usage: setattr <sdfsfilename> [--version <version>] key=value ... (key= removes it)")
+				continue
+			}
+			args.SdfsFileName, args.Attrs = cmd[1], attrs
+			_, err := api.IssueMP3Command(opcode, args)
+			if err != nil {
+				fmt.Printf("MP3 failed command %v with error: %v\n", opcode, err)
+				continue
+			}
+			fmt.Printf("Command %v executed.\n", opcode)
+
+		case "diffversions":
+			if len(cmd) != 4 {
+				fmt.Println("Usage: diffversions <sdfsfilename> <version> <version>")
+				continue
+			}
+			from, err := fsys.ParseVersion(cmd[2])
+			if err != nil {
+				fmt.Println("Usage: diffversions <sdfsfilename> <version> <version>")
+				continue
+			}
+			to, err := fsys.ParseVersion(cmd[3])
+			if err != nil {
+				fmt.Println("Usage: diffversions <sdfsfilename> <version> <version>")
+				continue
+			}
+			args := schema.CliArgs{SdfsFileName: cmd[1], Version: from, OtherVersion: to}
+			resp, err := api.IssueMP3Command(opcode, args)
+			if err != nil {
+				fmt.Printf("MP3 failed command %v with error: %v\n", opcode, err)
+				continue
+			}
+			io.Copy(os.Stdout, resp.Body)
+			resp.Body.Close()
+			fmt.Printf("Command %v executed.\n", opcode)
+
 		case "quit":
 			fmt.Println("ok bye")
 			os.Exit(0)
@@ -257,3 +489,37 @@ func main() {
 		}
 	}
 }
+
+/*
+key=value arguments into a map. ok is false if one of them isn't key=value.
+*/
+func parseAttrs(fields []string) (attrs map[string]string, ok bool) {
+	for _, field := range fields {
+		eq := strings.Index(field, "=")
+		if eq <= 0 {
+			return nil, false
+		}
+		if attrs == nil {
+			attrs = make(map[string]string)
+		}
+		attrs[field[:eq]] = field[eq+1:]
+	}
+	return attrs, true
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+/*
+A timestamp on the command line: RFC 3339, or nanoseconds since the epoch, like versions.
+*/
+func parseTimestamp(s string) (time.Time, error) {
+	if nanos, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, nanos), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
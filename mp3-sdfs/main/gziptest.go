@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"time"
 )
 
 func GetGzipFileSize(source io.Reader) (int64, error) {
@@ -24,11 +23,11 @@ func GetGzipFileSize(source io.Reader) (int64, error) {
 		defer compressWrite.Close()
 		_, err := io.Copy(gzipConverter, source) // Writing to something that is ultimately an io pipe Write BLOCKS until a reader tries to read from it.
 		if err != nil {
-			fmt.Println("Couldn't read from source! Error: %v", err)
+			fmt.Printf("Couldn't read from source! Error: %v\n", err)
 		}
 		gzipConverter.Close()
 		if err != nil {
-			fmt.Println("Couldn't close gzip converter! Error: %v", err)
+			fmt.Printf("Couldn't close gzip converter! Error: %v\n", err)
 		}
 	}()
 
@@ -89,15 +88,15 @@ func main() {
 
 	fi, err := os.Stat(GZIPFILE)
 	if err != nil {
-		fmt.Println("Couldn't stat file %v! Error: %v", fi, err)
+		fmt.Printf("Couldn't stat file %v! Error: %v\n", fi, err)
 		return
 	}
 
 	fmt.Println("Filesize of gzip file is: ", fi.Size())
 	localFile.Seek(0, 0)
 	size, err := GetGzipFileSize(localFile)
-	fmt.Println("Size: %v", size)
-	fmt.Println("Err: %v", err)
+	fmt.Printf("Size: %v\n", size)
+	fmt.Printf("Err: %v\n", err)
 
 	nbytes, err := fsys.RecvFileFromGzip(
 		&io.LimitedReader{
@@ -129,7 +128,8 @@ func main() {
 	fmt.Fprintf(os.Stderr, "The content hash is: %v\n", contentHash)
 	// Above here known works
 
-	err = storage.RegisterTmpfileToSDFS(contentHash, time.Now(), "amogus")
+	var clock fsys.HLC
+	err = storage.RegisterTmpfileToSDFS(contentHash, clock.Now(), "amogus", fsys.VersionOrigin{Writer: "gziptest", Reason: "gziptest"}, fsys.VersionAttributes{})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error registering to tmpfile!  %v", err)
 		return
@@ -152,7 +152,7 @@ func main() {
 	//	fmt.Fprintf(os.Stderr, "Error registering to tmpfile!  %v", err)
 	//	return
 	//}
-	vers, err := storage.AcquireFileHandles(5, "amogus", time.Now())
+	vers, err := storage.AcquireFileHandles(5, "amogus", fsys.LATEST_VERSION)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error acquire file handles! %v", err)
 		return
@@ -170,7 +170,7 @@ func main() {
 	fmt.Fprintf(os.Stderr, "The result is: ")
 	fmt.Fprintf(os.Stderr, "%v", a)
 
-	_, err = storage.RemoveSDFSFile("amogus", time.Now())
+	_, err = storage.RemoveSDFSFile("amogus", clock.Now())
 	if err != nil {
 		mp3util.NodeLogger.Debugf("Bruh: %v", err)
 	}
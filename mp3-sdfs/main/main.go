@@ -3,11 +3,15 @@ package main
 import (
 	"amogus"
 	"amogus/api"
+	"amogus/config"
+	"amogus/fsys"
 	"amogus/mp3util"
+	"amogus/schema"
 	"flag"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -48,19 +52,36 @@ func main() {
 
 	logLevelFlag := flag.String("loglevel", "error", fmt.Sprintf("Logger flags: %s", logrus.AllLevels))
 	dumpToFileFlag := flag.Bool("d", false, "Specify whether you would like to dump to a file or not.")
+	recoverFlag := flag.Bool("recover", config.RECOVER_SDFS_STORAGE, "Keep and verify the files already in sdfs/ instead of wiping them on startup.")
+	domainsFlag := flag.String("domains", config.FAILURE_DOMAINS_FILE, "JSON file mapping member addresses to their zone/rack.")
+	retentionFlag := flag.String("retention", config.RETENTION_POLICIES_FILE, "JSON file with the version retention policies. Must be the same on every node.")
+	masterGroupFlag := flag.String("mastergroup", "", "Comma-separated addresses of the members that elect the master and keep its log. Required, and the same on every node.")
 	flag.Parse()
+	config.RECOVER_SDFS_STORAGE = *recoverFlag
+	if *masterGroupFlag != "" {
+		config.MASTER_GROUP = strings.Split(*masterGroupFlag, ",")
+	}
 
 	hostname, _ := os.Hostname()
 	mp3util.ConfigureLogger(hostname, *logLevelFlag, *dumpToFileFlag)
 
-	master := amogus.NewMasterGRPCService()
+	err := schema.LoadFailureDomains(*domainsFlag)
+	if err != nil {
+		mp3util.NodeLogger.Fatal("Failed to load failure domains: ", err)
+	}
+	err = fsys.LoadRetentionPolicies(*retentionFlag)
+	if err != nil {
+		mp3util.NodeLogger.Fatal("Failed to load retention policies: ", err)
+	}
+
 	replica := amogus.NewReplicaGRPCService()
+	master := amogus.NewMasterGRPCService(replica.Election())
 	//replica := amogus.Replica() 		// Replica not real rn
 
 	done := make(chan bool)
 	go api.RunAPI(master, replica, done)
 	replica.Run()
-	err := sendJoin()
+	err = sendJoin()
 	if err != nil {
 		mp3util.NodeLogger.Fatal("Failed to send join to mp2: ", err)
 	}
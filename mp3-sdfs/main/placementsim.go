@@ -0,0 +1,73 @@
+package main
+
+import (
+	"amogus/config"
+	"amogus/mp3util"
+	"amogus/schema"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+/**
+ * placementsim
+ *	Simulation harness for the placement strategies in schema/placement.go. Places
+ *	a bunch of made-up files over a made-up membership, changes the membership, and
+ *	reports how much data had to move (versus the least any strategy could get away
+ *	with) and how evenly it's spread afterwards. Nothing touches the network.
+ *
+ *	go run ./main/placementsim.go -members 10 -files 20000 -strategies chord,rendezvous,jump
+ */
+func main() {
+	numMembers := flag.Int("members", 10, "Members in the cluster before anything happens.")
+	numFiles := flag.Int("files", 20000, "Files to place.")
+	strategies := flag.String("strategies", "chord,rendezvous,jump", "Comma-separated placement strategies to compare.")
+	seed := flag.Int64("seed", 425, "Seed for picking who leaves.")
+	flag.Parse()
+	mp3util.ConfigureLogger("placementsim", "error", false)
+
+	members := make([]schema.Member, *numMembers)
+	for i := range members {
+		members[i] = newMember(i)
+	}
+	rng := rand.New(rand.NewSource(*seed))
+	leaver := rng.Intn(len(members))
+
+	scenarios := []struct {
+		name  string
+		after []schema.Member
+	}{
+		{"one joins", append(append([]schema.Member(nil), members...), newMember(len(members)))},
+		{"one leaves", append(append([]schema.Member(nil), members[:leaver]...), members[leaver+1:]...)},
+		{"last leaves", members[:len(members)-1]},
+		{"three join", append(append([]schema.Member(nil), members...), newMember(len(members)), newMember(len(members)+1), newMember(len(members)+2))},
+	}
+
+	fmt.Printf("%v members, %v files, %v replicas each\n\n", *numMembers, *numFiles, config.NUM_REPLICAS)
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 3, ' ', 0)
+	fmt.Fprintln(w, "Strategy\tScenario\tMoved\tIdeal\tMoved/Ideal\tMax load\tMin load\t")
+	fmt.Fprintln(w, "===========\t===========\t===========\t===========\t===========\t===========\t===========\t")
+	for _, name := range strings.Split(*strategies, ",") {
+		strategy, err := schema.NewPlacementStrategy(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, sc := range scenarios {
+			r := schema.SimulateMovement(strategy, members, sc.after, *numFiles)
+			fmt.Fprintf(w, "%v\t%v\t%.2f%%\t%.2f%%\t%.2f\t%.2f\t%.2f\t\n", r.Strategy, sc.name, 100*r.MovedFraction(),
+				100*float64(r.Ideal)/float64(r.Copies), float64(r.Moved)/float64(r.Ideal), r.MaxLoad, r.MinLoad)
+		}
+	}
+	w.Flush()
+}
+
+func newMember(i int) schema.Member {
+	return schema.Member{
+		Member_Id: fmt.Sprintf("%v-%v", i, 1667000000+i),
+		Address:   fmt.Sprintf("10.0.%v.%v", i/256, i%256),
+	}
+}
@@ -7,9 +7,21 @@ import (
 	"amogus/proto"
 	"amogus/schema"
 	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"math/rand"
 	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -18,66 +30,132 @@ type MasterGRPCService struct {
 	proto.UnimplementedMasterServer
 	server   *grpc.Server
 	isActive bool
+	election *Election
 	mtx      sync.Mutex
 }
 
+/*
+gRPC metadata key for the term the client thinks the master is in.
+*/
+const TERM_METADATA_KEY = "mp3-term"
+
+var errStaleTerm = errors.New("a replica has seen a newer master term than ours")
+
 /**
  * NewMasterGRPCService
- *	Creates a new master object. Checks if this node is the current
- *	master - if so, initializes master object with the "isActive" flags
- *	set to true.
+ *	Creates a new master object. It sits idle until the election (see
+ *	election.go) makes this node master, and goes back to idle when it
+ *	stops being master.
+ *	@param election - this node's side of the master election
  *	@return m - master object
  */
-func NewMasterGRPCService() *MasterGRPCService {
-	m := &MasterGRPCService{}
+func NewMasterGRPCService(election *Election) *MasterGRPCService {
+	m := &MasterGRPCService{election: election}
+	election.OnLeadershipChange(m.leadershipChanged)
 	return m
 }
 
 /**
  * MembershipListChanged
- *	Called when membership list changes. Checks if this node is the new
- *	master node. If so, runs the GRPC server. If not, stops the GRPC server.
- * 	Note: Needs to grab two locks; one for the master, one for the membership list
+ *	Called when membership list changes. Lets the election know, in case the
+ *	master is gone and it shouldn't wait for the heartbeat timeout.
  */
 func (m *MasterGRPCService) MembershipListChanged() error {
+	m.election.MembershipChanged()
+	m.leadershipChanged()
+	return nil
+}
+
+/**
+ * leadershipChanged
+ *	Called when this node wins or loses the master election. If it's master now,
+ *	runs the GRPC server. If not, stops the GRPC server.
+ * 	Note: grabs the master's lock, so not to be called with the election's
+ */
+func (m *MasterGRPCService) leadershipChanged() {
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
-
-	memList := &schema.MemList
-	memList.Mtx.Lock()
-	defer memList.Mtx.Unlock()
-	currMaster, err := memList.CurrMasterNode()
-	if err != nil {
-		m.stop()
-		m.isActive = false
-		return err
-	}
-
-	/* If this node is a new master, run the GRPC server.
-	 * If this node is no longer a master, stop the GRPC server.
-	 * In all other cases, no action taken
-	 */
-	selfNode := &memList.SelfNode
-	if (!m.isActive) && (currMaster.Member_Id == selfNode.Member_Id) {
-		mp3util.NodeLogger.Info("Node elected as master. Running GRPC server.")
+	term, leader := m.election.LeaderTerm()
+	if !m.isActive && leader {
+		mp3util.NodeLogger.Infof("Node elected as master of term %v. Running GRPC server.", term)
 		m.run()
 		m.isActive = true
-		return nil
-	}
-	if (m.isActive) && (currMaster.Member_Id != selfNode.Member_Id) {
-		mp3util.NodeLogger.Info("Node no longer master. Stopping GRPC server.")
+	} else if m.isActive && !leader {
+		mp3util.NodeLogger.Infof("Node no longer master (now term %v). Stopping GRPC server.", term)
 		m.stop()
 		m.isActive = false
-		return nil
 	}
+}
 
+/*
+Checks, before every RPC, that we're still master, and takes note of the term the client sent (if the client's newer
+than us, we've been replaced and didn't know it yet).
+*/
+func (m *MasterGRPCService) checkTerm(ctx context.Context) error {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, v := range md.Get(TERM_METADATA_KEY) {
+			if term, err := strconv.ParseUint(v, 10, 64); err == nil {
+				m.election.ObserveTerm(term)
+			}
+		}
+	}
+	term, leader := m.election.LeaderTerm()
+	if !leader {
+		return status.Errorf(codes.Unavailable, "not the master (as of term %v)", term)
+	}
 	return nil
 }
 
+func (m *MasterGRPCService) unaryTermInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := m.checkTerm(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (m *MasterGRPCService) streamTermInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := m.checkTerm(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+/*
+The term to stamp on requests to replicas, or an error for the client if we aren't master anymore.
+*/
+func (m *MasterGRPCService) currentTerm() (uint64, error) {
+	term, leader := m.election.LeaderTerm()
+	if !leader {
+		return 0, status.Errorf(codes.Unavailable, "no longer the master (now term %v)", term)
+	}
+	return term, nil
+}
+
+/*
+UnicastToReplica, for requests from the master: stamps them with term, and if the replica turns it away because it's
+seen a newer one, that's the end of our time as master.
+*/
+func (m *MasterGRPCService) unicastAsMaster(req *fsys.TCPChannelRequest, term uint64, r ReplicaMetadata) (*fsys.TCPChannelResponse, error) {
+	req.Term = term
+	resp, err := unicastToReplicaAnyCode(req, r, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.ResponseCode {
+	case fsys.OK:
+		return resp, nil
+	case fsys.STALE_TERM:
+		mp3util.NodeLogger.Warnf("Replica %v is in term %v, we're master of term %v", r.MemberId, resp.Term, term)
+		m.election.ObserveTerm(resp.Term)
+		return resp, errStaleTerm
+	}
+	return nil, errors.New(fmt.Sprintf("Received error code: %v", resp.ResponseCode))
+}
+
 /**
  * selectQuorum
- *	Returns quorum from given set of replicas.
- *	W, R = 4. Number of replicas = 6.
+ *	Returns quorum from given set of replicas, spread over as many failure domains
+ *	as possible. W, R = 4. Number of replicas = 6.
  *	@param replicaList - list of replicas
  *	@return quorum - quorum of replicas
  */
@@ -86,29 +164,103 @@ func selectQuorum(replicaList []*proto.ReplicaInfo) []*proto.ReplicaInfo {
 	rand.Shuffle(len(replicaList), func(i, j int) {
 		replicaList[i], replicaList[j] = replicaList[j], replicaList[i]
 	})
-	end := config.QUORUM_SIZE
-	if len(replicaList) < end {
-		end = len(replicaList)
-	}
-	quorum := replicaList[:end]
+	// Shuffled first, so which member of a failure domain gets picked is still random.
+	quorum := schema.SpreadAcrossDomains(replicaList, config.QUORUM_SIZE)
 	mp3util.NodeLogger.Debug("Selected quorum: ", quorum)
 	return quorum
 }
 
 /**
  * partitioner
- *	Determines which replicas contain a given sdfsfile. First, hashes the sdfsfilename
- *	to determine its file id in the ring. Then, hashes the IDs of each member in
- *	the membership list, and determines which six replicas contain the file.
- *	This partitioner works exactly the same as Chord's. The first ID that overshoots the
- *	file id is the first node that contains the sdfsfile. The next succeeding 5, in sorted
- *	order of hashed id, are replicas. Returns a quorum of the six replicas (W, R = 4, for 6 replicas).
+ *	Determines which replicas contain a given sdfsfile, using the placement strategy
+ *	picked by config.PLACEMENT_STRATEGY (chord ring, rendezvous or jump hashing, see
+ *	schema/placement.go). Replicas run the same strategy to figure out what they own,
+ *	so it has to be the same everywhere. Files are placed by their ID, not their path,
+ *	so renaming them doesn't move them.
  *
- *	@param f - file info struct, containing sdfsfilename
- *	@return replicaList - quorum of replicas that are responsible for a given sdfsfile.
+ *	@param f - file info struct, containing sdfsfilename (and fileid, if the client has it)
+ *	@return replicaList - replicas that are responsible for a given sdfsfile, most preferred first.
  */
 func (m *MasterGRPCService) partitioner(f *proto.FileInfo) ([]*proto.ReplicaInfo, error) {
-	return schema.RunPartitioner(f)
+	term, err := m.currentTerm()
+	if err != nil {
+		return nil, err
+	}
+	_, id, err := m.lookupFile(term, f, false)
+	if err != nil {
+		return nil, err
+	}
+	return schema.Partition(schema.ConfiguredPlacement(), &proto.FileInfo{Sdfsname: id}, schema.Membership())
+}
+
+/*
+The path of the file f is about, cleaned up, and its ID: the one the client sent if it did (it got it from Resolve),
+otherwise whatever the namespace says. With create, a path with no file at it gets a new ID.
+*/
+func (m *MasterGRPCService) lookupFile(term uint64, f *proto.FileInfo, create bool) (string, string, error) {
+	path, err := fsys.CleanFilePath(f.Sdfsname)
+	if err != nil {
+		return "", "", status.Errorf(codes.InvalidArgument, "%v: %v", f.Sdfsname, err)
+	}
+	if f.Fileid != "" {
+		if err := fsys.CheckSDFSFileName(f.Fileid); err != nil {
+			return "", "", status.Error(codes.InvalidArgument, err.Error())
+		}
+		return path, f.Fileid, nil
+	}
+	newId := ""
+	if create {
+		idBytes := make([]byte, 8)
+		if _, err := crand.Read(idBytes); err != nil {
+			return "", "", status.Errorf(codes.Internal, "couldn't make up a file ID: %v", err)
+		}
+		newId = "id-" + hex.EncodeToString(idBytes)
+	}
+	var id string
+	var resolveErr error
+	err = m.readState(term, func(st *fsys.MasterState) {
+		id, resolveErr = st.ResolveFile(path)
+		if create && (resolveErr == os.ErrNotExist || resolveErr == nil && !st.HasFile(path)) {
+			id, resolveErr = st.IdForNewFile(path, newId), nil
+		}
+	})
+	if err == nil {
+		err = resolveErr
+	}
+	if err != nil {
+		return "", "", namespaceError(path, err)
+	}
+	return path, id, nil
+}
+
+/*
+ReadState, with the errors a client should get.
+*/
+func (m *MasterGRPCService) readState(term uint64, read func(st *fsys.MasterState)) error {
+	err := m.election.ReadState(term, read)
+	if err == errNotMaster {
+		return status.Errorf(codes.Unavailable, "no longer the master of term %v", term)
+	}
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return nil
+}
+
+/*
+What the client gets for a namespace operation on path that failed with err.
+*/
+func namespaceError(path string, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch err {
+	case os.ErrNotExist:
+		return status.Errorf(codes.NotFound, "%v: %v", path, err)
+	case os.ErrExist:
+		return status.Errorf(codes.AlreadyExists, "%v: %v", path, err)
+	}
+	return status.Errorf(codes.FailedPrecondition, "%v: %v", path, err)
 }
 
 /**
@@ -126,7 +278,7 @@ func (m *MasterGRPCService) GetReplicas(f *proto.FileInfo, stream proto.Master_G
 	mp3util.NodeLogger.Debug("Entered master/GetReplicas")
 
 	if config.NO_PARTITIONING_DEBUG {
-		for _, m := range schema.MemList.List {
+		for _, m := range schema.Membership().Members {
 			retm := &proto.ReplicaInfo{Name: m.Address, Port: m.Port, Memberid: m.Member_Id}
 			err := stream.Send(retm)
 			if err != nil {
@@ -178,52 +330,224 @@ func (m *MasterGRPCService) GetReplicasNonQuorum(f *proto.FileInfo, stream proto
 	return nil
 }
 
+/*
+Has the master group log entry in term, so whichever master comes next knows about it (see masterlog.go).
+*/
+func (m *MasterGRPCService) decide(term uint64, entry fsys.MasterLogEntry) (fsys.MasterLogEntry, error) {
+	name := entry.Path
+	if name == "" {
+		name = entry.SDFSFileName
+	}
+	decision, err := m.election.Propose(term, entry)
+	if err == errNotMaster {
+		return decision, status.Errorf(codes.Unavailable, "deposed as master of term %v before %v of %v was decided", term, entry.Op, name)
+	}
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Master group didn't commit %v of %v: %v", entry.Op, name, err)
+		return decision, status.Errorf(codes.Unavailable, "%v of %v wasn't committed: %v", entry.Op, name, err)
+	}
+	return decision, nil
+}
+
+/*
+decide, for entries that change the namespace: they're checked against it first, so that one that can't go through
+is turned away here rather than logged and then ignored. Called with m.mtx held, so nothing gets decided in between.
+*/
+func (m *MasterGRPCService) decideNamespace(term uint64, entry fsys.MasterLogEntry) (fsys.MasterLogEntry, error) {
+	err := m.checkNamespace(term, entry)
+	if err != nil {
+		return entry, err
+	}
+	return m.decide(term, entry)
+}
+
+/*
+Whether entry could go in the namespace as it is now, with the errors a client should get if not.
+*/
+func (m *MasterGRPCService) checkNamespace(term uint64, entry fsys.MasterLogEntry) error {
+	var checkErr error
+	err := m.readState(term, func(st *fsys.MasterState) {
+		checkErr = st.Check(entry)
+	})
+	if err != nil {
+		return err
+	}
+	if checkErr != nil {
+		return namespaceError(entry.Path, checkErr)
+	}
+	return nil
+}
+
 // Input: FileInfo
 // Output: Status
 func (m *MasterGRPCService) FinalizeWrite(ctx context.Context, fq *proto.FileAndQuorumInfo) (*proto.Status, error) {
 	mp3util.NodeLogger.Debug("Entered master/FinalizeWrite")
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
+	term, err := m.currentTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	/* What the uploader says about the version goes to the replicas with it */
+	attrs := &fsys.VersionAttributes{LocalFileName: fq.Args.Localname, MimeType: fq.Args.Mimetype}
+	for _, a := range fq.Args.Attrs {
+		if attrs.Attrs == nil {
+			attrs.Attrs = make(map[string]string)
+		}
+		attrs.Attrs[a.Key] = a.Value
+	}
+	if err := fsys.CheckAttributes(attrs.Attrs); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "bad attributes for %v: %v", fq.Args.Sdfsname, err)
+	}
+
+	path, id, err := m.lookupFile(term, fq.Args, true)
+	if err != nil {
+		return nil, err
+	}
+	attrs.Path = path
 
-	timestamp := time.Now().UnixNano()
+	/* The version is the master group's decision, so that it survives us and the next master's come after it. The
+	 * WRITE, which is what puts the file in the namespace (and in listings), only goes in once the quorum has it, so a
+	 * write that fails doesn't leave anything behind but a version nobody uses. */
+	write := fsys.MasterLogEntry{Op: fsys.MASTER_OP_WRITE, SDFSFileName: id, Path: path, ContentHash: fq.Args.ContentHash,
+		Writer: fq.Args.Writer}
+	err = m.checkNamespace(term, write)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := m.decide(term, fsys.MasterLogEntry{Op: fsys.MASTER_OP_VERSION, SDFSFileName: id, Path: path})
+	if err != nil {
+		return nil, err
+	}
+	timestamp := reserved.Version
 	/* Contact each replica in quorum and issue a FinalizeWrite request */
+	var succeeded []ReplicaMetadata
+	var failed []*proto.ReplicaInfo
 	for _, repInfo := range fq.Quorum {
 		r := NewReplicaMetadata(repInfo)
 		req := &fsys.TCPChannelRequest{
 			RequestType:     fsys.MASTER_FINALIZE_WRITE,
 			SDFSFileVersion: timestamp,
 			FileContentHash: fq.Args.ContentHash,
-			SDFSFileName:    fq.Args.Sdfsname,
+			SDFSFileName:    id,
+			Writer:          fq.Args.Writer,
+			Attributes:      attrs,
 		}
 
-		_, err := UnicastToReplica(req, r)
+		_, err := m.unicastAsMaster(req, term, r)
+		if err == errStaleTerm {
+			return nil, status.Errorf(codes.Unavailable, "deposed as master of term %v while finalizing %v", term, fq.Args.Sdfsname)
+		}
 		if err != nil {
 			mp3util.NodeLogger.Errorf("Couldn't finalize write on replica %v! Error: %v", r.MemberId, err)
+			failed = append(failed, repInfo)
+			continue
 		}
+		succeeded = append(succeeded, r)
+	}
+
+	/* W is the quorum size, unless there aren't even that many replicas for the file right now */
+	needed := config.QUORUM_SIZE
+	if partition, err := m.partitioner(&proto.FileInfo{Sdfsname: path, Fileid: id}); err == nil && len(partition) < needed {
+		needed = len(partition)
+	}
+	if len(succeeded) < needed || len(succeeded) == 0 {
+		var failedIds []string
+		for _, repInfo := range failed {
+			failedIds = append(failedIds, repInfo.Memberid)
+		}
+		st := status.New(codes.Unavailable, fmt.Sprintf("write quorum not met for %v: %v of %v replicas registered it (failed: %v)",
+			fq.Args.Sdfsname, len(succeeded), needed, strings.Join(failedIds, ", ")))
+		detailed, err := st.WithDetails(&proto.WriteQuorumFailure{
+			Succeeded: int32(len(succeeded)),
+			Needed:    int32(needed),
+			Failed:    failed,
+		})
+		if err == nil {
+			st = detailed
+		}
+		mp3util.NodeLogger.Error(st.Message())
+		return nil, st.Err()
+	}
+
+	/* Nothing can have changed the namespace in the meantime, we've had m.mtx all along, unless we got deposed */
+	write.Version = timestamp
+	_, err = m.decideNamespace(term, write)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Whoever missed the write, here or back when the client was uploading, gets it from a replica that has it (or, if
+	 * they're down, that replica holds on to it for them until they're back) */
+	missed := append(append([]*proto.ReplicaInfo{}, failed...), fq.Missed...)
+	if len(missed) > 0 {
+		go m.repairWrite(term, id, timestamp, succeeded, missed)
 	}
 	return &proto.Status{Rc: "FinishedWriteFinished"}, nil
 }
 
+/*
+Gets a write onto the replicas that missed it, by having one that did register it push it to them, or hold a hint for
+the ones it can't reach. Best effort: if it doesn't work, regular replication will still get there eventually.
+*/
+func (m *MasterGRPCService) repairWrite(term uint64, sdfsFileName string, version int64, succeeded []ReplicaMetadata, failed []*proto.ReplicaInfo) {
+	req := &fsys.TCPChannelRequest{
+		RequestType:     fsys.MASTER_REPAIR_WRITE,
+		SDFSFileName:    sdfsFileName,
+		SDFSFileVersion: version,
+	}
+	for _, repInfo := range failed {
+		req.RepairTargets = append(req.RepairTargets, fsys.ReplicaAddr{MemberId: repInfo.Memberid, Address: repInfo.Name})
+	}
+	for _, r := range succeeded {
+		_, err := m.unicastAsMaster(req, term, r)
+		if err == errStaleTerm {
+			return
+		}
+		if err == nil {
+			mp3util.NodeLogger.Infof("Replica %v repaired %v @ %v onto %v replicas that missed it", r.MemberId, sdfsFileName, version, len(failed))
+			return
+		}
+		mp3util.NodeLogger.Warnf("Replica %v couldn't repair %v @ %v: %v", r.MemberId, sdfsFileName, version, err)
+	}
+}
+
 // Input: FileInfo
 // Output: Status
 func (m *MasterGRPCService) FinalizeDelete(ctx context.Context, f *proto.FileInfo) (*proto.Status, error) {
 	m.mtx.Lock()
 	defer m.mtx.Unlock()
 	mp3util.NodeLogger.Debug("Entered master/finalizedelete")
+	term, err := m.currentTerm()
+	if err != nil {
+		return nil, err
+	}
 
-	timestamp := time.Now().UnixNano()
-	replicas, err := m.partitioner(f)
+	path, id, err := m.lookupFile(term, f, false)
+	if err != nil {
+		return nil, err
+	}
+	decision, err := m.decide(term, fsys.MasterLogEntry{Op: fsys.MASTER_OP_DELETE, SDFSFileName: id, Path: path})
+	if err != nil {
+		return nil, err
+	}
+	timestamp := decision.Version
+	replicas, err := m.partitioner(&proto.FileInfo{Sdfsname: path, Fileid: id})
 	if err != nil {
 		mp3util.NodeLogger.Error("Couldn't delete file on node!")
 		return nil, err
 	}
 
 	for _, r := range replicas {
-		_, err := UnicastToReplica(&fsys.TCPChannelRequest{
+		_, err := m.unicastAsMaster(&fsys.TCPChannelRequest{
 			RequestType:     fsys.MASTER_FINALIZE_DELETE,
-			SDFSFileName:    f.Sdfsname,
+			SDFSFileName:    id,
 			SDFSFileVersion: timestamp,
-		}, NewReplicaMetadata(r))
+		}, term, NewReplicaMetadata(r))
+		if err == errStaleTerm {
+			return nil, status.Errorf(codes.Unavailable, "deposed as master of term %v while deleting %v", term, f.Sdfsname)
+		}
 		if err != nil {
 			// TODO: Drink Potbelly Milkshake
 			mp3util.NodeLogger.Warnf("Couldn't delete file on replica %v! Error: %v", r.Memberid, err)
@@ -234,15 +558,256 @@ func (m *MasterGRPCService) FinalizeDelete(ctx context.Context, f *proto.FileInf
 	return &proto.Status{Rc: "FinalizeDeleteFinished"}, nil
 }
 
+/**
+ * Resolve
+ *	Looks up the ID of the file at a path, which is what replicas know it as
+ *	(see fsys/namespace.go). With create, a path there's no file at yet gets an
+ *	ID for the file about to be written there.
+ *
+ *	@param f - args containing the path
+ *	@return f - the cleaned up path and the file's ID
+ */
+func (m *MasterGRPCService) Resolve(ctx context.Context, f *proto.FileInfo) (*proto.FileInfo, error) {
+	mp3util.NodeLogger.Debug("Entered master/Resolve")
+	term, err := m.currentTerm()
+	if err != nil {
+		return nil, err
+	}
+	path, id, err := m.lookupFile(term, &proto.FileInfo{Sdfsname: f.Sdfsname}, f.Create)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.FileInfo{Sdfsname: path, Fileid: id}, nil
+}
+
+// Input: FileInfo
+// Output: Status
+func (m *MasterGRPCService) Mkdir(ctx context.Context, f *proto.FileInfo) (*proto.Status, error) {
+	return m.changeNamespace(fsys.MASTER_OP_MKDIR, f.Sdfsname, "")
+}
+
+// Input: FileInfo
+// Output: Status
+func (m *MasterGRPCService) Rmdir(ctx context.Context, f *proto.FileInfo) (*proto.Status, error) {
+	return m.changeNamespace(fsys.MASTER_OP_RMDIR, f.Sdfsname, "")
+}
+
+// Input: RenameInfo
+// Output: Status
+func (m *MasterGRPCService) Rename(ctx context.Context, r *proto.RenameInfo) (*proto.Status, error) {
+	return m.changeNamespace(fsys.MASTER_OP_RENAME, r.From, r.To)
+}
+
+/*
+Mkdir, Rmdir and Rename: nothing on the replicas changes, so it's just a matter of getting it decided.
+*/
+func (m *MasterGRPCService) changeNamespace(op fsys.MasterOp, path string, newPath string) (*proto.Status, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	mp3util.NodeLogger.Debugf("Entered master/%v", op)
+	term, err := m.currentTerm()
+	if err != nil {
+		return nil, err
+	}
+	entry := fsys.MasterLogEntry{Op: op}
+	if entry.Path, err = fsys.CleanPath(path); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v: %v", path, err)
+	}
+	if op == fsys.MASTER_OP_RENAME {
+		if entry.NewPath, err = fsys.CleanPath(newPath); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v: %v", newPath, err)
+		}
+	}
+	_, err = m.decideNamespace(term, entry)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.Status{Rc: fmt.Sprintf("%vFinished", op)}, nil
+}
+
+/**
+ * ListDirectory
+ *	Streams back what's in a directory (or with recursive, everything under it),
+ *	sorted by path. A path that's a file gets just that file.
+ *
+ *	@param f - args containing the directory
+ *	@param stream - grpc stream back to client
+ */
+func (m *MasterGRPCService) ListDirectory(f *proto.FileInfo, stream proto.Master_ListDirectoryServer) error {
+	mp3util.NodeLogger.Debug("Entered master/ListDirectory")
+	term, err := m.currentTerm()
+	if err != nil {
+		return err
+	}
+	dir, err := fsys.CleanPath(f.Sdfsname)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v: %v", f.Sdfsname, err)
+	}
+	var entries []*proto.DirEntry
+	err = m.readState(term, func(st *fsys.MasterState) {
+		var listing []fsys.NamespaceEntry
+		if st.IsDir(dir) {
+			listing = st.List(dir, f.Recursive)
+		} else if st.HasFile(dir) {
+			listing = []fsys.NamespaceEntry{{Path: dir, FileId: st.Names[dir]}}
+		} else {
+			return
+		}
+		entries = []*proto.DirEntry{}
+		for _, e := range listing {
+			entry := &proto.DirEntry{Path: e.Path, Dir: e.Dir, Fileid: e.FileId}
+			if record, ok := st.Files[e.FileId]; ok && !e.Dir {
+				entry.Version = record.Latest
+			}
+			entries = append(entries, entry)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if entries == nil {
+		return status.Errorf(codes.NotFound, "%v: %v", dir, os.ErrNotExist)
+	}
+	/* Gathered up first, so that the election's lock isn't held while we wait on the client */
+	for _, e := range entries {
+		err := stream.Send(e)
+		if err != nil {
+			mp3util.NodeLogger.Error("Failed to send directory entry: ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * ListFiles
+ *	Lists every file in SDFS. Asks every member what it stores (CLIENT_LIST_FILES)
+ *	and puts that together with the namespace, so a file that no member that
+ *	answered has still shows up, with no replicas. Streams back one summary per
+ *	file, sorted by path, filtered and paged the way the request says.
+ *
+ *	@param req - filters and paging
+ *	@param stream - grpc stream back to client
+ */
+func (m *MasterGRPCService) ListFiles(req *proto.ListFilesRequest, stream proto.Master_ListFilesServer) error {
+	mp3util.NodeLogger.Debug("Entered master/ListFiles")
+	term, err := m.currentTerm()
+	if err != nil {
+		return err
+	}
+	if _, err := path.Match(req.Glob, ""); err != nil {
+		return status.Errorf(codes.InvalidArgument, "bad glob %q: %v", req.Glob, err)
+	}
+
+	/* Newest version each member has of each file, by ID */
+	type stored struct {
+		version  int64
+		size     int64
+		replicas int32
+	}
+	storedFiles := make(map[string]*stored)
+	membership := schema.Membership()
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	for _, member := range membership.Members {
+		wg.Add(1)
+		go func(r ReplicaMetadata) {
+			defer wg.Done()
+			resp, err := unicastToReplicaAnyCode(&fsys.TCPChannelRequest{RequestType: fsys.CLIENT_LIST_FILES}, r, 0)
+			if err == nil && resp.ResponseCode != fsys.OK {
+				err = errors.New(fmt.Sprintf("responded %v", resp.ResponseCode))
+			}
+			if err != nil {
+				mp3util.NodeLogger.Warnf("Couldn't list the files on %v, they'll look under-replicated: %v", r.MemberId, err)
+				return
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			for _, f := range resp.FileList {
+				s, ok := storedFiles[f.SDFSFileName]
+				if !ok || f.Version > s.version {
+					storedFiles[f.SDFSFileName] = &stored{version: f.Version, size: f.Size, replicas: 1}
+				} else if f.Version == s.version {
+					s.replicas++
+				}
+			}
+		}(replicaMetadataOf(member))
+	}
+	wg.Wait()
+
+	/* Which of those have a path, and which files with a path nobody has */
+	type listedFile struct {
+		path    string
+		id      string
+		version int64 // What the master knows of, in case nobody has it
+	}
+	var files []listedFile
+	err = m.readState(term, func(st *fsys.MasterState) {
+		for p, id := range st.Names {
+			f := listedFile{path: p, id: id}
+			if record, ok := st.Files[id]; ok {
+				f.version = record.Latest
+			}
+			files = append(files, f)
+		}
+		for id := range storedFiles {
+			if _, named := st.Paths[id]; named {
+				continue
+			}
+			// From before the namespace. Anything else isn't at any path (a write that never got finalized, say).
+			if resolved, err := st.ResolveFile(id); err == nil && resolved == id {
+				files = append(files, listedFile{path: id, id: id})
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	var listed []listedFile
+	for _, f := range files {
+		if !strings.HasPrefix(f.path, req.Prefix) || req.After != "" && f.path <= req.After {
+			continue
+		}
+		if matched, _ := path.Match(req.Glob, f.path); req.Glob != "" && !matched {
+			continue
+		}
+		listed = append(listed, f)
+	}
+	sort.Slice(listed, func(i, j int) bool {
+		return listed[i].path < listed[j].path
+	})
+	if req.Limit > 0 && len(listed) > int(req.Limit) {
+		listed = listed[:req.Limit]
+	}
+
+	for _, f := range listed {
+		summary := &proto.FileSummary{Path: f.path, Fileid: f.id, Version: f.version}
+		if s, ok := storedFiles[f.id]; ok {
+			summary.Version, summary.Size, summary.Replicas = s.version, s.size, s.replicas
+		}
+		if partition, err := schema.Partition(schema.ConfiguredPlacement(), &proto.FileInfo{Sdfsname: f.id}, membership); err == nil {
+			summary.Wanted = int32(len(partition))
+		}
+		summary.Underreplicated = summary.Replicas < summary.Wanted
+		err := stream.Send(summary)
+		if err != nil {
+			mp3util.NodeLogger.Error("Failed to send file summary: ", err)
+			return err
+		}
+	}
+	return nil
+}
+
 /**
  * run
  *	Runs the master GRPC server on this node. Called
- *	once on init and every time this node becomes the master, which
- *	is provoked from a membership list change.
+ *	every time this node becomes the master, which is provoked
+ *	by winning an election.
  *	NOTE: Assumes caller grabs lock
  */
 func (m *MasterGRPCService) run() {
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(m.unaryTermInterceptor), grpc.StreamInterceptor(m.streamTermInterceptor))
 	proto.RegisterMasterServer(grpcServer, m)
 
 	conn, err := net.Listen("tcp", ":"+config.MP3_MASTER_PORT)
@@ -265,8 +830,8 @@ func (m *MasterGRPCService) run() {
 /**
  * Stop
  *	Stops the master GRPC server on this node. Called whenever
- *	this node is no longer the master, provoked by a membership list
- *	change.
+ *	this node is no longer the master, provoked by a newer term
+ *	showing up (or by losing touch with a majority).
  *	NOTE: Assumes caller grabs lock
  */
 func (m *MasterGRPCService) stop() {
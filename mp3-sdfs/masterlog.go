@@ -0,0 +1,390 @@
+package amogus
+
+import (
+	"amogus/config"
+	"amogus/fsys"
+	"amogus/mp3util"
+	"amogus/schema"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+The log half of Raft (election.go has the other half). The master puts each decision it makes (what version a write
+gets, what a delete takes out) in its log, sends the log along with its heartbeats to the rest of the master group, and
+only goes through with the decision once a majority of the group has it. That majority is sure to include whoever gets
+elected next, since nobody votes for a candidate whose log is behind theirs. So a new master knows everything the old
+ones decided, and versions never go backwards across a failover.
+
+Everyone in the group applies committed entries to their copy of fsys.MasterState, and compacts the log into a snapshot
+every MASTER_LOG_SNAPSHOT_INTERVAL entries. Followers too far behind for the log get the snapshot instead.
+*/
+
+var errNotMaster = errors.New("not the master anymore")
+
+/*
+The members that elect the master and keep its log, other than us: the ones at config.MASTER_GROUP's addresses, whether
+or not MP2 has them right now. Also returns how big the group is, us included, to count majorities against.
+
+The group has to be the same everywhere and stay put (see NewElection): if majorities were counted over whoever MP2
+says is around, a node that started alone could commit entries all by itself, and the ones that joined after could then
+elect a master that never heard of them.
+*/
+func masterGroup(membership *schema.MembershipSnapshot) ([]ReplicaMetadata, int) {
+	var peers []ReplicaMetadata
+	byAddress := make(map[string]schema.Member)
+	for _, m := range membership.Members {
+		byAddress[m.Address] = m
+	}
+	for _, address := range config.MASTER_GROUP {
+		if address == membership.Self.Address {
+			continue
+		}
+		peer := ReplicaMetadata{Address: address}
+		if m, ok := byAddress[address]; ok {
+			peer = replicaMetadataOf(m)
+		}
+		peers = append(peers, peer)
+	}
+	return peers, len(config.MASTER_GROUP)
+}
+
+func inMasterGroup(m schema.Member) bool {
+	for _, address := range config.MASTER_GROUP {
+		if address == m.Address {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+The master's state as of the newest committed entry.
+*/
+func (e *Election) MasterState() fsys.MasterState {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.state.Copy()
+}
+
+/*
+Runs read on the master's state, as master of term. Waits (up to MASTER_COMMIT_TIMEOUT) until something from our own
+term is committed first: before then, entries from before us that a majority has might not be applied yet. read runs
+with the election's lock held, so it has to be quick, and can't hang on to st.
+*/
+func (e *Election) ReadState(term uint64, read func(st *fsys.MasterState)) error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	timedOut := false
+	timer := time.AfterFunc(config.MASTER_COMMIT_TIMEOUT, func() {
+		e.mtx.Lock()
+		timedOut = true
+		e.committed.Broadcast()
+		e.mtx.Unlock()
+	})
+	defer timer.Stop()
+	for {
+		if e.role != LEADER || e.term != term {
+			return errNotMaster
+		}
+		if t, _ := e.log.Term(e.commitIndex); t == term {
+			break
+		}
+		if timedOut {
+			return errors.New(fmt.Sprintf("nothing from term %v committed within %v", term, config.MASTER_COMMIT_TIMEOUT))
+		}
+		e.committed.Wait()
+	}
+	read(&e.state)
+	return nil
+}
+
+/*
+Logs entry as the master of term, and waits for the group to commit it. VERSIONs and DELETEs get their version here, off
+our HLC, which has seen every version in the log, so it's newer than any a master of this or an earlier term handed
+out, whatever our clock says. So do WRITEs, unless they come with the version a VERSION entry got them. Fails if we
+stop being master, or a majority doesn't have it within MASTER_COMMIT_TIMEOUT (in which case it may still get committed
+later, but nobody acted on it).
+*/
+func (e *Election) Propose(term uint64, entry fsys.MasterLogEntry) (fsys.MasterLogEntry, error) {
+	_, size := masterGroup(schema.Membership())
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if e.role != LEADER || e.term != term {
+		return entry, errNotMaster
+	}
+	if entry.Op == fsys.MASTER_OP_VERSION || entry.Op == fsys.MASTER_OP_DELETE || entry.Op == fsys.MASTER_OP_WRITE && entry.Version == 0 {
+		entry.Version = e.clock.Now()
+	}
+	err := e.appendAsMaster(entry)
+	if err != nil {
+		return entry, err
+	}
+	entry = e.log.Entries(e.log.LastIndex(), 1)[0]
+	e.advanceCommit(size)
+	select {
+	case e.kick <- true:
+	default:
+	}
+
+	timedOut := false
+	timer := time.AfterFunc(config.MASTER_COMMIT_TIMEOUT, func() {
+		e.mtx.Lock()
+		timedOut = true
+		e.committed.Broadcast()
+		e.mtx.Unlock()
+	})
+	defer timer.Stop()
+	for e.commitIndex < entry.Index {
+		if e.role != LEADER || e.term != term {
+			return entry, errNotMaster
+		}
+		if timedOut {
+			return entry, errors.New(fmt.Sprintf("a majority of the master group didn't log entry %v within %v", entry.Index, config.MASTER_COMMIT_TIMEOUT))
+		}
+		e.committed.Wait()
+	}
+	if t, ok := e.log.Term(entry.Index); ok && t != term {
+		// Only possible if we were deposed and some other master's entry got committed there
+		return entry, errNotMaster
+	}
+	return entry, nil
+}
+
+func (e *Election) appendAsMaster(entry fsys.MasterLogEntry) error {
+	entry.Index = e.log.LastIndex() + 1
+	entry.Term = e.term
+	err := e.log.Append(entry)
+	if err == nil {
+		e.clock.Observe(entry.Version)
+	}
+	return err
+}
+
+/*
+Applies entries up to index, which a majority of the group has.
+*/
+func (e *Election) commitTo(index uint64) {
+	if index <= e.commitIndex {
+		return
+	}
+	for _, entry := range e.log.Entries(e.commitIndex+1, int(index-e.commitIndex)) {
+		e.state.Apply(entry)
+	}
+	e.commitIndex = index
+	e.committed.Broadcast()
+	if e.commitIndex-e.log.SnapshotIndex() >= uint64(config.MASTER_LOG_SNAPSHOT_INTERVAL) {
+		err := e.log.Compact(e.commitIndex, e.state.Copy())
+		if err != nil {
+			mp3util.NodeLogger.Errorf("Couldn't compact the master log up to %v: %v", e.commitIndex, err)
+		}
+	}
+}
+
+/*
+Commits the newest entry a majority of the group (out of size) has, if it's from our term. Older ones can't be counted
+that way (Raft paper, figure 8); they get committed along with the first of ours.
+*/
+func (e *Election) advanceCommit(size int) {
+	for n := e.log.LastIndex(); n > e.commitIndex; n-- {
+		if term, _ := e.log.Term(n); term != e.term {
+			return
+		}
+		count := 1
+		for _, p := range e.peers {
+			if p.match >= n {
+				count++
+			}
+		}
+		if count > size/2 {
+			e.commitTo(n)
+			return
+		}
+	}
+}
+
+/*
+What to send peer with the next heartbeat: the log from where it's at, or the snapshot if that's been compacted away.
+*/
+func (e *Election) appendRequest(peer string) *fsys.TCPChannelRequest {
+	p, ok := e.peers[peer]
+	if !ok {
+		p = &logPeer{next: e.log.LastIndex() + 1}
+		e.peers[peer] = p
+	}
+	req := &fsys.TCPChannelRequest{RequestType: fsys.ELECTION_HEARTBEAT, Term: e.term, Candidate: e.leader, LeaderCommit: e.commitIndex}
+	if p.next <= e.log.SnapshotIndex() {
+		snap := e.log.Snapshot()
+		req.LogSnapshot = &snap
+		req.PrevLogIndex, req.PrevLogTerm = snap.Index, snap.Term
+	} else {
+		req.PrevLogIndex = p.next - 1
+		req.PrevLogTerm, _ = e.log.Term(req.PrevLogIndex)
+	}
+	req.LogEntries = e.log.Entries(req.PrevLogIndex+1, config.MASTER_LOG_MAX_APPEND)
+	return req
+}
+
+/*
+Sends the group whatever it's missing of the log (and everybody else a plain heartbeat), commits what a majority has,
+and steps down if no majority has answered in ELECTION_TIMEOUT.
+*/
+func (e *Election) replicate() {
+	membership := schema.Membership()
+	group, size := masterGroup(membership)
+	e.mtx.Lock()
+	term, self, role := e.term, e.leader, e.role
+	if role != LEADER {
+		e.mtx.Unlock()
+		return
+	}
+	reqs := make(map[ReplicaMetadata]*fsys.TCPChannelRequest)
+	inGroup := make(map[string]bool)
+	for _, peer := range group {
+		inGroup[peer.Address] = true
+		reqs[peer] = e.appendRequest(peer.Address)
+	}
+	for _, m := range membership.Members {
+		if m.Member_Id != self && !inMasterGroup(m) {
+			reqs[replicaMetadataOf(m)] = &fsys.TCPChannelRequest{RequestType: fsys.ELECTION_HEARTBEAT, Term: term, Candidate: self}
+		}
+	}
+	e.mtx.Unlock()
+
+	acks := 1
+	var wg sync.WaitGroup
+	for peer, req := range reqs {
+		wg.Add(1)
+		go func(peer ReplicaMetadata, req *fsys.TCPChannelRequest) {
+			defer wg.Done()
+			timeout := config.ELECTION_RPC_TIMEOUT
+			if req.LogSnapshot != nil {
+				timeout = config.DEFAULT_TCP_TIMEOUT
+			}
+			resp, err := unicastToReplicaAnyCode(req, peer, timeout)
+			if err != nil {
+				return
+			}
+			if resp.Term > term {
+				e.ObserveTerm(resp.Term)
+				return
+			}
+			if !inGroup[peer.Address] || resp.ResponseCode != fsys.OK {
+				return
+			}
+			e.mtx.Lock()
+			defer e.mtx.Unlock()
+			acks++
+			if e.role != LEADER || e.term != term {
+				return
+			}
+			p := e.peers[peer.Address]
+			if resp.LogMatched {
+				if resp.LastLogIndex > p.match {
+					p.match = resp.LastLogIndex
+				}
+				p.next = p.match + 1
+				e.advanceCommit(size)
+			} else {
+				// Back up to where its log ends or disagrees with ours, and try again from there next time
+				if resp.LastLogIndex+1 < p.next {
+					p.next = resp.LastLogIndex + 1
+				} else if p.next > 1 {
+					p.next--
+				}
+				select {
+				case e.kick <- true:
+				default:
+				}
+			}
+		}(peer, req)
+	}
+	wg.Wait()
+
+	e.mtx.Lock()
+	steppedDown := false
+	if e.role == LEADER && e.term == term {
+		if acks > size/2 {
+			e.deadline = time.Now().Add(config.ELECTION_TIMEOUT)
+		} else if time.Now().After(e.deadline) {
+			mp3util.NodeLogger.Warnf("No majority of the master group has answered in %v, stepping down as master of term %v", config.ELECTION_TIMEOUT, term)
+			e.role = FOLLOWER
+			e.leader = ""
+			e.resetDeadline()
+			e.committed.Broadcast()
+			steppedDown = true
+		}
+	}
+	e.mtx.Unlock()
+	if steppedDown {
+		e.notify()
+	}
+}
+
+/*
+Follower side of replicate: takes the snapshot if the master sent one we need, then the entries if our log matches the
+master's up to where they start. Answers with how far our log is known to match (or, if it doesn't, where the master
+should back up to).
+*/
+func (e *Election) appendFromMaster(req fsys.TCPChannelRequest, resp *fsys.TCPChannelResponse) {
+	if req.LogSnapshot != nil && req.LogSnapshot.Index > e.commitIndex {
+		err := e.log.InstallSnapshot(*req.LogSnapshot)
+		if err != nil {
+			mp3util.NodeLogger.Errorf("Couldn't install the master's log snapshot at %v: %v", req.LogSnapshot.Index, err)
+			resp.LastLogIndex = e.commitIndex
+			return
+		}
+		mp3util.NodeLogger.Infof("Caught up on the master log from %v's snapshot at %v", req.Candidate, req.LogSnapshot.Index)
+		e.state = req.LogSnapshot.State.Copy()
+		e.commitIndex = req.LogSnapshot.Index
+		e.clock.Observe(e.state.LastVersion)
+		e.committed.Broadcast()
+	}
+
+	/* Anything up to the snapshot was committed, so it can't disagree with the master's */
+	if req.PrevLogIndex > e.log.SnapshotIndex() {
+		term, ok := e.log.Term(req.PrevLogIndex)
+		if !ok || term != req.PrevLogTerm {
+			resp.LastLogIndex = req.PrevLogIndex - 1
+			if e.log.LastIndex() < resp.LastLogIndex {
+				resp.LastLogIndex = e.log.LastIndex()
+			}
+			return
+		}
+	}
+	for i, entry := range req.LogEntries {
+		if entry.Index <= e.log.SnapshotIndex() {
+			continue
+		}
+		if term, ok := e.log.Term(entry.Index); ok {
+			if term == entry.Term {
+				continue
+			}
+			err := e.log.TruncateAfter(entry.Index - 1)
+			if err != nil {
+				mp3util.NodeLogger.Errorf("Couldn't truncate the master log after %v: %v", entry.Index-1, err)
+				resp.LastLogIndex = e.commitIndex
+				return
+			}
+		}
+		err := e.log.Append(req.LogEntries[i:]...)
+		if err != nil {
+			mp3util.NodeLogger.Errorf("Couldn't append to the master log: %v", err)
+			resp.LastLogIndex = e.commitIndex
+			return
+		}
+		for _, appended := range req.LogEntries[i:] {
+			e.clock.Observe(appended.Version)
+		}
+		break
+	}
+	resp.LogMatched = true
+	resp.LastLogIndex = req.PrevLogIndex + uint64(len(req.LogEntries))
+	if req.LeaderCommit < resp.LastLogIndex {
+		e.commitTo(req.LeaderCommit)
+	} else {
+		e.commitTo(resp.LastLogIndex)
+	}
+}
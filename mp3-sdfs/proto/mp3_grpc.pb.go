@@ -26,6 +26,12 @@ type MasterClient interface {
 	GetReplicasNonQuorum(ctx context.Context, in *FileInfo, opts ...grpc.CallOption) (Master_GetReplicasNonQuorumClient, error)
 	FinalizeWrite(ctx context.Context, in *FileAndQuorumInfo, opts ...grpc.CallOption) (*Status, error)
 	FinalizeDelete(ctx context.Context, in *FileInfo, opts ...grpc.CallOption) (*Status, error)
+	Resolve(ctx context.Context, in *FileInfo, opts ...grpc.CallOption) (*FileInfo, error)
+	Mkdir(ctx context.Context, in *FileInfo, opts ...grpc.CallOption) (*Status, error)
+	Rmdir(ctx context.Context, in *FileInfo, opts ...grpc.CallOption) (*Status, error)
+	Rename(ctx context.Context, in *RenameInfo, opts ...grpc.CallOption) (*Status, error)
+	ListDirectory(ctx context.Context, in *FileInfo, opts ...grpc.CallOption) (Master_ListDirectoryClient, error)
+	ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (Master_ListFilesClient, error)
 }
 
 type masterClient struct {
@@ -118,6 +124,106 @@ func (c *masterClient) FinalizeDelete(ctx context.Context, in *FileInfo, opts ..
 	return out, nil
 }
 
+func (c *masterClient) Resolve(ctx context.Context, in *FileInfo, opts ...grpc.CallOption) (*FileInfo, error) {
+	out := new(FileInfo)
+	err := c.cc.Invoke(ctx, "/proto.Master/Resolve", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) Mkdir(ctx context.Context, in *FileInfo, opts ...grpc.CallOption) (*Status, error) {
+	out := new(Status)
+	err := c.cc.Invoke(ctx, "/proto.Master/Mkdir", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) Rmdir(ctx context.Context, in *FileInfo, opts ...grpc.CallOption) (*Status, error) {
+	out := new(Status)
+	err := c.cc.Invoke(ctx, "/proto.Master/Rmdir", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) Rename(ctx context.Context, in *RenameInfo, opts ...grpc.CallOption) (*Status, error) {
+	out := new(Status)
+	err := c.cc.Invoke(ctx, "/proto.Master/Rename", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *masterClient) ListDirectory(ctx context.Context, in *FileInfo, opts ...grpc.CallOption) (Master_ListDirectoryClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Master_ServiceDesc.Streams[2], "/proto.Master/ListDirectory", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &masterListDirectoryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Master_ListDirectoryClient interface {
+	Recv() (*DirEntry, error)
+	grpc.ClientStream
+}
+
+type masterListDirectoryClient struct {
+	grpc.ClientStream
+}
+
+func (x *masterListDirectoryClient) Recv() (*DirEntry, error) {
+	m := new(DirEntry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *masterClient) ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (Master_ListFilesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Master_ServiceDesc.Streams[3], "/proto.Master/ListFiles", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &masterListFilesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Master_ListFilesClient interface {
+	Recv() (*FileSummary, error)
+	grpc.ClientStream
+}
+
+type masterListFilesClient struct {
+	grpc.ClientStream
+}
+
+func (x *masterListFilesClient) Recv() (*FileSummary, error) {
+	m := new(FileSummary)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // MasterServer is the server API for Master service.
 // All implementations must embed UnimplementedMasterServer
 // for forward compatibility
@@ -126,6 +232,12 @@ type MasterServer interface {
 	GetReplicasNonQuorum(*FileInfo, Master_GetReplicasNonQuorumServer) error
 	FinalizeWrite(context.Context, *FileAndQuorumInfo) (*Status, error)
 	FinalizeDelete(context.Context, *FileInfo) (*Status, error)
+	Resolve(context.Context, *FileInfo) (*FileInfo, error)
+	Mkdir(context.Context, *FileInfo) (*Status, error)
+	Rmdir(context.Context, *FileInfo) (*Status, error)
+	Rename(context.Context, *RenameInfo) (*Status, error)
+	ListDirectory(*FileInfo, Master_ListDirectoryServer) error
+	ListFiles(*ListFilesRequest, Master_ListFilesServer) error
 	mustEmbedUnimplementedMasterServer()
 }
 
@@ -145,6 +257,24 @@ func (UnimplementedMasterServer) FinalizeWrite(context.Context, *FileAndQuorumIn
 func (UnimplementedMasterServer) FinalizeDelete(context.Context, *FileInfo) (*Status, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method FinalizeDelete not implemented")
 }
+func (UnimplementedMasterServer) Resolve(context.Context, *FileInfo) (*FileInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resolve not implemented")
+}
+func (UnimplementedMasterServer) Mkdir(context.Context, *FileInfo) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Mkdir not implemented")
+}
+func (UnimplementedMasterServer) Rmdir(context.Context, *FileInfo) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rmdir not implemented")
+}
+func (UnimplementedMasterServer) Rename(context.Context, *RenameInfo) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rename not implemented")
+}
+func (UnimplementedMasterServer) ListDirectory(*FileInfo, Master_ListDirectoryServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListDirectory not implemented")
+}
+func (UnimplementedMasterServer) ListFiles(*ListFilesRequest, Master_ListFilesServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListFiles not implemented")
+}
 func (UnimplementedMasterServer) mustEmbedUnimplementedMasterServer() {}
 
 // UnsafeMasterServer may be embedded to opt out of forward compatibility for this service.
@@ -236,6 +366,120 @@ func _Master_FinalizeDelete_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Master_Resolve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FileInfo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).Resolve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Master/Resolve",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).Resolve(ctx, req.(*FileInfo))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_Mkdir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FileInfo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).Mkdir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Master/Mkdir",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).Mkdir(ctx, req.(*FileInfo))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_Rmdir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FileInfo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).Rmdir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Master/Rmdir",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).Rmdir(ctx, req.(*FileInfo))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_Rename_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameInfo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).Rename(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Master/Rename",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).Rename(ctx, req.(*RenameInfo))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Master_ListDirectory_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FileInfo)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MasterServer).ListDirectory(m, &masterListDirectoryServer{stream})
+}
+
+type Master_ListDirectoryServer interface {
+	Send(*DirEntry) error
+	grpc.ServerStream
+}
+
+type masterListDirectoryServer struct {
+	grpc.ServerStream
+}
+
+func (x *masterListDirectoryServer) Send(m *DirEntry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Master_ListFiles_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListFilesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MasterServer).ListFiles(m, &masterListFilesServer{stream})
+}
+
+type Master_ListFilesServer interface {
+	Send(*FileSummary) error
+	grpc.ServerStream
+}
+
+type masterListFilesServer struct {
+	grpc.ServerStream
+}
+
+func (x *masterListFilesServer) Send(m *FileSummary) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Master_ServiceDesc is the grpc.ServiceDesc for Master service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -251,6 +495,22 @@ var Master_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "FinalizeDelete",
 			Handler:    _Master_FinalizeDelete_Handler,
 		},
+		{
+			MethodName: "Resolve",
+			Handler:    _Master_Resolve_Handler,
+		},
+		{
+			MethodName: "Mkdir",
+			Handler:    _Master_Mkdir_Handler,
+		},
+		{
+			MethodName: "Rmdir",
+			Handler:    _Master_Rmdir_Handler,
+		},
+		{
+			MethodName: "Rename",
+			Handler:    _Master_Rename_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -263,6 +523,16 @@ var Master_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Master_GetReplicasNonQuorum_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ListDirectory",
+			Handler:       _Master_ListDirectory_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListFiles",
+			Handler:       _Master_ListFiles_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "proto/mp3.proto",
 }
@@ -6,11 +6,11 @@ import (
 	"amogus/mp3util"
 	"amogus/proto"
 	"amogus/schema"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
-	"path/filepath"
 	"sync"
 	"time"
 )
@@ -23,12 +23,18 @@ type ReplicaMetadata struct {
 
 type ReplicaFileInfo struct {
 	ReplicaID ReplicaMetadata
-	Version   time.Time
+	Version   int64 // See fsys/version.go
 }
 
 type ReplicaService struct {
-	dataConn net.Conn
-	sdfs     *fsys.LocalSDFSStorage
+	dataConn       net.Conn
+	sdfs           *fsys.LocalSDFSStorage
+	scrubCursor    fsys.SDFSFile           // The last version Scrub looked at.
+	chains         map[string]*uploadChain // Uploads we're forwarding down a chain, by their ID here
+	chainsMtx      sync.Mutex
+	merkle         merkleCache // Our Merkle trees of the ring ranges, for anti-entropy
+	antiEntropyMtx sync.Mutex
+	election       *Election // Who's master, and in which term
 }
 
 type ReplicationJobs struct {
@@ -41,18 +47,29 @@ var inProgressReplicationJobs ReplicationJobs
 
 func NewReplicaGRPCService() *ReplicaService {
 	r := &ReplicaService{}
-	sdfs, err := fsys.NewSDFSStorage()
+	var sdfs *fsys.LocalSDFSStorage
+	var err error
+	if config.RECOVER_SDFS_STORAGE {
+		sdfs, err = fsys.RecoverSDFSStorage()
+	} else {
+		sdfs, err = fsys.NewSDFSStorage()
+	}
 	if err != nil {
 		mp3util.NodeLogger.Fatal("Failed to create filesystem module")
 		return nil
 	}
 	inProgressReplicationJobs.inProgressReplications = make(map[string]map[int64]bool)
 	r.sdfs = sdfs
+	r.chains = make(map[string]*uploadChain)
+	r.election = NewElection(sdfs)
 	return r
 }
 
-/*
+func (r *ReplicaService) Election() *Election {
+	return r.election
+}
 
+/*
  */
 func (r *ReplicaService) DataConnHandleQUERYREPLICATIONOFFER(conn net.Conn, req fsys.TCPChannelRequest) error {
 	defer conn.Close()
@@ -90,7 +107,7 @@ func (r *ReplicaService) DataConnHandleQUERYREPLICATIONOFFER(conn net.Conn, req
 	}()
 
 	if err != nil {
-		mp3util.NodeLogger.Error("Couldn't set up replication workflow! Error: %v ", err)
+		mp3util.NodeLogger.Errorf("Couldn't set up replication workflow! Error: %v ", err)
 		return err
 	}
 	mp3util.NodeLogger.Debugf("replicationTransactions to initialize: %v", replicationTransactions)
@@ -105,21 +122,59 @@ func (r *ReplicaService) DataConnHandleQUERYREPLICATIONOFFER(conn net.Conn, req
 					"out of %v total pending transactions!!", nthTransaction, len(replicationTransactions))
 				return err
 			}
-			err = (&fsys.TCPChannelResponse{ResponseCode: fsys.OK}).Send(conn)
+			if fileReq.Manifest == nil {
+				mp3util.NodeLogger.Errorf("Replication of %v @ %v came without a manifest!", fileReq.SDFSFileName, fileReq.SDFSFileVersion)
+				fsys.TrySendTCPChannelResponseError(conn, fsys.BAD_REQUEST)
+				return errors.New("replication request without a manifest")
+			}
+			// Only ask for the chunks we don't already have. The ones we do have are held for this version from here on.
+			reservation := r.sdfs.ReserveChunks(*fileReq.Manifest)
+			err = (&fsys.TCPChannelResponse{ResponseCode: fsys.OK, MissingChunks: reservation.Missing}).Send(conn)
 			if err != nil {
-				mp3util.NodeLogger.Error("Couldn't send ACK for replication on the %v'th transaction out of %v total pending transactions!",
+				mp3util.NodeLogger.Errorf("Couldn't send ACK for replication on the %v'th transaction out of %v total pending transactions!",
 					nthTransaction, len(replicationTransactions))
 			}
-			mp3util.NodeLogger.Infof("Now downloading %v @ %v...", fileReq.SDFSFileName, fileReq.SDFSFileVersion)
-			contentHash, err := r.sdfs.DumpBytesToTmpfile(&io.LimitedReader{R: conn, N: fileReq.FileSize})
+			mp3util.NodeLogger.Infof("Now downloading %v @ %v (%v of %v chunks)...", fileReq.SDFSFileName, fileReq.SDFSFileVersion,
+				len(reservation.Missing), len(fileReq.Manifest.Chunks))
+			corrupt := false
+			for _, hash := range reservation.Missing {
+				size, _ := fileReq.Manifest.CompressedSizeOf(hash)
+				err = reservation.Receive(hash, size, conn)
+				if err == fsys.ErrContentHashMismatch {
+					corrupt = true // Keep reading, the rest of the transfer is still in sync.
+				} else if err != nil {
+					mp3util.NodeLogger.Errorf("Couldn't finish downloading file: %v @ %v! Error: %v", fileReq.SDFSFileName, fileReq.SDFSFileVersion, err)
+					reservation.Release()
+					return err
+				}
+			}
+			if corrupt {
+				err = fsys.ErrContentHashMismatch
+			} else {
+				mp3util.NodeLogger.Infof("Now registering replica-sent file to fs...")
+				var attrs fsys.VersionAttributes
+				if fileReq.Attributes != nil {
+					attrs = *fileReq.Attributes
+				}
+				err = r.sdfs.RegisterReplicatedVersion(reservation, fileReq.FileContentHash, fileReq.SDFSFileVersion, fileReq.SDFSFileName, fsys.VersionOrigin{
+					Writer: fileReq.Writer,
+					Reason: fmt.Sprintf("replicated from %v", conn.RemoteAddr()),
+				}, attrs)
+			}
+			verdict := &fsys.TCPChannelResponse{ResponseCode: fsys.OK, FileContentHash: fileReq.FileContentHash}
 			if err != nil {
-				mp3util.NodeLogger.Errorf("Couldn't finish downloading file: %v @ %v! Error: %v", fileReq.SDFSFileName, fileReq.SDFSFileVersion, err)
-				return err
+				mp3util.NodeLogger.Errorf("Couldn't register replicated version %v @ %v! Error: %v", fileReq.SDFSFileName, fileReq.SDFSFileVersion, err)
+				reservation.Release()
+				verdict.ResponseCode = fsys.MISC_ERROR
+				if err == fsys.ErrContentHashMismatch {
+					// Rejected. Tell the sender, its copy is probably the bad one. We'll be offered it again next pass.
+					verdict.ResponseCode = fsys.CORRUPT
+				}
 			}
-			mp3util.NodeLogger.Infof("Now registering replica-sent file to fs...")
-			err = r.sdfs.RegisterTmpfileToSDFS(contentHash, time.Unix(0, fileReq.SDFSFileVersion), fileReq.SDFSFileName)
+			err = verdict.Send(conn)
 			if err != nil {
-				mp3util.NodeLogger.Errorf("Couldn't register tmpfile for %v @ %v! Error: %v", fileReq.SDFSFileName, fileReq.SDFSFileVersion, err)
+				mp3util.NodeLogger.Errorf("Couldn't tell the sender how %v @ %v went! Error: %v", fileReq.SDFSFileName, fileReq.SDFSFileVersion, err)
+				return err
 			}
 
 			inProgressReplicationJobs.mtx.Lock()
@@ -142,7 +197,7 @@ func (r *ReplicaService) DataConnHandleQUERYREPLICATIONOFFER(conn net.Conn, req
 	}()
 
 	if err != nil {
-		mp3util.NodeLogger.Errorf("Error downloading all file transactions! Error: %v. Acquiring lock to unreserve the incomplete transactions...")
+		mp3util.NodeLogger.Errorf("Error downloading all file transactions! Error: %v. Acquiring lock to unreserve the incomplete transactions...", err)
 		inProgressReplicationJobs.mtx.Lock()
 		defer inProgressReplicationJobs.mtx.Unlock()
 		// TODO: Mark the outstanding transfers somewhere so that we may restart them via passive replication or something like that.
@@ -163,7 +218,7 @@ func (r *ReplicaService) DataConnHandleQUERYREPLICATIONOFFER(conn net.Conn, req
 		return err
 	}
 
-	mp3util.NodeLogger.Debug("Successfully replicated %v files!", nthTransaction-1)
+	mp3util.NodeLogger.Debugf("Successfully replicated %v files!", nthTransaction-1)
 	return nil
 }
 
@@ -187,10 +242,22 @@ func (r *ReplicaService) IdentifyDesiredFiles(req fsys.TCPChannelRequest) (fsys.
 	for assignedFile := range req.FileVersionSet {
 		_, existsLocally := localSet[assignedFile]
 		if !existsLocally {
-			localSet[assignedFile] = map[int64]bool{} // Make MergedKLatestVersions work with this map, otherwise something weird might happen.
+			localSet[assignedFile] = map[int64]bool{} // Make MergedRetainedVersions work with this map, otherwise something weird might happen.
 		}
+		// Never take back versions we were told to delete; a replica that missed the delete (or a read repair from it)
+		// would otherwise resurrect them.
+		offered := make(map[int64]bool)
+		_, tombstone := r.sdfs.History(assignedFile)
+		for version := range req.FileVersionSet[assignedFile] {
+			if version > tombstone {
+				offered[version] = true
+			}
+		}
+		// If we don't have the file yet, we don't know its path, and go by its ID. For a file not in the root, that may
+		// be the wrong policy, but once we have a version we know better, and the next offer gets us the rest.
+		policy := fsys.RetentionPolicyFor(r.sdfs.RetentionPath(assignedFile, localSet[assignedFile]))
 		unregisteredSDFSFileVersionPairs[assignedFile], versionsToDelete[assignedFile] =
-			fsys.MergedKLatestVersions(localSet[assignedFile], req.FileVersionSet[assignedFile], config.NUM_VERSIONS)
+			fsys.MergedRetainedVersions(localSet[assignedFile], offered, policy, time.Now())
 
 		mp3util.NodeLogger.Debugf("unregistered=%v, versionsToDelete=%v", unregisteredSDFSFileVersionPairs[assignedFile], versionsToDelete[assignedFile])
 		// Prevent malformed outputs, our business logic can't handle an file -> empty map.
@@ -221,7 +288,7 @@ func (r *ReplicaService) IdentifyDesiredFiles(req fsys.TCPChannelRequest) (fsys.
 func (r *ReplicaService) DataConnHandleCLIENTREQKVERSIONS(conn net.Conn, req fsys.TCPChannelRequest) error {
 	defer conn.Close()
 	mp3util.NodeLogger.Debugf("About to acquire filehandles for SDFSFileName=%v, KVersions=%v", req.SDFSFileName, req.KVersions)
-	handles, err := r.sdfs.AcquireFileHandles(req.KVersions, req.SDFSFileName, time.Now())
+	handles, err := r.sdfs.AcquireFileHandles(req.KVersions, req.SDFSFileName, fsys.LATEST_VERSION)
 	if err != nil || len(handles) == 0 {
 		if os.IsNotExist(err) || len(handles) == 0 {
 			mp3util.NodeLogger.Warn("No file found on this replica.")
@@ -237,11 +304,15 @@ func (r *ReplicaService) DataConnHandleCLIENTREQKVERSIONS(conn net.Conn, req fsy
 	defer fsys.CloseHandles(handles)
 
 	var allVersions []fsys.SDFSFile
-	for _, r := range handles {
-		allVersions = append(allVersions, fsys.SDFSFile{
+	for _, h := range handles {
+		f := fsys.SDFSFile{
 			SDFSFileName: req.SDFSFileName,
-			Version:      r.Version.UnixNano(),
-		})
+			Version:      h.Version,
+		}
+		if meta, err := r.sdfs.ReadVersionMetadata(req.SDFSFileName, h.Version); err == nil {
+			f.Metadata, f.Size = &meta, meta.Size
+		}
+		allVersions = append(allVersions, f)
 	}
 	mp3util.NodeLogger.Debugf("About to send the response back to the client.")
 	err = (&fsys.TCPChannelResponse{
@@ -261,7 +332,7 @@ func (r *ReplicaService) DataConnHandleCLIENTREQFILEDATA(conn net.Conn, req fsys
 	defer conn.Close()
 
 	/* Find the latest version of a file per the client's request */
-	handles, err := r.sdfs.AcquireFileHandles(1, req.SDFSFileName, time.Unix(0, req.UpperVersionBound))
+	handles, err := r.sdfs.AcquireFileHandles(1, req.SDFSFileName, req.UpperVersionBound)
 	if err != nil || len(handles) == 0 {
 		if os.IsNotExist(err) || len(handles) == 0 {
 			fsys.TrySendTCPChannelResponseError(conn, fsys.FILE_NOT_FOUND)
@@ -270,14 +341,21 @@ func (r *ReplicaService) DataConnHandleCLIENTREQFILEDATA(conn net.Conn, req fsys
 			fsys.TrySendTCPChannelResponseError(conn, fsys.MISC_ERROR)
 			return err
 		}
-		return err
 	}
 	defer fsys.CloseHandles(handles)
+	// The client checks what it unzips against this, so a rotten copy on our disk can't silently become its copy.
+	meta, err := r.sdfs.ReadVersionMetadata(req.SDFSFileName, handles[0].Version)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("No metadata for %v @ %v, can't vouch for it! Error: %v", req.SDFSFileName, handles[0].Version, err)
+		fsys.TrySendTCPChannelResponseError(conn, fsys.MISC_ERROR)
+		return err
+	}
 
 	err = (&fsys.TCPChannelResponse{
 		ResponseCode:          "OK",
 		ReturningSDFSFileSize: handles[0].FileSize,
-		SDFSFileVersion:       handles[0].Version.UnixNano(),
+		SDFSFileVersion:       handles[0].Version,
+		FileContentHash:       meta.ContentHash,
 	}).Send(conn)
 	if err != nil {
 		mp3util.NodeLogger.Errorf("Couldn't send back OK response to client!")
@@ -294,12 +372,58 @@ func (r *ReplicaService) DataConnHandleCLIENTREQFILEDATA(conn net.Conn, req fsys
 	return nil
 }
 
+/*
+Like CLIENT_REQ_FILE_DATA, but only the chunks covering [RangeOffset, RangeOffset+RangeLength) of the uncompressed
+content. The client gets told which chunks are coming so it can check each of them and trim the ends.
+*/
+func (r *ReplicaService) DataConnHandleCLIENTREQFILERANGE(conn net.Conn, req fsys.TCPChannelRequest) error {
+	defer conn.Close()
+
+	rangeHandle, err := r.sdfs.AcquireRange(req.SDFSFileName, req.UpperVersionBound, req.RangeOffset, req.RangeLength)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fsys.TrySendTCPChannelResponseError(conn, fsys.FILE_NOT_FOUND)
+		} else if errors.Is(err, os.ErrInvalid) {
+			fsys.TrySendTCPChannelResponseError(conn, fsys.BAD_REQUEST)
+		} else {
+			fsys.TrySendTCPChannelResponseError(conn, fsys.MISC_ERROR)
+		}
+		return err
+	}
+	defer rangeHandle.Handle.Close()
+
+	err = (&fsys.TCPChannelResponse{
+		ResponseCode:          fsys.OK,
+		ReturningSDFSFileSize: rangeHandle.CompressedSize,
+		SDFSFileVersion:       rangeHandle.Version,
+		RangeChunks:           rangeHandle.Chunks,
+		RangeSkip:             rangeHandle.Skip,
+		RangeLength:           rangeHandle.Length,
+	}).Send(conn)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't send back OK response to client!")
+		return err
+	}
+
+	nbytes, err := io.Copy(conn, rangeHandle.Handle)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Only sent %v bytes of the range before erroring out! Error: %v", nbytes, err)
+		return err
+	}
+	mp3util.NodeLogger.Debugf("Wrote %v bytes of %v (range %v+%v) to the connection.", nbytes, req.SDFSFileName, req.RangeOffset, rangeHandle.Length)
+	return nil
+}
+
 func (r *ReplicaService) DataConnHandleCLIENTREQFILEMETADATA(conn net.Conn, req fsys.TCPChannelRequest) error {
 	defer conn.Close()
-	/* Find the latest version'ed file for the client's request */
-	handles, err := r.sdfs.AcquireFileHandles(1, req.SDFSFileName, time.Now())
+	/* Find the latest version'ed file for the client's request (or the latest up to the version it asked for) */
+	upperVersionBound := req.UpperVersionBound
+	if upperVersionBound == 0 {
+		upperVersionBound = fsys.LATEST_VERSION
+	}
+	handles, err := r.sdfs.AcquireFileHandles(1, req.SDFSFileName, upperVersionBound)
 	defer fsys.CloseHandles(handles)
-	if err != nil {
+	if err != nil || len(handles) == 0 {
 		mp3util.NodeLogger.Warn("File not found: ", req.SDFSFileName)
 		err = (&fsys.TCPChannelResponse{
 			ResponseCode: fsys.FILE_NOT_FOUND,
@@ -307,19 +431,16 @@ func (r *ReplicaService) DataConnHandleCLIENTREQFILEMETADATA(conn net.Conn, req
 		return err
 	}
 
-	/* Determine file size */
-	path := filepath.Join(r.sdfs.RootDir, fsys.STOREDFILE_DIR, handles[0].SDFSFileName)
-	stat, err := os.Stat(path)
-	if err != nil {
-		return err
-	}
-
-	/* Construct response for latest file */
-	err = (&fsys.TCPChannelResponse{
+	/* Construct response for latest file. The size is what a CLIENT_REQ_FILE_DATA would send, not what the manifest takes up. */
+	resp := &fsys.TCPChannelResponse{
 		ResponseCode:          fsys.OK,
-		ReturningSDFSFileSize: stat.Size(),
-		SDFSFileVersion:       handles[0].Version.UnixNano(),
-	}).Send(conn)
+		ReturningSDFSFileSize: handles[0].FileSize,
+		SDFSFileVersion:       handles[0].Version,
+	}
+	if meta, err := r.sdfs.ReadVersionMetadata(req.SDFSFileName, handles[0].Version); err == nil {
+		resp.Metadata = &meta
+	}
+	err = resp.Send(conn)
 
 	if err != nil {
 		mp3util.NodeLogger.Error("Could not send response back to the client! Error: ", err)
@@ -360,7 +481,7 @@ func (r *ReplicaService) DataConnHandleCLIENTSENDFILEDATA(conn net.Conn, req fsy
 		ResponseCode:    fsys.OK,
 		FileContentHash: hashName,
 	}
-	mp3util.NodeLogger.Debugf("We are sending back response: %v")
+	mp3util.NodeLogger.Debugf("We are sending back response: %v", response)
 	err = (&response).Send(conn)
 	if err != nil {
 		mp3util.NodeLogger.Error("Could not communicate with client: ", err)
@@ -368,6 +489,139 @@ func (r *ReplicaService) DataConnHandleCLIENTSENDFILEDATA(conn net.Conn, req fsy
 	return nil
 }
 
+/*
+Resumable put, step 1: the client tells us how big the (gzipped) upload is going to be, and we hand back a session ID.
+If it gives us a chain, we begin the upload on the next replica in it before answering, so it's ready for the chunks we
+forward (see chain.go).
+*/
+func (r *ReplicaService) DataConnHandleCLIENTUPLOADBEGIN(conn net.Conn, req fsys.TCPChannelRequest) error {
+	defer conn.Close()
+	id, err := r.sdfs.BeginUpload(req.SDFSFileName, req.FileSize)
+	if err != nil {
+		mp3util.NodeLogger.Error("Couldn't begin upload: ", err)
+		fsys.TrySendTCPChannelResponseError(conn, fsys.MISC_ERROR)
+		return err
+	}
+	if len(req.Chain) > 0 {
+		r.beginChain(id, req)
+	}
+	return (&fsys.TCPChannelResponse{ResponseCode: fsys.OK, UploadID: id}).Send(conn)
+}
+
+/*
+Resumable put, after a dropped connection: where should the client pick up from? FILE_NOT_FOUND means the session is
+gone (expired, or we were wiped) and the client has to begin again.
+*/
+func (r *ReplicaService) DataConnHandleCLIENTUPLOADRESUME(conn net.Conn, req fsys.TCPChannelRequest) error {
+	defer conn.Close()
+	offset, err := r.sdfs.UploadOffset(req.UploadID)
+	if err != nil {
+		fsys.TrySendTCPChannelResponseError(conn, fsys.FILE_NOT_FOUND)
+		return err
+	}
+	r.resumeChain(req.UploadID, offset)
+	return (&fsys.TCPChannelResponse{ResponseCode: fsys.OK, UploadOffset: offset}).Send(conn)
+}
+
+/*
+Resumable put, the actual data: a stream of CLIENT_UPLOAD_CHUNK requests on one connection, each followed by its
+bytes and answered with the offset we have now, optionally ending in a CLIENT_UPLOAD_COMMIT. A chunk that doesn't
+start at our offset or doesn't match its checksum is answered with BAD_REQUEST/CONTENT_HASH_MISMATCH plus the offset
+to resend from. The connection may drop at any point; nothing we've acknowledged is lost.
+*/
+func (r *ReplicaService) DataConnHandleCLIENTUPLOADCHUNK(conn net.Conn, req fsys.TCPChannelRequest) error {
+	defer conn.Close()
+	defer r.pauseChain(req.UploadID)
+	for {
+		if req.RequestType == fsys.CLIENT_UPLOAD_COMMIT {
+			return r.handleUploadCommit(conn, req)
+		}
+		if req.RequestType != fsys.CLIENT_UPLOAD_CHUNK {
+			fsys.TrySendTCPChannelResponseError(conn, fsys.BAD_REQUEST)
+			return errors.New(fmt.Sprintf("unexpected %v in the middle of an upload", req.RequestType))
+		}
+		offset, err := r.writeChainedUploadChunk(req, conn)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			mp3util.NodeLogger.Infof("Upload %v connection dropped mid-chunk at %v, the client can resume.", req.UploadID, req.ChunkOffset)
+			return nil
+		}
+		resp := &fsys.TCPChannelResponse{ResponseCode: fsys.OK, UploadOffset: offset}
+		switch err {
+		case nil:
+		case fsys.ErrWrongUploadOffset:
+			resp.ResponseCode = fsys.BAD_REQUEST
+		case fsys.ErrContentHashMismatch:
+			resp.ResponseCode = fsys.CORRUPT
+		case fsys.ErrUnknownUpload:
+			resp.ResponseCode = fsys.FILE_NOT_FOUND
+		default:
+			mp3util.NodeLogger.Errorf("Couldn't take chunk at %v of upload %v! Error: %v", req.ChunkOffset, req.UploadID, err)
+			resp.ResponseCode = fsys.MISC_ERROR
+		}
+		err = resp.Send(conn)
+		if err != nil {
+			return err
+		}
+		next, err := fsys.RecvTCPChannelRequest(conn)
+		if err != nil {
+			mp3util.NodeLogger.Infof("Upload %v connection went away at offset %v, the client can resume.", req.UploadID, offset)
+			return nil
+		}
+		req = *next
+	}
+}
+
+func (r *ReplicaService) DataConnHandleCLIENTUPLOADCOMMIT(conn net.Conn, req fsys.TCPChannelRequest) error {
+	defer conn.Close()
+	return r.handleUploadCommit(conn, req)
+}
+
+/*
+Resumable put, done: the upload becomes a tmpfile, and like with CLIENT_SEND_FILE_DATA, its name goes back to the
+client for FinalizeWrite. If we were forwarding it, the rest of the chain commits at the same time, and the client also
+hears how many of them (and us) got there.
+*/
+func (r *ReplicaService) handleUploadCommit(conn net.Conn, req fsys.TCPChannelRequest) error {
+	type chainCommit struct {
+		committed int
+		hashName  string
+	}
+	downstream := make(chan chainCommit, 1)
+	go func() {
+		committed, hashName := r.commitChain(req.UploadID)
+		downstream <- chainCommit{committed, hashName}
+	}()
+	hashName, err := r.sdfs.CommitUpload(req.UploadID)
+	chained := <-downstream
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't commit upload %v! Error: %v", req.UploadID, err)
+		fsys.TrySendTCPChannelResponseError(conn, fsys.MISC_ERROR)
+		return err
+	}
+	resp := &fsys.TCPChannelResponse{ResponseCode: fsys.OK, FileContentHash: hashName, ChainCommitted: 1}
+	if chained.committed > 0 && chained.hashName == hashName {
+		resp.ChainCommitted += chained.committed
+	} else if chained.committed > 0 {
+		mp3util.NodeLogger.Warnf("The rest of the chain committed upload %v as %v, but we have %v!", req.UploadID, chained.hashName, hashName)
+	}
+	return resp.Send(conn)
+}
+
+/*
+Resumable put, called off: the client got what it needed elsewhere (or gave up), so the upload can go now instead of
+waiting to expire.
+*/
+func (r *ReplicaService) DataConnHandleCLIENTUPLOADABORT(conn net.Conn, req fsys.TCPChannelRequest) error {
+	defer conn.Close()
+	r.abortChain(req.UploadID)
+	err := r.sdfs.AbortUpload(req.UploadID)
+	if err != nil {
+		fsys.TrySendTCPChannelResponseError(conn, fsys.FILE_NOT_FOUND)
+		return err
+	}
+	return (&fsys.TCPChannelResponse{ResponseCode: fsys.OK}).Send(conn)
+}
+
 func (r *ReplicaService) DataConnHandleCLIENTLISTFILES(conn net.Conn, _ fsys.TCPChannelRequest) error {
 	files, err := r.sdfs.ListDirectory()
 	if err != nil {
@@ -385,11 +639,56 @@ func (r *ReplicaService) DataConnHandleCLIENTLISTFILES(conn net.Conn, _ fsys.TCP
 	return nil
 }
 
+/*
+Answers "why is this version here?" for one file, straight out of the journal.
+*/
+func (r *ReplicaService) DataConnHandleCLIENTREQHISTORY(conn net.Conn, req fsys.TCPChannelRequest) error {
+	defer conn.Close()
+	records, tombstone := r.sdfs.History(req.SDFSFileName)
+	err := (&fsys.TCPChannelResponse{
+		ResponseCode:   fsys.OK,
+		VersionHistory: records,
+		Tombstone:      tombstone,
+	}).Send(conn)
+	if err != nil {
+		mp3util.NodeLogger.Error("Unable to send response! Error: ", err)
+		return err
+	}
+	return nil
+}
+
+/*
+setattr: changes the attributes of one version we have (see fsys/attributes.go).
+*/
+func (r *ReplicaService) DataConnHandleCLIENTSETATTRS(conn net.Conn, req fsys.TCPChannelRequest) error {
+	defer conn.Close()
+	var changes map[string]string
+	if req.Attributes != nil {
+		changes = req.Attributes.Attrs
+	}
+	meta, err := r.sdfs.SetVersionAttributes(req.SDFSFileName, req.SDFSFileVersion, changes)
+	resp := &fsys.TCPChannelResponse{ResponseCode: fsys.OK, Metadata: &meta}
+	if os.IsNotExist(err) {
+		resp = &fsys.TCPChannelResponse{ResponseCode: fsys.FILE_NOT_FOUND}
+	} else if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't set attributes of %v @ %v: %v", req.SDFSFileName, req.SDFSFileVersion, err)
+		resp = &fsys.TCPChannelResponse{ResponseCode: fsys.BAD_REQUEST}
+	}
+	return handleTCPChannelRequestErr(resp.Send(conn))
+}
+
 func (r *ReplicaService) DataConnHandleMASTERFINALIZEWRITE(conn net.Conn, req fsys.TCPChannelRequest) error {
 	defer conn.Close()
 
-	version := time.Unix(0, req.SDFSFileVersion)
-	err := r.sdfs.RegisterTmpfileToSDFS(req.FileContentHash, version, req.SDFSFileName)
+	version := req.SDFSFileVersion
+	var attrs fsys.VersionAttributes
+	if req.Attributes != nil {
+		attrs = *req.Attributes
+	}
+	err := r.sdfs.RegisterTmpfileToSDFS(req.FileContentHash, version, req.SDFSFileName, fsys.VersionOrigin{
+		Writer: req.Writer,
+		Reason: fmt.Sprintf("client write finalized by master at %v", conn.RemoteAddr()),
+	}, attrs)
 	resp := &fsys.TCPChannelResponse{ResponseCode: fsys.OK}
 	if err != nil {
 		mp3util.NodeLogger.Error("Replica registerToSDFS error: ", err)
@@ -398,6 +697,30 @@ func (r *ReplicaService) DataConnHandleMASTERFINALIZEWRITE(conn net.Conn, req fs
 	return handleTCPChannelRequestErr(resp.Send(conn))
 }
 
+/*
+The master couldn't finalize a write on some of its quorum, but we have it: push it to the ones that missed it, and
+hold a hint for any we can't reach right now (see DeliverHints). Also used for read repair, when a client notices other
+replicas are behind us.
+*/
+func (r *ReplicaService) DataConnHandleMASTERREPAIRWRITE(conn net.Conn, req fsys.TCPChannelRequest) error {
+	defer conn.Close()
+	versionSet := fsys.SDFSFileVersionSet{req.SDFSFileName: {req.SDFSFileVersion: true}}
+	resp := &fsys.TCPChannelResponse{ResponseCode: fsys.OK}
+	for _, target := range req.RepairTargets {
+		err := r.offerFileSetToReplica(versionSet, ReplicaMetadata{Address: target.Address, MemberId: target.MemberId})
+		if err != nil {
+			mp3util.NodeLogger.Warnf("Couldn't repair %v @ %v onto replica %v: %v", req.SDFSFileName, req.SDFSFileVersion, target.MemberId, err)
+			if req.RequestType == fsys.MASTER_REPAIR_WRITE {
+				err = r.sdfs.StoreHint(req.SDFSFileName, req.SDFSFileVersion, target)
+			}
+			if err != nil {
+				resp.ResponseCode = fsys.MISC_ERROR
+			}
+		}
+	}
+	return handleTCPChannelRequestErr(resp.Send(conn))
+}
+
 func handleTCPChannelRequestErr(err error) error {
 	if err != nil {
 		mp3util.NodeLogger.Error("Replica TCPChannelRequest command failed! Error: ", err)
@@ -408,7 +731,7 @@ func handleTCPChannelRequestErr(err error) error {
 
 func (r *ReplicaService) DataConnHandleMASTERFINALIZEDELETE(conn net.Conn, req fsys.TCPChannelRequest) error {
 	defer conn.Close()
-	timestamp := time.Unix(0, req.SDFSFileVersion)
+	timestamp := req.SDFSFileVersion
 	resp := &fsys.TCPChannelResponse{ResponseCode: fsys.OK}
 
 	fileExists, err := r.sdfs.RemoveSDFSFile(req.SDFSFileName, timestamp)
@@ -429,6 +752,34 @@ func (r *ReplicaService) DataConnHandleMASTERFINALIZEDELETE(conn net.Conn, req f
 	return handleTCPChannelRequestErr(resp.Send(conn))
 }
 
+/*
+Votes and heartbeats for the master election (see election.go).
+*/
+func (r *ReplicaService) DataConnHandleELECTION(conn net.Conn, req fsys.TCPChannelRequest) error {
+	defer conn.Close()
+	var resp *fsys.TCPChannelResponse
+	if req.RequestType == fsys.ELECTION_REQUEST_VOTE {
+		resp = r.election.HandleRequestVote(req)
+	} else {
+		resp = r.election.HandleHeartbeat(req)
+	}
+	return handleTCPChannelRequestErr(resp.Send(conn))
+}
+
+/*
+Turns away requests from a master whose term is over. Returns whether it did.
+*/
+func (r *ReplicaService) refuseStaleMaster(conn net.Conn, req fsys.TCPChannelRequest) bool {
+	if r.election.AcceptMasterTerm(req.Term) {
+		return false
+	}
+	defer conn.Close()
+	_, term := r.election.Leader()
+	mp3util.NodeLogger.Warnf("Refusing %v from a master of term %v, we're in term %v", req.RequestType, req.Term, term)
+	handleTCPChannelRequestErr((&fsys.TCPChannelResponse{ResponseCode: fsys.STALE_TERM, Term: term}).Send(conn))
+	return true
+}
+
 func (r *ReplicaService) DataConnAccept(conn *net.Conn) {
 	req, err := fsys.RecvTCPChannelRequest(*conn)
 	if err != nil {
@@ -436,6 +787,12 @@ func (r *ReplicaService) DataConnAccept(conn *net.Conn) {
 		return
 	}
 	switch req.RequestType {
+	case fsys.MASTER_FINALIZE_WRITE, fsys.MASTER_FINALIZE_DELETE, fsys.MASTER_REPAIR_WRITE:
+		if r.refuseStaleMaster(*conn, *req) {
+			return
+		}
+	}
+	switch req.RequestType {
 	case fsys.CLIENT_REQ_FILE_METADATA:
 		err = r.DataConnHandleCLIENTREQFILEMETADATA(*conn, *req)
 		if err != nil {
@@ -448,18 +805,66 @@ func (r *ReplicaService) DataConnAccept(conn *net.Conn) {
 			mp3util.NodeLogger.Error("DataConnHandleCLIENTREQFILEDATA failed. Error: ", err)
 			return
 		}
+	case fsys.CLIENT_REQ_FILE_RANGE: // GetRange: send part of the file to client
+		err = r.DataConnHandleCLIENTREQFILERANGE(*conn, *req)
+		if err != nil {
+			mp3util.NodeLogger.Error("DataConnHandleCLIENTREQFILERANGE failed. Error: ", err)
+			return
+		}
 	case fsys.CLIENT_SEND_FILE_DATA: // PutFile: get the data from the client
 		err = r.DataConnHandleCLIENTSENDFILEDATA(*conn, *req)
 		if err != nil {
 			mp3util.NodeLogger.Error("DataConnHandleCLIENTSENDFILEDATA failed. Error: ", err)
 			return
 		}
+	case fsys.CLIENT_UPLOAD_BEGIN:
+		err = r.DataConnHandleCLIENTUPLOADBEGIN(*conn, *req)
+		if err != nil {
+			mp3util.NodeLogger.Error("DataConnHandleCLIENTUPLOADBEGIN failed. Error: ", err)
+			return
+		}
+	case fsys.CLIENT_UPLOAD_RESUME:
+		err = r.DataConnHandleCLIENTUPLOADRESUME(*conn, *req)
+		if err != nil {
+			mp3util.NodeLogger.Error("DataConnHandleCLIENTUPLOADRESUME failed. Error: ", err)
+			return
+		}
+	case fsys.CLIENT_UPLOAD_CHUNK:
+		err = r.DataConnHandleCLIENTUPLOADCHUNK(*conn, *req)
+		if err != nil {
+			mp3util.NodeLogger.Error("DataConnHandleCLIENTUPLOADCHUNK failed. Error: ", err)
+			return
+		}
+	case fsys.CLIENT_UPLOAD_COMMIT:
+		err = r.DataConnHandleCLIENTUPLOADCOMMIT(*conn, *req)
+		if err != nil {
+			mp3util.NodeLogger.Error("DataConnHandleCLIENTUPLOADCOMMIT failed. Error: ", err)
+			return
+		}
+	case fsys.CLIENT_UPLOAD_ABORT:
+		err = r.DataConnHandleCLIENTUPLOADABORT(*conn, *req)
+		if err != nil {
+			mp3util.NodeLogger.Error("DataConnHandleCLIENTUPLOADABORT failed. Error: ", err)
+			return
+		}
 	case fsys.CLIENT_LIST_FILES:
 		err := r.DataConnHandleCLIENTLISTFILES(*conn, *req)
 		if err != nil {
 			mp3util.NodeLogger.Error("DataConnHandleCLIENTLISTFILES. Error: ", err)
 			return
 		}
+	case fsys.CLIENT_REQ_HISTORY:
+		err := r.DataConnHandleCLIENTREQHISTORY(*conn, *req)
+		if err != nil {
+			mp3util.NodeLogger.Error("DataConnHandleCLIENTREQHISTORY. Error: ", err)
+			return
+		}
+	case fsys.CLIENT_SET_ATTRS:
+		err := r.DataConnHandleCLIENTSETATTRS(*conn, *req)
+		if err != nil {
+			mp3util.NodeLogger.Error("DataConnHandleCLIENTSETATTRS. Error: ", err)
+			return
+		}
 	case fsys.MASTER_FINALIZE_WRITE:
 		err := r.DataConnHandleMASTERFINALIZEWRITE(*conn, *req)
 		if err != nil {
@@ -472,6 +877,24 @@ func (r *ReplicaService) DataConnAccept(conn *net.Conn) {
 			mp3util.NodeLogger.Error("DataConnHandleMASTERFINALIZEDELETE. Error: ", err)
 			return
 		}
+	case fsys.MASTER_REPAIR_WRITE, fsys.CLIENT_READ_REPAIR:
+		err = r.DataConnHandleMASTERREPAIRWRITE(*conn, *req)
+		if err != nil {
+			mp3util.NodeLogger.Error("DataConnHandleMASTERREPAIRWRITE failed. Error: ", err)
+			return
+		}
+	case fsys.REPLICA_MERKLE_NODES, fsys.REPLICA_MERKLE_LEAVES:
+		err = r.DataConnHandleREPLICAMERKLE(*conn, *req)
+		if err != nil {
+			mp3util.NodeLogger.Error("DataConnHandleREPLICAMERKLE failed. Error: ", err)
+			return
+		}
+	case fsys.ELECTION_REQUEST_VOTE, fsys.ELECTION_HEARTBEAT:
+		err = r.DataConnHandleELECTION(*conn, *req)
+		if err != nil {
+			mp3util.NodeLogger.Error("DataConnHandleELECTION failed. Error: ", err)
+			return
+		}
 	case fsys.CLIENT_REQ_KVERSIONS:
 		err := r.DataConnHandleCLIENTREQKVERSIONS(*conn, *req)
 		if err != nil {
@@ -519,6 +942,10 @@ func (r *ReplicaService) Run() {
 	mp3util.NodeLogger.Info("Started Dataconn TCP for replica")
 	go r.ReplicaDaemon()
 	mp3util.NodeLogger.Info("Started Replica Daemon")
+	go r.ScrubDaemon()
+	mp3util.NodeLogger.Info("Started scrubber")
+	go r.election.Run()
+	mp3util.NodeLogger.Info("Started master election")
 
 	// go r.RunGRPC()
 	// mp3util.NodeLogger.Info("Started GRPC server for replica")
@@ -549,6 +976,31 @@ func UnicastToReplica(req *fsys.TCPChannelRequest, r ReplicaMetadata) (*fsys.TCP
 	return resp, nil
 }
 
+/*
+Like UnicastToReplica, but responses that aren't OK come back too (a STALE_TERM one has the replica's term in it), and
+if timeout isn't 0, the whole thing gives up after that long. Doesn't log anything: the callers know better whether a
+member not answering is news.
+*/
+func unicastToReplicaAnyCode(req *fsys.TCPChannelRequest, r ReplicaMetadata, timeout time.Duration) (*fsys.TCPChannelResponse, error) {
+	dialTimeout := config.DEFAULT_TCP_TIMEOUT
+	if timeout > 0 {
+		dialTimeout = timeout
+	}
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", r.Address, config.MP3_REPLICA_TCP_PORT), dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	err = req.Send(conn)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.RecvTCPChannelResponseAnyCode(conn)
+}
+
 /*
 TODO: Replication for cases:
 (
@@ -568,7 +1020,7 @@ TODO: Replication for cases:
 - Maybe we can do something clever to transfer the files to all replicas?IDK bruh bruh nananana
 
 (responder)
-- Gets a request from initiator that they detected some failures and want to send files or some bruh 
+- Gets a request from initiator that they detected some failures and want to send files or some bruh
 - Responder is like "OK i want these ones"
 	- To make sure the responder doesn't get the SAME file-version multiple times (connections from multiple initiators)
 		, we might have a lock to a shared variable that indicates whether we're already downloading
@@ -583,16 +1035,19 @@ func (r *ReplicaService) SendFileSetToReplica(conn net.Conn, requested fsys.SDFS
 	for filename, versions := range requested {
 		for version, _ := range versions {
 
-			fileHandles, err := r.sdfs.AcquireFileHandles(1, filename, time.Unix(0, version))
+			manifest, err := r.sdfs.ReadManifest(filename, version)
 			if err != nil {
-				mp3util.NodeLogger.Warn("Failed to acquire file handle for file %v", filename)
-				fsys.CloseHandles(fileHandles)
+				mp3util.NodeLogger.Warnf("Failed to read manifest for file %v @ %v", filename, version)
 				continue
 			}
-
-			fileSize := fileHandles[0].FileSize
-			fd := fileHandles[0].Handle
-			defer fd.Close()
+			meta, err := r.sdfs.ReadVersionMetadata(filename, version)
+			if err != nil {
+				mp3util.NodeLogger.Warnf("Failed to read metadata for file %v @ %v", filename, version)
+				continue
+			}
+			fileSize := manifest.CompressedSize
+			// Pass the original writer along, so the peer's journal doesn't think we wrote it.
+			record, _ := r.sdfs.VersionRecord(filename, version)
 
 			mp3util.NodeLogger.Debugf("Sending request to send file %v, version %v, file size %v to replica %v",
 				filename, version, fileSize, replica)
@@ -603,6 +1058,10 @@ func (r *ReplicaService) SendFileSetToReplica(conn net.Conn, requested fsys.SDFS
 				SDFSFileName:    filename,
 				SDFSFileVersion: version,
 				FileSize:        fileSize,
+				FileContentHash: meta.ContentHash,
+				Writer:          record.Writer,
+				Manifest:        &manifest,
+				Attributes:      &meta.VersionAttributes,
 			}).Send(conn)
 
 			if err != nil {
@@ -611,22 +1070,55 @@ func (r *ReplicaService) SendFileSetToReplica(conn net.Conn, requested fsys.SDFS
 				return err
 			}
 
-			_, err = fsys.RecvTCPChannelResponse(conn)
+			resp, err := fsys.RecvTCPChannelResponse(conn)
 			if err != nil {
 				mp3util.NodeLogger.Errorf("Did not get OK from replica with ID=%v at addr=%v: %v !\n", replica.MemberId, replica.Address, err)
 				return err
 			}
+			if resp.ResponseCode != fsys.OK {
+				return errors.New(fmt.Sprintf("replica refused %v @ %v: %v", filename, version, resp.ResponseCode))
+			}
 
-			/* Now send the entire compressed file over to the replica */
-			mp3util.NodeLogger.Debugf("Sending entire file %v, version %v, file size %v to replica %v",
-				filename, version, fileSize, replica)
-			nbytes, err := io.Copy(conn, fd)
-			if err != nil {
-				mp3util.NodeLogger.Errorf("Unable to write all bytes to the connection, only wrote %v bytes! Error: %v", nbytes, err)
-				return err
+			/* Now send over only the chunks the replica doesn't already have */
+			mp3util.NodeLogger.Debugf("Sending %v of %v chunks of file %v, version %v to replica %v",
+				len(resp.MissingChunks), len(manifest.Chunks), filename, version, replica)
+			var nbytes int64
+			for _, hash := range resp.MissingChunks {
+				size, ok := manifest.CompressedSizeOf(hash)
+				if !ok {
+					return errors.New(fmt.Sprintf("replica asked for chunk %v which isn't part of %v @ %v", hash, filename, version))
+				}
+				fd, err := r.sdfs.OpenChunk(hash)
+				if err != nil {
+					mp3util.NodeLogger.Errorf("Couldn't open chunk %v of %v @ %v! Error: %v", hash, filename, version, err)
+					return err
+				}
+				n, err := io.CopyN(conn, fd, size)
+				fd.Close()
+				nbytes += n
+				if err != nil {
+					mp3util.NodeLogger.Errorf("Unable to write all bytes to the connection, only wrote %v bytes! Error: %v", nbytes, err)
+					return err
+				}
 			}
 
 			mp3util.NodeLogger.Debugf("Copied %v bytes to replica %v", nbytes, replica)
+
+			verdict, err := fsys.RecvTCPChannelResponseAnyCode(conn)
+			if err != nil {
+				mp3util.NodeLogger.Errorf("Replica %v never said whether it took %v @ %v: %v", replica.MemberId, filename, version, err)
+				return err
+			}
+			if verdict.ResponseCode == fsys.CORRUPT {
+				// Either a chunk we sent or the whole didn't hash right. Most likely it's our copy that's bad (the network
+				// has its own checksums), so don't keep offering it around if it is.
+				mp3util.NodeLogger.Errorf("Replica %v rejected %v @ %v as corrupt. Checking our own copy...", replica.MemberId, filename, version)
+				if err := r.sdfs.VerifyVersion(filename, version); err != nil {
+					r.sdfs.QuarantineVersion(filename, version, fmt.Sprintf("rejected as corrupt by replica %v", replica.MemberId))
+				}
+			} else if verdict.ResponseCode != fsys.OK {
+				mp3util.NodeLogger.Warnf("Replica %v couldn't store %v @ %v: %v", replica.MemberId, filename, version, verdict.ResponseCode)
+			}
 		}
 	}
 	return nil
@@ -640,12 +1132,21 @@ func (r *ReplicaService) Replicate() error {
 		mp3util.NodeLogger.Warn("Failed to get version set for file")
 	}
 
+	/* What RecoverSDFSStorage kept at boot is just part of myVersionSet, so it needs no offer of its own: peers'
+	 * IdentifyDesiredFiles only asks for what they lack, and when THEY offer to us, ours already counts the recovered
+	 * versions, so only the missing ones cross the network. */
+	if config.ANTI_ENTROPY {
+		// The trees find what the peers are missing on their own, no need to send anybody our whole version set.
+		return r.AntiEntropy()
+	}
+
 	visitedReplicas := make(map[ReplicaMetadata]bool)
+	membership := schema.Membership()
 
 	for fileName := range myVersionSet {
-		partition, err := schema.RunPartitioner(&proto.FileInfo{
+		partition, err := schema.Partition(schema.ConfiguredPlacement(), &proto.FileInfo{
 			Sdfsname: fileName,
-		})
+		}, membership)
 		if err != nil {
 			mp3util.NodeLogger.Errorf("Partitioner failed for filename %v", fileName)
 		}
@@ -658,62 +1159,66 @@ func (r *ReplicaService) Replicate() error {
 				continue
 			}
 
-			req := &fsys.TCPChannelRequest{
-				RequestType:    fsys.REPLICA_QUERY_FILES,
-				FileVersionSet: myVersionSet,
-			}
-
-			mp3util.NodeLogger.Debugf("Replicate: Unicast REPLICA_QUERY_FILES to replica with ID=%v at addr=%v\n", replica.MemberId, replica.Address)
-			conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", replica.Address, config.MP3_REPLICA_TCP_PORT), config.DEFAULT_TCP_TIMEOUT)
-
+			err = r.offerFileSetToReplica(myVersionSet, replica)
 			if err != nil {
-				mp3util.NodeLogger.Errorf("Replicate couldn't connect to replica with ID=%v at addr=%v: %v !\n", replica.MemberId, replica.Address, err)
-				if err != nil {
-					mp3util.NodeLogger.Error("Failed to close conn", err)
-				}
 				continue
 			}
 
-			/* Ask replica to return set of files that the replica desires */
-			err = req.Send(conn)
-			if err != nil {
-				mp3util.NodeLogger.Errorf("Couldn't send request to replica with ID=%v at addr=%v: %v !\n", replica.MemberId, replica.Address, err)
-				err := conn.Close()
-				if err != nil {
-					mp3util.NodeLogger.Error("Failed to close conn", err)
-				}
-				continue
-			}
+			visitedReplicas[replica] = true
+		}
+	}
 
-			resp, err := fsys.RecvTCPChannelResponse(conn)
-			if err != nil {
-				mp3util.NodeLogger.Errorf("Did not get OK from replica with ID=%v at addr=%v: %v !\n", replica.MemberId, replica.Address, err)
-				err := conn.Close()
-				if err != nil {
-					mp3util.NodeLogger.Error("Failed to close conn", err)
-				}
-				continue
-			}
+	return nil
+}
 
-			requestedFiles := resp.RequestedFileVersionSet
-			err = r.SendFileSetToReplica(conn, requestedFiles, replica)
-			if err != nil {
-				mp3util.NodeLogger.Warnf("Could not send requested file version set to replica %v", replica)
-				continue
-			}
+/*
+Offers versionSet to replica, and sends it whichever of those versions it says it wants.
+*/
+func (r *ReplicaService) offerFileSetToReplica(versionSet fsys.SDFSFileVersionSet, replica ReplicaMetadata) error {
+	req := &fsys.TCPChannelRequest{
+		RequestType:    fsys.REPLICA_QUERY_FILES,
+		FileVersionSet: versionSet,
+	}
 
-			visitedReplicas[replica] = true
-			if err := conn.Close(); err != nil {
-				mp3util.NodeLogger.Error("Failed to close conn", err)
-			}
+	mp3util.NodeLogger.Debugf("Replicate: Unicast REPLICA_QUERY_FILES to replica with ID=%v at addr=%v\n", replica.MemberId, replica.Address)
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", replica.Address, config.MP3_REPLICA_TCP_PORT), config.DEFAULT_TCP_TIMEOUT)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Replicate couldn't connect to replica with ID=%v at addr=%v: %v !\n", replica.MemberId, replica.Address, err)
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			mp3util.NodeLogger.Error("Failed to close conn", err)
 		}
+	}()
+
+	/* Ask replica to return set of files that the replica desires */
+	err = req.Send(conn)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Couldn't send request to replica with ID=%v at addr=%v: %v !\n", replica.MemberId, replica.Address, err)
+		return err
+	}
+
+	resp, err := fsys.RecvTCPChannelResponse(conn)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("Did not get OK from replica with ID=%v at addr=%v: %v !\n", replica.MemberId, replica.Address, err)
+		return err
 	}
 
+	requestedFiles := resp.RequestedFileVersionSet
+	err = r.SendFileSetToReplica(conn, requestedFiles, replica)
+	if err != nil {
+		mp3util.NodeLogger.Warnf("Could not send requested file version set to replica %v", replica)
+		return err
+	}
 	return nil
 }
 
 func (r *ReplicaService) ReplicaDaemon() {
 	t1 := time.NewTimer(config.GARBAGE_COLLECTION_PERIOD)
+	t4 := time.NewTimer(config.HINT_DELIVERY_PERIOD)
+	t5 := time.NewTimer(config.ANTI_ENTROPY_PERIOD)
+	t6 := time.NewTimer(config.RETENTION_PERIOD)
 	//t2 := time.NewTimer(config.PASSIVE_REPLICATION_PERIOD)
 	for {
 		select {
@@ -725,6 +1230,32 @@ func (r *ReplicaService) ReplicaDaemon() {
 			}
 			t1 = time.NewTimer(config.GARBAGE_COLLECTION_PERIOD) // Tick again
 
+		case <-t4.C:
+			err := r.DeliverHints()
+			t4.Stop()
+			if err != nil {
+				mp3util.NodeLogger.Warn("Failed to deliver hints: ", err)
+			}
+			t4 = time.NewTimer(config.HINT_DELIVERY_PERIOD)
+
+		case <-t5.C:
+			if config.ANTI_ENTROPY {
+				err := r.AntiEntropy()
+				if err != nil {
+					mp3util.NodeLogger.Warn("Failed anti-entropy: ", err)
+				}
+			}
+			t5.Stop()
+			t5 = time.NewTimer(config.ANTI_ENTROPY_PERIOD)
+
+		case <-t6.C:
+			err := r.EnforceRetention()
+			t6.Stop()
+			if err != nil {
+				mp3util.NodeLogger.Warn("Failed to enforce retention: ", err)
+			}
+			t6 = time.NewTimer(config.RETENTION_PERIOD)
+
 			//case <-t2.C:
 			//	err := r.Replicate()
 			//	t2.Stop() // Avoid weird edge cases
@@ -738,13 +1269,11 @@ func (r *ReplicaService) ReplicaDaemon() {
 }
 
 func (r *ReplicaService) GarbageCollect() error {
-	schema.MemList.Mtx.Lock()
-	self := ReplicaMetadata{
-		Address:  schema.MemList.SelfNode.Address,
-		MemberId: schema.MemList.SelfNode.Member_Id,
-		Port:     schema.MemList.SelfNode.Port,
-	}
-	schema.MemList.Mtx.Unlock()
+	/* One membership for the whole pass, so we don't decide ownership of half the files under one and half under another */
+	membership := schema.Membership()
+	self := replicaMetadataOf(membership.Self)
+	r.sdfs.ExpireUploads(config.UPLOAD_SESSION_TTL)
+	r.dropStaleChains()
 
 	inProgressReplicationJobs.mtx.Lock()
 	defer inProgressReplicationJobs.mtx.Unlock()
@@ -759,10 +1288,10 @@ func (r *ReplicaService) GarbageCollect() error {
 	}
 	for _, f := range files {
 		mp3util.NodeLogger.Debugf("Checking ownership of file: %v", f.SDFSFileName)
-		reps, err := schema.RunPartitioner(&proto.FileInfo{
+		reps, err := schema.Partition(schema.ConfiguredPlacement(), &proto.FileInfo{
 			Sdfsname:    f.SDFSFileName,
 			ContentHash: "",
-		})
+		}, membership)
 		if err != nil {
 			mp3util.NodeLogger.Errorf("Couldn't run partiioner on the local file! Error: %v", err)
 			return err
@@ -777,7 +1306,7 @@ func (r *ReplicaService) GarbageCollect() error {
 
 		if !ownerOfFile {
 			mp3util.NodeLogger.Debugf("No longer the owner of file %v. Deleting now...", f.SDFSFileName)
-			fileExists, err := r.sdfs.RemoveSDFSFile(f.SDFSFileName, time.Now())
+			fileExists, err := r.sdfs.GarbageCollectSDFSFile(f.SDFSFileName)
 			if err != nil {
 				mp3util.NodeLogger.Errorf("Failed to clean up sdfs file %v: err = %v", f.SDFSFileName, err)
 				return err
@@ -0,0 +1,44 @@
+package amogus
+
+import (
+	"amogus/fsys"
+	"amogus/mp3util"
+	"fmt"
+	"os"
+	"time"
+)
+
+/*
+The retention enforcer: every config.RETENTION_PERIOD, drops the versions we store that their file's retention policy
+(see fsys/retention.go) doesn't keep. Replication already won't take versions the policy wouldn't keep, but writes and
+replication only ever add versions, so without this a replica would hold on to everything it ever got.
+*/
+func (r *ReplicaService) EnforceRetention() error {
+	inProgressReplicationJobs.mtx.Lock()
+	defer inProgressReplicationJobs.mtx.Unlock()
+
+	versionSet, err := r.sdfs.ListStoredSDFSFilesAllVersions()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // Nothing stored, nothing to drop.
+		}
+		return err
+	}
+	now := time.Now()
+	for name, versions := range versionSet {
+		path := r.sdfs.RetentionPath(name, versions)
+		policy := fsys.RetentionPolicyFor(path)
+		keep := policy.Retain(versions, now)
+		for version := range versions {
+			if keep[version] {
+				continue
+			}
+			mp3util.NodeLogger.Debugf("Retention policy for %v doesn't keep %v @ %v. Dropping it.", path, name, version)
+			err := r.sdfs.DiscardVersion(name, version, fmt.Sprintf("not kept by the retention policy for %v", path))
+			if err != nil {
+				mp3util.NodeLogger.Warnf("Couldn't drop %v @ %v! Error: %v", name, version, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package schema
+
+import (
+	"amogus/config"
+	"amogus/mp3util"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+/*
+Chord-style placement: every member has VNODES_PER_MEMBER tokens on a RING_SIZE-bit ring, a file goes to the member
+with the first token at or after the file's ring ID, and its other replicas are the next distinct members clockwise.
+*/
+type ChordPlacement struct {
+	key    string // VNODES_PER_MEMBER and the membership Key tokens were built for
+	tokens []RingToken
+	mtx    sync.Mutex
+}
+
+/*
+One of a member's points on the ring. With virtual nodes every member has several of them, so ownership evens out and a
+join or leave takes a little from everybody instead of a lot from one or two neighbours.
+*/
+type RingToken struct {
+	Id     uint64
+	Member Member
+}
+
+func (c *ChordPlacement) Name() string {
+	return "chord"
+}
+
+func (c *ChordPlacement) Place(sdfsFileName string, membership *MembershipSnapshot) []Member {
+	tokens := c.ring(membership)
+	if len(tokens) == 0 {
+		return nil
+	}
+	fileHashId := GetRingId(sdfsFileName)
+
+	/* The file goes to the member with the first token at or after its id (wrapping around), and to the distinct
+	 * members of the tokens after that */
+	first := sort.Search(len(tokens), func(i int) bool {
+		return tokens[i].Id >= fileHashId
+	})
+	return successors(tokens, first%len(tokens))
+}
+
+/*
+The token ring for a membership. Building it means hashing every token, so it's kept around until the membership changes.
+*/
+func (c *ChordPlacement) ring(membership *MembershipSnapshot) []RingToken {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	key := fmt.Sprintf("%v/%v", config.VNODES_PER_MEMBER, membership.Key())
+	if c.tokens == nil || c.key != key {
+		c.tokens = buildTokenRing(membership.Members)
+		c.key = key
+		for _, tok := range c.tokens {
+			mp3util.NodeLogger.Tracef("Node with id: %v has a token at %x", tok.Member.Member_Id, tok.Id)
+		}
+	}
+	return c.tokens
+}
+
+/*
+How many tokens m gets: VNODES_PER_MEMBER, scaled by m's weight, but at least one.
+*/
+func MemberTokens(m Member) int {
+	n := int(math.Round(float64(config.VNODES_PER_MEMBER) * MemberWeight(m)))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+/*
+Where m's tokens are. The first one is GetRingId(Member_Id), i.e. where m sat on the ring before there were virtual
+nodes, so VNODES_PER_MEMBER = 1 gives the old placement.
+*/
+func TokenIds(m Member) []uint64 {
+	ids := []uint64{GetRingId(m.Member_Id)}
+	for i := 1; i < MemberTokens(m); i++ {
+		ids = append(ids, GetRingId(fmt.Sprintf("%v#%v", m.Member_Id, i)))
+	}
+	return ids
+}
+
+/*
+Every member's tokens, sorted by where they are on the ring.
+*/
+func buildTokenRing(members []Member) []RingToken {
+	var tokens []RingToken
+	for _, m := range members {
+		for _, id := range TokenIds(m) {
+			tokens = append(tokens, RingToken{Id: id, Member: m})
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Id != tokens[j].Id {
+			return tokens[i].Id < tokens[j].Id
+		}
+		return tokens[i].Member.Member_Id < tokens[j].Member.Member_Id
+	})
+	return tokens
+}
+
+/*
+The replicas for a file that lands on tokens[first]: walk clockwise from there, and take NUM_REPLICAS distinct members
+(or all of them, if there are fewer), spread over as many failure domains as we can. Those come back in the order we
+walked past them, so the member at tokens[first] is always first.
+*/
+func successors(tokens []RingToken, first int) []Member {
+	var candidates []Member
+	seen := make(map[string]bool)
+	for i := 0; i < len(tokens); i++ {
+		memb := tokens[(first+i)%len(tokens)].Member
+		if seen[memb.Member_Id] {
+			continue
+		}
+		seen[memb.Member_Id] = true
+		candidates = append(candidates, memb)
+	}
+	return pickReplicas(candidates, config.NUM_REPLICAS)
+}
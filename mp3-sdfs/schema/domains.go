@@ -0,0 +1,118 @@
+package schema
+
+import (
+	"amogus/config"
+	"amogus/mp3util"
+	"amogus/proto"
+	"encoding/json"
+	"os"
+)
+
+/*
+Failure domains (zones, racks, whatever shares a power strip): a member's comes from Member.Zone if MP2 told us one,
+otherwise from config.FAILURE_DOMAINS. A member with neither is in a domain of its own, so with no labels at all
+placement is exactly the plain ring walk.
+*/
+func FailureDomain(m Member) string {
+	if m.Zone != "" {
+		return m.Zone
+	}
+	if zone, ok := config.FAILURE_DOMAINS[m.Address]; ok {
+		return zone
+	}
+	return "member:" + m.Address
+}
+
+/*
+The failure domain of a replica handed out by the partitioner, looked up in the current membership.
+*/
+func ReplicaDomain(rep *proto.ReplicaInfo) string {
+	if m, ok := Membership().Lookup(rep.Memberid); ok {
+		return FailureDomain(m)
+	}
+	return FailureDomain(Member{Member_Id: rep.Memberid, Address: rep.Name})
+}
+
+/*
+Reads the address -> failure domain map in path (a JSON object) into config.FAILURE_DOMAINS. No file is fine, that
+just means everybody is in their own domain.
+*/
+func LoadFailureDomains(path string) error {
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		mp3util.NodeLogger.Debugf("No failure domains file at %v", path)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	domains := make(map[string]string)
+	err = json.Unmarshal(contents, &domains)
+	if err != nil {
+		return err
+	}
+	config.FAILURE_DOMAINS = domains
+	mp3util.NodeLogger.Infof("Loaded failure domains for %v members from %v", len(domains), path)
+	return nil
+}
+
+/*
+Picks n of the candidates, which are in order of preference, taking the first one from every failure domain before
+taking a second from any. Returns the indexes of the picks, in the order the candidates were in.
+*/
+func pickAcrossDomains(domains []string, n int) []int {
+	picked := make([]bool, len(domains))
+	numPicked := 0
+	if config.SPREAD_ACROSS_DOMAINS {
+		usedDomains := make(map[string]bool)
+		for i, d := range domains {
+			if numPicked < n && !usedDomains[d] {
+				usedDomains[d] = true
+				picked[i] = true
+				numPicked++
+			}
+		}
+	}
+	for i := range domains {
+		if numPicked < n && !picked[i] {
+			picked[i] = true
+			numPicked++
+		}
+	}
+	var indexes []int
+	for i := range domains {
+		if picked[i] {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+/*
+Picks n of the candidate members (in order of preference) spread over as many failure domains as possible.
+*/
+func pickReplicas(candidates []Member, n int) []Member {
+	domains := make([]string, len(candidates))
+	for i, m := range candidates {
+		domains[i] = FailureDomain(m)
+	}
+	var picked []Member
+	for _, i := range pickAcrossDomains(domains, n) {
+		picked = append(picked, candidates[i])
+	}
+	return picked
+}
+
+/*
+Picks n of replicas (in order of preference) spread over as many failure domains as possible.
+*/
+func SpreadAcrossDomains(replicas []*proto.ReplicaInfo, n int) []*proto.ReplicaInfo {
+	domains := make([]string, len(replicas))
+	for i, rep := range replicas {
+		domains[i] = ReplicaDomain(rep)
+	}
+	var spread []*proto.ReplicaInfo
+	for _, i := range pickAcrossDomains(domains, n) {
+		spread = append(spread, replicas[i])
+	}
+	return spread
+}
@@ -0,0 +1,135 @@
+package schema
+
+import (
+	"amogus/config"
+	"amogus/proto"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPickAcrossDomains(t *testing.T) {
+	defer func(spread bool) { config.SPREAD_ACROSS_DOMAINS = spread }(config.SPREAD_ACROSS_DOMAINS)
+	tests := []struct {
+		domains []string
+		n       int
+		spread  bool
+		want    []int
+	}{
+		{[]string{"a", "a", "b", "b", "c"}, 3, true, []int{0, 2, 4}},
+		{[]string{"a", "a", "b", "b", "c"}, 4, true, []int{0, 1, 2, 4}},
+		{[]string{"a", "a", "b", "b", "c"}, 3, false, []int{0, 1, 2}},
+		{[]string{"a", "a", "a"}, 2, true, []int{0, 1}},
+		{[]string{"a", "b"}, 5, true, []int{0, 1}},
+		{[]string{"a", "b", "c"}, 0, true, nil},
+		{nil, 3, true, nil},
+	}
+	for _, test := range tests {
+		config.SPREAD_ACROSS_DOMAINS = test.spread
+		if got := pickAcrossDomains(test.domains, test.n); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("pickAcrossDomains(%v, %v) with spread %v = %v, want %v", test.domains, test.n, test.spread, got, test.want)
+		}
+	}
+}
+
+func TestFailureDomain(t *testing.T) {
+	defer func(domains map[string]string) { config.FAILURE_DOMAINS = domains }(config.FAILURE_DOMAINS)
+	config.FAILURE_DOMAINS = map[string]string{"10.0.0.1": "rack-1", "10.0.0.2": "rack-2"}
+	tests := []struct {
+		m    Member
+		want string
+	}{
+		{Member{Address: "10.0.0.1", Zone: "zone-a"}, "zone-a"},
+		{Member{Address: "10.0.0.1"}, "rack-1"},
+		{Member{Address: "10.0.0.3"}, "member:10.0.0.3"},
+	}
+	for _, test := range tests {
+		if got := FailureDomain(test.m); got != test.want {
+			t.Errorf("FailureDomain(%+v) = %v, want %v", test.m, got, test.want)
+		}
+	}
+}
+
+/*
+Nine members in three zones of three (neighbours by ID share one, so jump's run of successors would too): every strategy should put NUM_REPLICAS = 3 replicas in three different zones,
+without changing which member is first.
+*/
+func TestPlacementSpreadsAcrossDomains(t *testing.T) {
+	defer func(spread bool, replicas int) {
+		config.SPREAD_ACROSS_DOMAINS = spread
+		config.NUM_REPLICAS = replicas
+	}(config.SPREAD_ACROSS_DOMAINS, config.NUM_REPLICAS)
+	config.NUM_REPLICAS = 3
+	members := testMembers(9)
+	for i := range members {
+		members[i].Zone = fmt.Sprintf("zone-%v", i/3)
+	}
+	membership := NewMembershipSnapshot(1, members, Member{})
+	for _, strategy := range testStrategies() {
+		clustered := 0
+		for i := 0; i < 500; i++ {
+			name := fmt.Sprintf("file-%v", i)
+			config.SPREAD_ACROSS_DOMAINS = false
+			plain := strategy.Place(name, membership)
+			config.SPREAD_ACROSS_DOMAINS = true
+			spread := strategy.Place(name, membership)
+			zones := make(map[string]bool)
+			for _, m := range spread {
+				zones[m.Zone] = true
+			}
+			if len(zones) != 3 {
+				t.Fatalf("%v put %v in zones %v", strategy.Name(), name, zones)
+			}
+			if spread[0] != plain[0] {
+				t.Fatalf("%v: spreading moved the first replica of %v from %v to %v", strategy.Name(), name, plain[0].Member_Id, spread[0].Member_Id)
+			}
+			plainZones := make(map[string]bool)
+			for _, m := range plain {
+				plainZones[m.Zone] = true
+			}
+			if len(plainZones) < 3 {
+				clustered++
+			}
+		}
+		if clustered == 0 {
+			t.Errorf("%v never put two replicas in one zone without spreading, so this didn't test anything", strategy.Name())
+		}
+	}
+}
+
+func TestSpreadAcrossDomains(t *testing.T) {
+	defer func(spread bool) { config.SPREAD_ACROSS_DOMAINS = spread }(config.SPREAD_ACROSS_DOMAINS)
+	config.SPREAD_ACROSS_DOMAINS = true
+	members := []Member{
+		{Member_Id: "a1", Address: "10.0.0.1", Zone: "a"},
+		{Member_Id: "a2", Address: "10.0.0.2", Zone: "a"},
+		{Member_Id: "b1", Address: "10.0.0.3", Zone: "b"},
+		{Member_Id: "c1", Address: "10.0.0.4", Zone: "c"},
+	}
+	PublishMembership(members, members[0])
+	var replicas []*proto.ReplicaInfo
+	for _, m := range members {
+		replicas = append(replicas, &proto.ReplicaInfo{Name: m.Address, Memberid: m.Member_Id})
+	}
+	// Not in the membership: falls back to being its own domain, by address
+	replicas = append(replicas, &proto.ReplicaInfo{Name: "10.0.0.9", Memberid: "gone"})
+
+	tests := []struct {
+		n    int
+		want []string
+	}{
+		{2, []string{"a1", "b1"}},
+		{3, []string{"a1", "b1", "c1"}},
+		{4, []string{"a1", "b1", "c1", "gone"}},
+		{5, []string{"a1", "a2", "b1", "c1", "gone"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, rep := range SpreadAcrossDomains(replicas, test.n) {
+			got = append(got, rep.Memberid)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SpreadAcrossDomains(n = %v) = %v, want %v", test.n, got, test.want)
+		}
+	}
+}
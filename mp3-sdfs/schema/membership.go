@@ -0,0 +1,83 @@
+package schema
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+One version of the membership list. Snapshots are never modified once they're made, so whoever gets one can keep it
+and read it without any locks, and it can't change (or get sorted) under them halfway through a decision. Every time
+MP2 tells us something changed we publish a new one with the next Epoch.
+*/
+type MembershipSnapshot struct {
+	Epoch   uint64
+	Members []Member // Sorted by Member_Id
+	Self    Member
+	key     string
+}
+
+var currentMembership atomic.Value // *MembershipSnapshot
+var publishMtx sync.Mutex
+
+/*
+Makes a snapshot of members without publishing it (the simulator, for instance, places files over made up ones).
+members is copied, so the caller can keep using it.
+*/
+func NewMembershipSnapshot(epoch uint64, members []Member, self Member) *MembershipSnapshot {
+	sorted := append([]Member(nil), members...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Member_Id < sorted[j].Member_Id
+	})
+	var ids []string
+	for _, m := range sorted {
+		ids = append(ids, m.Member_Id+"@"+m.Address+"/"+m.Zone)
+	}
+	return &MembershipSnapshot{Epoch: epoch, Members: sorted, Self: self, key: strings.Join(ids, ",")}
+}
+
+/*
+The latest published membership. Before the first PublishMembership that's an empty one at epoch 0.
+*/
+func Membership() *MembershipSnapshot {
+	if snap, ok := currentMembership.Load().(*MembershipSnapshot); ok {
+		return snap
+	}
+	return NewMembershipSnapshot(0, nil, Member{})
+}
+
+/*
+Publishes members and self as the new membership, at the next epoch. If nothing that matters for placement changed
+(pings dropped don't), the current snapshot stays as it is.
+*/
+func PublishMembership(members []Member, self Member) *MembershipSnapshot {
+	publishMtx.Lock()
+	defer publishMtx.Unlock()
+	current := Membership()
+	snap := NewMembershipSnapshot(current.Epoch+1, members, self)
+	if snap.key == current.key && snap.Self == current.Self {
+		return current
+	}
+	currentMembership.Store(snap)
+	return snap
+}
+
+/*
+A string that's the same for two snapshots exactly when they have the same members, for caching things computed from
+them across epochs (and across unpublished snapshots, which don't have meaningful epochs).
+*/
+func (s *MembershipSnapshot) Key() string {
+	return s.key
+}
+
+func (s *MembershipSnapshot) Lookup(memberId string) (Member, bool) {
+	i := sort.Search(len(s.Members), func(i int) bool {
+		return s.Members[i].Member_Id >= memberId
+	})
+	if i < len(s.Members) && s.Members[i].Member_Id == memberId {
+		return s.Members[i], true
+	}
+	return Member{}, false
+}
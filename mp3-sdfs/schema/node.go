@@ -4,14 +4,9 @@ import (
 	"amogus/config"
 	"amogus/mp3util"
 	"amogus/proto"
-	"container/ring"
 	"crypto/sha256"
 	"encoding/hex"
-	"errors"
-	"fmt"
-	"sort"
 	"strconv"
-	"sync"
 )
 
 type Member struct {
@@ -19,118 +14,36 @@ type Member struct {
 	Address      string
 	PingsDropped int
 	Port         uint32
+	Zone         string // Failure domain, if MP2 knows it. See FailureDomain.
 }
 
-type MembershipList struct {
-	List     []Member
-	SelfNode Member
-	Mtx      sync.Mutex
-}
-
-var MemList MembershipList
-
 type CliArgs struct {
-	LocalFileName string
-	SdfsFileName  string
-	NumVersions   int
-	Bruhflag      bool
-}
-
-/**
- * CurrMasterNode
- *	The membership list from MP2 is NOT assumed to be
- *	sorted. But, the designated master node is the node in the
- *	membership with the min ID. Linear scan to find the smallest ID
- *
- *	NOTE: ASSUMES CALLER GRABS LOCK
- */
-func (ml MembershipList) CurrMasterNode() (Member, error) {
-	if len(ml.List) == 0 {
-		/* Empty membership list... */
-		mp3util.NodeLogger.Warn("Membership list is empty. Returning nil master...")
-		return Member{}, errors.New("Empty membership list, no master.")
-	}
-
-	masterNode := ml.List[0]
-	for _, m := range ml.List {
-		currMasterId := masterNode.Member_Id
-		membId := m.Member_Id
-		if membId == "" {
-			return Member{}, errors.New(fmt.Sprintf("Member ID corrupted: %v", m))
-		}
-
-		if membId < currMasterId {
-			masterNode = m
-		}
-	}
-
-	return masterNode, nil
+	LocalFileName     string
+	SdfsFileName      string // Path in the namespace
+	FileId            string // What replicas know SdfsFileName as. Filled in by the client from the master.
+	DestFileName      string // mv
+	NumVersions       int
+	Bruhflag          bool
+	Recursive         bool              // ls -R
+	Offset            int64             // getrange
+	Length            int64             // getrange
+	Prefix            string            // lsall
+	Glob              string            // lsall
+	After             string            // lsall: where the previous page left off
+	Limit             int               // lsall: page size
+	JSON              bool              // lsall
+	Version           int64             // stat, setattr, getfile --version, diffversions: which version (see fsys/version.go), 0 meaning the latest
+	Attrs             map[string]string // putfile: attributes for the new version. setattr: changes, "" removing a key
+	UpperVersionBound int64             // getfile --at: the newest version wanted (see fsys.VersionAt)
+	OtherVersion      int64             // diffversions: what to compare Version to
 }
 
 /*
+Runs the placement strategy picked in config (see placement.go) over the current membership.
 Note: does not need f.ContentHash.
 */
 func RunPartitioner(f *proto.FileInfo) ([]*proto.ReplicaInfo, error) {
-	mp3util.NodeLogger.Debug("Running partitioner for sdfsfilename: ", f.Sdfsname)
-	var replicaList []*proto.ReplicaInfo
-
-	/* Hash the sdfs name and truncate to RING_SIZE bits */
-	fileHashId := GetRingId(f.Sdfsname)
-	mp3util.NodeLogger.Debugf("SDFSname %v hashes to id: %x", f.Sdfsname, fileHashId)
-
-	memList := &MemList
-	memList.Mtx.Lock()
-	defer memList.Mtx.Unlock()
-
-	/* Sort the membership list by hash-ID in the ring */
-	memListSortedByHash := memList.List // TODO: Verify this actually makes a copy
-	sort.Slice(memListSortedByHash, func(i, j int) bool {
-		a := GetRingId(memListSortedByHash[i].Member_Id)
-		b := GetRingId(memListSortedByHash[j].Member_Id)
-		return a < b
-	})
-
-	/* Create ring buffer representing topology of nodes */
-	ringBuf := ring.New(len(memListSortedByHash))
-	currRing := ringBuf
-	for _, memb := range memListSortedByHash {
-		currRing.Value = memb
-		currRing = currRing.Next()
-	}
-
-	/* Debug loop */
-	currRing = ringBuf
-	for i := 0; i < ringBuf.Len(); i++ {
-		memb := currRing.Value.(Member)
-		mp3util.NodeLogger.Tracef("Node with id: %v hashes to %x", memb.Member_Id, GetRingId(memb.Member_Id))
-		currRing = currRing.Next()
-	}
-
-	/* Now iterate through each member in the sorted chord ring, and find the group of six
-	 * replicas that contain this file id */
-	currRing = ringBuf
-	for i := 0; i < ringBuf.Len(); i++ {
-		memb := currRing.Value.(Member)
-		if GetRingId(memb.Member_Id) >= fileHashId {
-			break
-		}
-		currRing = currRing.Next()
-	}
-
-	start := currRing
-	for i := 0; i < config.NUM_REPLICAS; i++ {
-		memb := currRing.Value.(Member)
-		mp3util.NodeLogger.Tracef("Target node containing file has id: %x", GetRingId(memb.Member_Id))
-		replicaList = append(replicaList, &proto.ReplicaInfo{Name: memb.Address, Port: memb.Port, Memberid: memb.Member_Id})
-		/* Fewer than 6 nodes in the system */
-		if currRing.Next() == start {
-			break
-		}
-		currRing = currRing.Next()
-	}
-
-	/* Return quorum */
-	return replicaList, nil
+	return Partition(ConfiguredPlacement(), f, Membership())
 }
 
 /**
@@ -0,0 +1,196 @@
+package schema
+
+import (
+	"amogus/config"
+	"amogus/mp3util"
+	"amogus/proto"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+/*
+Decides where files live. Place gets a file name and a snapshot of the membership, and returns the members that should
+hold the file: NUM_REPLICAS of them (or all of them, if there are fewer), most preferred first. It has to be
+deterministic, since every node runs it on its own and they all have to agree.
+*/
+type PlacementStrategy interface {
+	Name() string
+	Place(sdfsFileName string, membership *MembershipSnapshot) []Member
+}
+
+func NewPlacementStrategy(name string) (PlacementStrategy, error) {
+	switch name {
+	case "chord":
+		return &ChordPlacement{}, nil
+	case "rendezvous":
+		return RendezvousPlacement{}, nil
+	case "jump":
+		return JumpPlacement{}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown placement strategy %v", name))
+}
+
+var configuredPlacement struct {
+	strategy PlacementStrategy
+	mtx      sync.Mutex
+}
+
+/*
+The strategy config.PLACEMENT_STRATEGY names. An unknown one is a typo in the config, so we complain and go with chord.
+*/
+func ConfiguredPlacement() PlacementStrategy {
+	configuredPlacement.mtx.Lock()
+	defer configuredPlacement.mtx.Unlock()
+	if configuredPlacement.strategy != nil && configuredPlacement.strategy.Name() == config.PLACEMENT_STRATEGY {
+		return configuredPlacement.strategy
+	}
+	strategy, err := NewPlacementStrategy(config.PLACEMENT_STRATEGY)
+	if err != nil {
+		mp3util.NodeLogger.Errorf("%v, placing files with chord instead", err)
+		strategy = &ChordPlacement{}
+	}
+	configuredPlacement.strategy = strategy
+	return strategy
+}
+
+/*
+Runs strategy for f over membership.
+*/
+func Partition(strategy PlacementStrategy, f *proto.FileInfo, membership *MembershipSnapshot) ([]*proto.ReplicaInfo, error) {
+	mp3util.NodeLogger.Debugf("Running %v partitioner for sdfsfilename: %v at membership epoch %v", strategy.Name(), f.Sdfsname, membership.Epoch)
+	if len(membership.Members) == 0 {
+		return nil, errors.New("Empty membership list, nowhere to put anything.")
+	}
+
+	var replicaList []*proto.ReplicaInfo
+	for _, memb := range strategy.Place(f.Sdfsname, membership) {
+		mp3util.NodeLogger.Tracef("Target node containing file has id: %v", memb.Member_Id)
+		replicaList = append(replicaList, &proto.ReplicaInfo{Name: memb.Address, Port: memb.Port, Memberid: memb.Member_Id})
+	}
+	return replicaList, nil
+}
+
+/*
+How much data m should get relative to the others: its entry in MEMBER_WEIGHTS (by address, since member IDs change
+every time a node reboots), or 1.
+*/
+func MemberWeight(m Member) float64 {
+	if weight, ok := config.MEMBER_WEIGHTS[m.Address]; ok {
+		return weight
+	}
+	return 1
+}
+
+func placementHash(s string) uint64 {
+	h := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+/*
+Rendezvous (highest random weight) hashing: every member gets a score for the file, and the highest scores win. A join
+or leave only moves the files whose winners changed, i.e. about 1/N of them. Scores are weighted so that a member with
+twice the weight wins twice as often.
+*/
+type RendezvousPlacement struct{}
+
+func (RendezvousPlacement) Name() string {
+	return "rendezvous"
+}
+
+func (RendezvousPlacement) Place(sdfsFileName string, membership *MembershipSnapshot) []Member {
+	scores := make(map[string]float64)
+	for _, m := range membership.Members {
+		// A uniform number in (0, 1) from the hash, turned into a score such that P(m wins) is proportional to its weight.
+		u := (float64(placementHash(sdfsFileName+"\x00"+m.Member_Id)>>11) + 0.5) / (1 << 53)
+		scores[m.Member_Id] = -MemberWeight(m) / math.Log(u)
+	}
+	candidates := append([]Member(nil), membership.Members...)
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := scores[candidates[i].Member_Id], scores[candidates[j].Member_Id]
+		if a != b {
+			return a > b
+		}
+		return candidates[i].Member_Id < candidates[j].Member_Id
+	})
+	return pickReplicas(candidates, config.NUM_REPLICAS)
+}
+
+/*
+Jump consistent hashing (Lamping & Veach): no state at all, and perfectly even. But it numbers the members 0..N-1 (by
+ID here), and only adding or removing the LAST one moves the minimum, so a member in the middle leaving shuffles a lot.
+Weights don't apply. The other replicas are the members after the first one, in that numbering.
+*/
+type JumpPlacement struct{}
+
+func (JumpPlacement) Name() string {
+	return "jump"
+}
+
+func (JumpPlacement) Place(sdfsFileName string, membership *MembershipSnapshot) []Member {
+	sorted := membership.Members // Already sorted by ID
+	if len(sorted) == 0 {
+		return nil
+	}
+	first := jumpHash(placementHash(sdfsFileName), len(sorted))
+	candidates := append(append([]Member(nil), sorted[first:]...), sorted[:first]...)
+	return pickReplicas(candidates, config.NUM_REPLICAS)
+}
+
+func jumpHash(key uint64, numBuckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+/*
+How much data a member is in charge of, according to some membership snapshot.
+*/
+type Ownership struct {
+	Member  Member
+	Weight  float64
+	Target  float64 // The share of the data it's supposed to get, i.e. its share of the total weight
+	Primary float64 // The share of files it's the first replica for
+	Replica float64 // The share of files it holds a replica of. These add up to min(NUM_REPLICAS, #members).
+}
+
+/*
+Works out every member's Ownership under the configured strategy, sorted by member ID. Strategies don't have to be
+anything we can do the math on, so this places OWNERSHIP_SAMPLES made up file names and counts.
+*/
+func OwnershipReport(membership *MembershipSnapshot) []Ownership {
+	report := make(map[string]*Ownership)
+	totalWeight := 0.0
+	for _, m := range membership.Members {
+		report[m.Member_Id] = &Ownership{Member: m, Weight: MemberWeight(m)}
+		totalWeight += MemberWeight(m)
+	}
+	strategy := ConfiguredPlacement()
+	share := 1 / float64(config.OWNERSHIP_SAMPLES)
+	for i := 0; i < config.OWNERSHIP_SAMPLES; i++ {
+		for j, m := range strategy.Place(fmt.Sprintf("ownership-sample-%v", i), membership) {
+			if j == 0 {
+				report[m.Member_Id].Primary += share
+			}
+			report[m.Member_Id].Replica += share
+		}
+	}
+
+	var ownership []Ownership
+	for _, o := range report {
+		o.Target = o.Weight / totalWeight
+		ownership = append(ownership, *o)
+	}
+	sort.Slice(ownership, func(i, j int) bool {
+		return ownership[i].Member.Member_Id < ownership[j].Member.Member_Id
+	})
+	return ownership
+}
@@ -0,0 +1,189 @@
+package schema
+
+import (
+	"amogus/config"
+	"amogus/mp3util"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	mp3util.ConfigureLogger("test", "error", false)
+	os.Exit(m.Run())
+}
+
+func testMembers(n int) []Member {
+	var members []Member
+	for i := 0; i < n; i++ {
+		members = append(members, Member{Member_Id: fmt.Sprintf("member-%02d", i), Address: fmt.Sprintf("10.0.0.%v", i)})
+	}
+	return members
+}
+
+func testStrategies() []PlacementStrategy {
+	return []PlacementStrategy{&ChordPlacement{}, RendezvousPlacement{}, JumpPlacement{}}
+}
+
+/*
+Share of numFiles files each member is the first replica for, relative to what it would be if they were all the same.
+*/
+func primaryLoad(strategy PlacementStrategy, membership *MembershipSnapshot, numFiles int) map[string]float64 {
+	load := make(map[string]float64)
+	for _, m := range membership.Members {
+		load[m.Member_Id] = 0
+	}
+	for i := 0; i < numFiles; i++ {
+		load[strategy.Place(fmt.Sprintf("file-%v", i), membership)[0].Member_Id]++
+	}
+	for id := range load {
+		load[id] *= float64(len(membership.Members)) / float64(numFiles)
+	}
+	return load
+}
+
+func loadSpread(load map[string]float64) (lo float64, hi float64) {
+	lo = math.Inf(1)
+	for _, l := range load {
+		lo = math.Min(lo, l)
+		hi = math.Max(hi, l)
+	}
+	return lo, hi
+}
+
+func TestPlaceReturnsDistinctReplicas(t *testing.T) {
+	for _, strategy := range testStrategies() {
+		for _, n := range []int{1, 3, config.NUM_REPLICAS, 12} {
+			membership := NewMembershipSnapshot(1, testMembers(n), Member{})
+			want := n
+			if want > config.NUM_REPLICAS {
+				want = config.NUM_REPLICAS
+			}
+			for i := 0; i < 200; i++ {
+				name := fmt.Sprintf("file-%v", i)
+				replicas := strategy.Place(name, membership)
+				if len(replicas) != want {
+					t.Fatalf("%v with %v members placed %v on %v replicas, want %v", strategy.Name(), n, name, len(replicas), want)
+				}
+				seen := make(map[string]bool)
+				for _, m := range replicas {
+					if seen[m.Member_Id] {
+						t.Fatalf("%v placed %v on %v twice", strategy.Name(), name, m.Member_Id)
+					}
+					seen[m.Member_Id] = true
+				}
+				again := strategy.Place(name, NewMembershipSnapshot(2, testMembers(n), Member{}))
+				for j := range replicas {
+					if again[j] != replicas[j] {
+						t.Fatalf("%v placed %v differently on the same membership: %v then %v", strategy.Name(), name, replicas, again)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPlacementDistribution(t *testing.T) {
+	membership := NewMembershipSnapshot(1, testMembers(10), Member{})
+	tests := []struct {
+		strategy PlacementStrategy
+		lo, hi   float64 // Bounds on every member's load relative to the mean
+	}{
+		{&ChordPlacement{}, 0.6, 1.4},
+		{RendezvousPlacement{}, 0.9, 1.1},
+		{JumpPlacement{}, 0.9, 1.1},
+	}
+	for _, test := range tests {
+		lo, hi := loadSpread(primaryLoad(test.strategy, membership, 20000))
+		if lo < test.lo || hi > test.hi {
+			t.Errorf("%v: member loads range over [%.2f, %.2f] of the mean, want within [%.2f, %.2f]", test.strategy.Name(), lo, hi, test.lo, test.hi)
+		}
+	}
+}
+
+func TestVnodesEvenOutChord(t *testing.T) {
+	defer func(vnodes int) { config.VNODES_PER_MEMBER = vnodes }(config.VNODES_PER_MEMBER)
+	membership := NewMembershipSnapshot(1, testMembers(10), Member{})
+	chord := &ChordPlacement{}
+
+	config.VNODES_PER_MEMBER = 1
+	m := membership.Members[3]
+	if ids := TokenIds(m); len(ids) != 1 || ids[0] != GetRingId(m.Member_Id) {
+		t.Errorf("with one vnode %v should have the single token %x, got %x", m.Member_Id, GetRingId(m.Member_Id), ids)
+	}
+	plainLo, plainHi := loadSpread(primaryLoad(chord, membership, 20000))
+
+	config.VNODES_PER_MEMBER = 64
+	if n := len(TokenIds(m)); n != 64 {
+		t.Errorf("with 64 vnodes %v has %v tokens", m.Member_Id, n)
+	}
+	if ids := TokenIds(m); ids[0] != GetRingId(m.Member_Id) {
+		t.Errorf("the first token should stay where plain chord put the member")
+	}
+	lo, hi := loadSpread(primaryLoad(chord, membership, 20000))
+	if hi-lo >= plainHi-plainLo {
+		t.Errorf("64 vnodes spread load over [%.2f, %.2f], no better than one vnode's [%.2f, %.2f]", lo, hi, plainLo, plainHi)
+	}
+	if lo < 0.7 || hi > 1.3 {
+		t.Errorf("64 vnodes spread load over [%.2f, %.2f] of the mean", lo, hi)
+	}
+}
+
+func TestMemberWeights(t *testing.T) {
+	defer func(weights map[string]float64, vnodes int) {
+		config.MEMBER_WEIGHTS = weights
+		config.VNODES_PER_MEMBER = vnodes
+	}(config.MEMBER_WEIGHTS, config.VNODES_PER_MEMBER)
+	members := testMembers(6)
+	heavy := members[2]
+	config.MEMBER_WEIGHTS = map[string]float64{heavy.Address: 2, members[4].Address: 0.01}
+	config.VNODES_PER_MEMBER = 64
+
+	if n := MemberTokens(heavy); n != 128 {
+		t.Errorf("a member with weight 2 got %v tokens, want 128", n)
+	}
+	if n := MemberTokens(members[4]); n != 1 {
+		t.Errorf("a member with a tiny weight got %v tokens, want at least 1", n)
+	}
+	if w := MemberWeight(members[0]); w != 1 {
+		t.Errorf("a member without a weight weighs %v, want 1", w)
+	}
+
+	membership := NewMembershipSnapshot(1, members, Member{})
+	for _, strategy := range []PlacementStrategy{&ChordPlacement{}, RendezvousPlacement{}} {
+		load := primaryLoad(strategy, membership, 20000)
+		others := 0.0
+		for _, m := range []Member{members[0], members[1], members[3], members[5]} {
+			others += load[m.Member_Id] / 4
+		}
+		if ratio := load[heavy.Member_Id] / others; ratio < 1.6 || ratio > 2.4 {
+			t.Errorf("%v: weight 2 member got %.2fx the load of the weight 1 ones, want about 2x", strategy.Name(), ratio)
+		}
+		if load[members[4].Member_Id] > 0.1 {
+			t.Errorf("%v: weight 0.01 member got %.2f of the mean load", strategy.Name(), load[members[4].Member_Id])
+		}
+	}
+}
+
+func TestMovementOnJoin(t *testing.T) {
+	before := testMembers(10)
+	after := append(testMembers(10), Member{Member_Id: "member-99", Address: "10.0.0.99"}) // Sorts last, which is what jump wants
+	tests := []struct {
+		strategy PlacementStrategy
+		slack    float64 // How many times the ideal it may move
+	}{
+		{&ChordPlacement{}, 1.5},
+		{RendezvousPlacement{}, 1.2},
+		{JumpPlacement{}, 1.5}, // Only first replicas move the minimum, the runs of successors after them shift too
+	}
+	for _, test := range tests {
+		report := SimulateMovement(test.strategy, before, after, 5000)
+		if report.Copies != 5000*config.NUM_REPLICAS {
+			t.Errorf("%v: %v copies of 5000 files", test.strategy.Name(), report.Copies)
+		}
+		if float64(report.Moved) > test.slack*float64(report.Ideal) {
+			t.Errorf("%v: one join moved %v copies, ideal is %v", test.strategy.Name(), report.Moved, report.Ideal)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+)
+
+/*
+What happened to placement when the membership went from one list to another, for the simulation harness
+(main/placementsim.go).
+*/
+type MovementReport struct {
+	Strategy string
+	Copies   int     // (file, replica) pairs after the change
+	Moved    int     // ...that weren't there before, i.e. copies that have to be shipped to a new replica
+	Ideal    int     // The least any strategy could get away with moving: the share of the members that came or went
+	MaxLoad  float64 // Most copies on one member after the change, relative to the mean
+	MinLoad  float64 // Fewest copies on one member after the change, relative to the mean
+}
+
+func (m MovementReport) MovedFraction() float64 {
+	return float64(m.Moved) / float64(m.Copies)
+}
+
+/*
+Places numFiles made-up files with strategy over before and after, and compares.
+*/
+func SimulateMovement(strategy PlacementStrategy, before []Member, after []Member, numFiles int) MovementReport {
+	report := MovementReport{Strategy: strategy.Name()}
+	beforeSnapshot := NewMembershipSnapshot(0, before, Member{})
+	afterSnapshot := NewMembershipSnapshot(1, after, Member{})
+	load := make(map[string]int)
+	for _, m := range after {
+		load[m.Member_Id] = 0
+	}
+	// All of before first, then all of after, so strategies that cache per membership (chord) don't rebuild every time.
+	had := make([]map[string]bool, numFiles)
+	for i := range had {
+		had[i] = make(map[string]bool)
+		for _, m := range strategy.Place(fmt.Sprintf("sim-file-%v", i), beforeSnapshot) {
+			had[i][m.Member_Id] = true
+		}
+	}
+	for i := range had {
+		for _, m := range strategy.Place(fmt.Sprintf("sim-file-%v", i), afterSnapshot) {
+			report.Copies++
+			load[m.Member_Id]++
+			if !had[i][m.Member_Id] {
+				report.Moved++
+			}
+		}
+	}
+
+	/* Whoever joined has to get their share from somewhere, and whoever left had a share that has to go somewhere */
+	inBefore := make(map[string]bool)
+	for _, m := range before {
+		inBefore[m.Member_Id] = true
+	}
+	churn := 0
+	for _, m := range after {
+		if !inBefore[m.Member_Id] {
+			churn++
+		}
+		delete(inBefore, m.Member_Id)
+	}
+	churn += len(inBefore)
+	biggest := len(after)
+	if len(before) > biggest {
+		biggest = len(before)
+	}
+	report.Ideal = int(math.Round(float64(report.Copies) * math.Min(1, float64(churn)/float64(biggest))))
+
+	mean := float64(report.Copies) / float64(len(after))
+	report.MinLoad = math.Inf(1)
+	for _, n := range load {
+		report.MaxLoad = math.Max(report.MaxLoad, float64(n)/mean)
+		report.MinLoad = math.Min(report.MinLoad, float64(n)/mean)
+	}
+	return report
+}
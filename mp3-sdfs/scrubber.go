@@ -0,0 +1,174 @@
+package amogus
+
+import (
+	"amogus/config"
+	"amogus/fsys"
+	"amogus/mp3util"
+	"amogus/proto"
+	"amogus/schema"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+/*
+Runs Scrub every config.SCRUB_PERIOD, on its own, since a pass takes as long as its throttled reads do and the
+ReplicaDaemon's garbage collection and replication shouldn't have to wait for it.
+*/
+func (r *ReplicaService) ScrubDaemon() {
+	ticker := time.NewTicker(config.SCRUB_PERIOD)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := r.Scrub()
+		if err != nil {
+			mp3util.NodeLogger.Warn("Failed to scrub: ", err)
+		}
+	}
+}
+
+/*
+The scrubber walks every version we store, a slice at a time (see config.SCRUB_BYTES_PER_PASS), re-reading it slowly
+(config.SCRUB_BYTES_PER_SECOND) and checking it against what was registered. Where it left off is kept in
+r.scrubCursor, so consecutive passes pick up where the last one stopped and eventually cover everything, then wrap
+around.
+
+When a version has rotted, we ask the other replicas of that file (per schema.RunPartitioner) for their copy of that
+exact version, and only take it if it hashes to what ours was supposed to. If nobody has a good copy, ours gets
+quarantined so that at least we stop serving and replicating garbage.
+*/
+func (r *ReplicaService) Scrub() error {
+	versionSet, err := r.sdfs.ListStoredSDFSFilesAllVersions()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // Nothing stored, nothing to scrub.
+		}
+		return err
+	}
+	var versions []fsys.SDFSFile
+	for name, vs := range versionSet {
+		for v := range vs {
+			versions = append(versions, fsys.SDFSFile{SDFSFileName: name, Version: v})
+		}
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].SDFSFileName != versions[j].SDFSFileName {
+			return versions[i].SDFSFileName < versions[j].SDFSFileName
+		}
+		return versions[i].Version < versions[j].Version
+	})
+	// First version strictly after the cursor.
+	start := sort.Search(len(versions), func(i int) bool {
+		if versions[i].SDFSFileName != r.scrubCursor.SDFSFileName {
+			return versions[i].SDFSFileName > r.scrubCursor.SDFSFileName
+		}
+		return versions[i].Version > r.scrubCursor.Version
+	})
+
+	var budget int64
+	for i := 0; i < len(versions) && budget < config.SCRUB_BYTES_PER_PASS; i++ {
+		idx := (start + i) % len(versions)
+		if idx == 0 && i > 0 {
+			mp3util.NodeLogger.Debug("Scrubber finished a full pass over local storage.")
+		}
+		v := versions[idx]
+		r.scrubCursor = v
+		nbytes, err := r.sdfs.ScrubVersion(v.SDFSFileName, v.Version, config.SCRUB_BYTES_PER_SECOND)
+		budget += nbytes
+		if err == nil || os.IsNotExist(err) {
+			continue
+		}
+		if err != fsys.ErrContentHashMismatch {
+			mp3util.NodeLogger.Warnf("Scrubber couldn't check %v @ %v: %v", v.SDFSFileName, v.Version, err)
+			continue
+		}
+		mp3util.NodeLogger.Errorf("Scrubber found %v @ %v corrupted! Repairing...", v.SDFSFileName, v.Version)
+		err = r.RepairVersion(v.SDFSFileName, v.Version)
+		if err != nil {
+			mp3util.NodeLogger.Errorf("Couldn't repair %v @ %v from any replica, quarantining it. Error: %v", v.SDFSFileName, v.Version, err)
+			r.sdfs.QuarantineVersion(v.SDFSFileName, v.Version, "corrupted and no replica had a good copy")
+		}
+	}
+	return nil
+}
+
+/*
+Replaces our copy of sdfsFileName @ version with a healthy one from one of its other replicas.
+*/
+func (r *ReplicaService) RepairVersion(sdfsFileName string, version int64) error {
+	meta, err := r.sdfs.ReadVersionMetadata(sdfsFileName, version)
+	if err != nil {
+		return err // Without the expected hash, we can't tell a good copy from a bad one.
+	}
+	record, _ := r.sdfs.VersionRecord(sdfsFileName, version)
+	replicas, err := schema.RunPartitioner(&proto.FileInfo{Sdfsname: sdfsFileName})
+	if err != nil {
+		return err
+	}
+	self := selfReplicaMetadata()
+	for _, rep := range replicas {
+		peer := NewReplicaMetadata(rep)
+		if peer == self {
+			continue
+		}
+		err = r.fetchVersionFromReplica(sdfsFileName, version, meta.ContentHash, record.Writer, meta.VersionAttributes, peer)
+		if err != nil {
+			mp3util.NodeLogger.Warnf("Replica %v couldn't give us a good copy of %v @ %v: %v", peer.MemberId, sdfsFileName, version, err)
+			continue
+		}
+		mp3util.NodeLogger.Infof("Repaired %v @ %v from replica %v.", sdfsFileName, version, peer.MemberId)
+		return nil
+	}
+	return errors.New(fmt.Sprintf("no replica has an intact copy of %v @ %v", sdfsFileName, version))
+}
+
+/*
+Downloads exactly sdfsFileName @ version from peer, the same way a client get does, and restores it over ours.
+*/
+func (r *ReplicaService) fetchVersionFromReplica(sdfsFileName string, version int64, expectedContentHash string, writer string, attrs fsys.VersionAttributes, peer ReplicaMetadata) error {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%v", peer.Address, config.MP3_REPLICA_TCP_PORT), config.DEFAULT_TCP_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = (&fsys.TCPChannelRequest{
+		RequestType:       fsys.CLIENT_REQ_FILE_DATA,
+		SDFSFileName:      sdfsFileName,
+		UpperVersionBound: version,
+	}).Send(conn)
+	if err != nil {
+		return err
+	}
+	resp, err := fsys.RecvTCPChannelResponse(conn)
+	if err != nil {
+		return err
+	}
+	if resp.SDFSFileVersion != version {
+		return errors.New(fmt.Sprintf("replica only has up to version %v", resp.SDFSFileVersion))
+	}
+	if resp.FileContentHash != expectedContentHash {
+		return fsys.ErrContentHashMismatch // Its metadata doesn't even agree with ours, don't bother downloading.
+	}
+	tmpfile, err := r.sdfs.DumpBytesToTmpfile(&io.LimitedReader{R: conn, N: resp.ReturningSDFSFileSize})
+	if err != nil {
+		return err
+	}
+	return r.sdfs.RestoreTmpfileToSDFS(tmpfile, expectedContentHash, version, sdfsFileName, fsys.VersionOrigin{
+		Writer: writer,
+		Reason: fmt.Sprintf("repaired by scrubber from replica %v", peer.MemberId),
+	}, attrs)
+}
+
+func selfReplicaMetadata() ReplicaMetadata {
+	return replicaMetadataOf(schema.Membership().Self)
+}
+
+func replicaMetadataOf(m schema.Member) ReplicaMetadata {
+	return ReplicaMetadata{Address: m.Address, MemberId: m.Member_Id, Port: m.Port}
+}